@@ -1,16 +1,28 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"openapi-validation-example/generated"
+	"openapi-validation-example/internal/handlers"
+	"openapi-validation-example/internal/server"
+	"openapi-validation-example/pkg/auth"
 	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/metrics"
+	"openapi-validation-example/pkg/response"
 	"openapi-validation-example/pkg/validation"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -28,69 +40,200 @@ func NewUserHandler(db *database.DatabaseService) *UserHandler {
 
 // CreateUser implements the generated.ServerInterface.CreateUser method
 func (h *UserHandler) CreateUser(ctx echo.Context) error {
-	var rawBody map[string]interface{}
-	if err := ctx.Bind(&rawBody); err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
+	contentType, err := handlers.Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	rawBody, err := handlers.BindUserBody(ctx)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
 			"error": "Invalid JSON format",
 		})
 	}
 
-	var userReq generated.UserRequest
-	reqBytes, _ := json.Marshal(rawBody)
-	if err := json.Unmarshal(reqBytes, &userReq); err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
+	userReq, additionalProps, err := handlers.ExtractAdditionalProps(rawBody)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
 			"error": "Invalid request format",
 		})
 	}
 
-	knownFields := map[string]bool{
-		"email":     true,
-		"age":       true,
-		"name":      true,
-		"bio":       true,
-		"is_active": true,
+	if err := handlers.ValidateAge(userReq.Age); err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
 	}
 
-	additionalProps := make(map[string]interface{})
-	for key, value := range rawBody {
-		if !knownFields[key] {
-			additionalProps[key] = value
+	var (
+		user    *generated.User
+		created = true
+	)
+	if key := ctx.Request().Header.Get(handlers.IdempotencyKeyHeader); key != "" {
+		if len(key) > handlers.MaxIdempotencyKeyLength {
+			return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("%s must be at most %d characters", handlers.IdempotencyKeyHeader, handlers.MaxIdempotencyKeyLength),
+			})
 		}
+		user, created, err = h.db.CreateUserIdempotentContext(handlers.RequestContext(ctx), key, userReq, additionalProps)
+	} else {
+		user, err = h.db.CreateUserContext(handlers.RequestContext(ctx), userReq, additionalProps)
 	}
-
-	user, err := h.db.CreateUser(userReq, additionalProps)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		if errors.Is(err, jobs.ErrQueueFull) {
+			return handlers.RespondQueueFull(ctx)
+		}
+		if errors.Is(err, database.ErrEmailTaken) {
+			return handlers.RespondEmailTaken(ctx)
+		}
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to create user: %v", err),
 		})
 	}
 
-	return ctx.JSON(http.StatusCreated, user)
+	if !created {
+		return handlers.RespondUser(ctx, http.StatusOK, contentType, user, nil)
+	}
+
+	return handlers.RespondUser(ctx, http.StatusCreated, contentType, user, nil)
+}
+
+// CreateUsersBatch implements the generated.ServerInterface.CreateUsersBatch method
+func (h *UserHandler) CreateUsersBatch(ctx echo.Context, params generated.CreateUsersBatchParams) error {
+	return handlers.CreateUsersBatch(ctx, params, func(users []database.BatchUser, allOrNothing bool) ([]database.BatchResult, error) {
+		return h.db.CreateUsersContext(handlers.RequestContext(ctx), users, allOrNothing)
+	})
 }
 
 // GetUserById implements the generated.ServerInterface.GetUserById method
 func (h *UserHandler) GetUserById(ctx echo.Context, id int64) error {
-	user, err := h.db.GetUserByID(id)
+	contentType, err := handlers.Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, additionalProps, err := h.db.GetUserByIDWithAdditionalProps(id)
 	if err != nil {
-		if err.Error() == "user not found" {
-			return ctx.JSON(http.StatusNotFound, map[string]string{
+		if errors.Is(err, database.ErrUserNotFound) {
+			return response.JSON(ctx, http.StatusNotFound, map[string]string{
 				"error": "User not found",
 			})
 		}
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to get user: %v", err),
 		})
 	}
 
-	return ctx.JSON(http.StatusOK, user)
+	return handlers.RespondUser(ctx, http.StatusOK, contentType, user, additionalProps)
+}
+
+// ListUsers implements the generated.ServerInterface.ListUsers method
+func (h *UserHandler) ListUsers(ctx echo.Context, params generated.ListUsersParams) error {
+	limit, offset := handlers.ListPage(params)
+
+	users, total, err := h.db.ListUsers(limit, offset)
+	if err != nil {
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to list users: %v", err),
+		})
+	}
+
+	return response.JSON(ctx, http.StatusOK, generated.UserList{
+		Users: users,
+		Total: total,
+	})
+}
+
+// DeleteUser implements the generated.ServerInterface.DeleteUser method
+func (h *UserHandler) DeleteUser(ctx echo.Context, id int64) error {
+	if err := h.db.DeleteUserContext(handlers.RequestContext(ctx), id); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return response.JSON(ctx, http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		}
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to delete user: %v", err),
+		})
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
 }
 
-func createApp(validationMode string) (*echo.Echo, error) {
+// createApp builds the server on db. The job queue settings below apply to
+// whichever jobs.JobQueue db uses; a setting the queue doesn't support fails
+// startup instead of being ignored.
+func createApp(validationMode string, db *database.DatabaseService) (*echo.Echo, error) {
 	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
 
+	// SERVER_NAME is sent in the Server header of JSON responses
+	response.ServerName = os.Getenv("SERVER_NAME")
+
+	// Accepts the client's X-Request-ID or generates one; it is logged,
+	// echoed in the response and recorded on the jobs the request enqueues
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
+	// CORS_ALLOWED_ORIGINS lets browser apps on those origins call the API.
+	// Preflight requests are answered here, before authentication and
+	// validation.
+	corsConfig, corsEnabled, err := server.CORSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if corsEnabled {
+		e.Use(middleware.CORSWithConfig(corsConfig))
+	}
+
+	// GZIP=1 compresses larger responses. It wraps metrics, authentication
+	// and validation, so response validation sees the uncompressed body.
+	gzipConfig, gzipEnabled, err := server.GzipConfig()
+	if err != nil {
+		return nil, err
+	}
+	if gzipEnabled {
+		e.Use(middleware.GzipWithConfig(gzipConfig))
+	}
+
+	// Metrics wrap validation so rejected requests are counted too
+	serverMetrics := metrics.New()
+	e.Use(serverMetrics.Middleware())
+	e.GET(metrics.Path, serverMetrics.Handler())
+
+	// MAX_BODY_SIZE (default 1M) caps request bodies; larger ones get 413
+	// before validation or a handler reads them
+	bodyLimit, err := server.BodyLimit()
+	if err != nil {
+		return nil, err
+	}
+	e.Use(middleware.BodyLimit(bodyLimit))
+
+	// MAX_CONCURRENT_PER_IP caps each client's requests in flight; the
+	// excess gets 429
+	maxConcurrent, limitConcurrency, err := server.ConcurrencyLimitFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if limitConcurrency {
+		e.Use(server.NewConcurrencyLimiter(maxConcurrent).Middleware(handlers.HealthzPath, handlers.ReadyzPath, metrics.Path))
+	}
+
+	// API_KEYS or API_KEYS_FILE require an X-API-Key header on every route
+	// except the health checks and metrics
+	apiKeys, err := auth.LoadKeySet()
+	if err != nil {
+		return nil, err
+	}
+	authenticate := openapi3filter.NoopAuthenticationFunc
+	if apiKeys.Enabled() {
+		e.Use(apiKeys.Middleware(handlers.HealthzPath, handlers.ReadyzPath, metrics.Path))
+		authenticate = apiKeys.AuthenticationFunc()
+	} else {
+		log.Println("API key authentication disabled: set API_KEYS or API_KEYS_FILE to enable it")
+	}
+
 	var specFile string
 	switch validationMode {
 	case "flexible":
@@ -100,17 +243,86 @@ func createApp(validationMode string) (*echo.Echo, error) {
 	default:
 		specFile = "openapi.yaml"
 	}
+	// OPENAPI_SPEC overrides the mode's spec; a missing or invalid one stops
+	// startup here
+	specFile = server.SpecPath(specFile)
 
-	validationMiddleware, err := validation.NewValidationMiddleware(specFile)
+	// VALIDATION_TIMING reports validation time in a response header;
+	// responses are validated in dev and staging (APP_ENV, VALIDATE_RESPONSES).
+	// Strict mode names every unexpected field in its 422, and
+	// VALIDATION_ALL_ERRORS lists every failure instead of the first.
+	validationMiddleware, err := validation.NewValidationMiddlewareWithOptions(specFile, validation.Options{
+		ReportDuration:      os.Getenv("VALIDATION_TIMING") != "",
+		AuthenticationFunc:  authenticate,
+		ValidateResponses:   validation.ResponseValidationFromEnv(),
+		OnValidationError:   serverMetrics.ValidationError,
+		ReportUnknownFields: validationMode == "strict",
+		MultiError:          os.Getenv("VALIDATION_ALL_ERRORS") != "",
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize validation middleware: %w", err)
 	}
 
 	e.Use(validationMiddleware.Validate())
 
-	db, err := database.NewDatabaseService("users.db")
+	// Optionally check that the generated types still match the spec
+	if os.Getenv("SPEC_SELF_TEST") != "" {
+		validation.SelfTest(specFile, log.Default())
+	}
+
+	jobQueue := db.JobQueue()
+
+	// Optionally cache job stats, shared by metrics scrapes and the admin
+	// endpoint; main refreshes them in the background until shutdown
+	if ttlStr := os.Getenv("JOB_STATS_CACHE_TTL"); ttlStr != "" {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JOB_STATS_CACHE_TTL %q: %w", ttlStr, err)
+		}
+		cacher, ok := jobQueue.(jobs.StatsCacher)
+		if !ok {
+			return nil, fmt.Errorf("JOB_STATS_CACHE_TTL is set but the job queue has no stats cache")
+		}
+		cacher.SetStatsCacheTTL(ttl)
+	}
+
+	// Optionally cap pending jobs; creates are rejected with 503 at the cap
+	if maxStr := os.Getenv("JOB_QUEUE_MAX_PENDING"); maxStr != "" {
+		max, err := strconv.Atoi(maxStr)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("invalid JOB_QUEUE_MAX_PENDING %q", maxStr)
+		}
+		limiter, ok := jobQueue.(jobs.PendingLimiter)
+		if !ok {
+			return nil, fmt.Errorf("JOB_QUEUE_MAX_PENDING is set but the job queue cannot cap pending jobs")
+		}
+		limiter.SetMaxPendingJobs(max)
+	}
+
+	if err := serverMetrics.RegisterJobQueue(jobQueue); err != nil {
+		return nil, fmt.Errorf("failed to register job queue metrics: %w", err)
+	}
+
+	// READY_MAX_PENDING_JOBS and READY_MAX_PENDING_AGE also fail /readyz
+	// while the job queue is backed up
+	readinessChecks := handlers.DatabaseReadinessChecks(db)
+	queueThresholds, err := handlers.QueueThresholdsFromEnv()
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+		return nil, err
+	}
+	if queueThresholds.Enabled() {
+		if db.GetJobQueue() == nil {
+			return nil, fmt.Errorf("READY_MAX_PENDING_JOBS and READY_MAX_PENDING_AGE need the SQLite job queue")
+		}
+		readinessChecks = append(readinessChecks, handlers.QueueBacklogCheck(db.GetJobQueue(), queueThresholds))
+	}
+	handlers.RegisterHealthRoutes(e, readinessChecks...)
+
+	handlers.RegisterAdminRoutes(e, db)
+
+	// POST /jobs/status looks jobs up in the SQLite job queue
+	if db.GetJobQueue() != nil {
+		handlers.RegisterJobRoutes(e, db.GetJobQueue())
 	}
 
 	userHandler := NewUserHandler(db)
@@ -127,7 +339,12 @@ func main() {
 		validationMode = "default"
 	}
 
-	e, err := createApp(validationMode)
+	db, err := database.NewDatabaseService("users.db")
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+
+	e, err := createApp(validationMode, db)
 	if err != nil {
 		log.Fatal("Failed to create app:", err)
 	}
@@ -144,7 +361,34 @@ func main() {
 	fmt.Println("  VALIDATION_MODE=flexible - Accepts any additional JSON properties")
 	fmt.Println("  VALIDATION_MODE=strict   - Rejects undefined properties")
 
-	if err := e.Start(":" + port); err != nil {
-		log.Fatal("Server failed to start:", err)
+	shutdownTimeout, err := server.ShutdownTimeout()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Drain active requests on SIGINT/SIGTERM, then close the database
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Refresh cached job stats until shutdown; stopped before the database
+	// is closed so it never queries a closed pool
+	refreshStopped := make(chan struct{})
+	go func() {
+		defer close(refreshStopped)
+		if cacher, ok := db.JobQueue().(jobs.StatsCacher); ok {
+			cacher.RunStatsRefresh(ctx.Done())
+		}
+	}()
+
+	runErr := server.Run(ctx, e, ":"+port, shutdownTimeout)
+	stop()
+	<-refreshStopped
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	} else {
+		log.Println("Database closed")
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
 	}
 }
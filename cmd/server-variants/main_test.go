@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateApp_MemoryJobQueue(t *testing.T) {
+	// The tests run in cmd/server-variants; validate against the repository's spec
+	t.Setenv("OPENAPI_SPEC", filepath.Join("..", "..", "openapi.yaml"))
+
+	db, err := database.NewDatabaseService(filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	queue := jobs.NewMemoryJobQueue()
+	db.SetJobQueue(queue)
+
+	e, err := createApp("default", db)
+	require.NoError(t, err)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Creating a user enqueues its job on the installed queue
+	rec := serve(http.MethodPost, "/users", `{"email": "memory@example.com", "age": 30}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	pending, err := queue.ListJobs(context.Background(), "pending", 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, string(jobs.JobUserCreated), pending[0].JobType)
+
+	// Metrics, readiness and the admin stats read the same queue
+	rec = serve(http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `job_queue_jobs{status="pending"} 1`)
+
+	rec = serve(http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = serve(http.MethodGet, "/admin/jobs/stats", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"pending":1`)
+
+	// Settings the queue doesn't support fail startup rather than being ignored
+	for _, setting := range []string{
+		"JOB_STATS_CACHE_TTL=1s",
+		"JOB_QUEUE_MAX_PENDING=10",
+		"READY_MAX_PENDING_JOBS=100",
+	} {
+		t.Run(setting, func(t *testing.T) {
+			name, value, _ := strings.Cut(setting, "=")
+			t.Setenv(name, value)
+
+			_, err := createApp("default", db)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), name)
+		})
+	}
+}
@@ -1,19 +1,35 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
 
 	"openapi-validation-example/generated"
+	"openapi-validation-example/internal/handlers"
+	"openapi-validation-example/internal/server"
+	"openapi-validation-example/pkg/auth"
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/metrics"
+	"openapi-validation-example/pkg/response"
 	"openapi-validation-example/pkg/validation"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 // InMemoryUserHandler implements the generated.ServerInterface (in-memory version)
 type InMemoryUserHandler struct {
+	mu     sync.RWMutex
 	users  map[int64]generated.User
 	nextID int64
 }
@@ -27,13 +43,58 @@ func NewInMemoryUserHandler() *InMemoryUserHandler {
 
 // CreateUser implements the generated.ServerInterface.CreateUser method
 func (h *InMemoryUserHandler) CreateUser(ctx echo.Context) error {
-	var req generated.UserRequest
-	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
+	contentType, err := handlers.Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := handlers.BindUserBody(ctx)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+	req, _, err := handlers.ExtractAdditionalProps(body)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
 			"error": "Invalid JSON format",
 		})
 	}
 
+	if err := handlers.ValidateAge(req.Age); err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	h.mu.Lock()
+	user := h.addUser(req)
+	h.mu.Unlock()
+
+	return handlers.RespondUser(ctx, http.StatusCreated, contentType, &user, nil)
+}
+
+// CreateUsersBatch implements the generated.ServerInterface.CreateUsersBatch method
+func (h *InMemoryUserHandler) CreateUsersBatch(ctx echo.Context, params generated.CreateUsersBatchParams) error {
+	return handlers.CreateUsersBatch(ctx, params, h.createUsers)
+}
+
+// createUsers adds users under one lock. Adding a user can't fail, so
+// allOrNothing makes no difference.
+func (h *InMemoryUserHandler) createUsers(users []database.BatchUser, allOrNothing bool) ([]database.BatchResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	results := make([]database.BatchResult, len(users))
+	for i, batchUser := range users {
+		user := h.addUser(batchUser.Request)
+		results[i].User = &user
+	}
+	return results, nil
+}
+
+// addUser stores a new user for req. The caller holds h.mu.
+func (h *InMemoryUserHandler) addUser(req generated.UserRequest) generated.User {
 	user := generated.User{
 		Id:    h.nextID,
 		Email: req.Email,
@@ -53,36 +114,197 @@ func (h *InMemoryUserHandler) CreateUser(ctx echo.Context) error {
 
 	h.users[h.nextID] = user
 	h.nextID++
-
-	return ctx.JSON(http.StatusCreated, user)
+	return user
 }
 
 // GetUserById implements the generated.ServerInterface.GetUserById method
 func (h *InMemoryUserHandler) GetUserById(ctx echo.Context, id int64) error {
+	contentType, err := handlers.Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
 	user, exists := h.users[id]
+	h.mu.RUnlock()
 	if !exists {
-		return ctx.JSON(http.StatusNotFound, map[string]string{
+		return response.JSON(ctx, http.StatusNotFound, map[string]string{
 			"error": "User not found",
 		})
 	}
 
-	return ctx.JSON(http.StatusOK, user)
+	return handlers.RespondUser(ctx, http.StatusOK, contentType, &user, nil)
+}
+
+// ListUsers implements the generated.ServerInterface.ListUsers method
+func (h *InMemoryUserHandler) ListUsers(ctx echo.Context, params generated.ListUsersParams) error {
+	limit, offset := handlers.ListPage(params)
+
+	h.mu.RLock()
+	ids := make([]int64, 0, len(h.users))
+	for id := range h.users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	users := []generated.User{}
+	for i := offset; i < len(ids) && len(users) < limit; i++ {
+		users = append(users, h.users[ids[i]])
+	}
+	h.mu.RUnlock()
+
+	return response.JSON(ctx, http.StatusOK, generated.UserList{
+		Users: users,
+		Total: int64(len(ids)),
+	})
+}
+
+// DeleteUser implements the generated.ServerInterface.DeleteUser method
+func (h *InMemoryUserHandler) DeleteUser(ctx echo.Context, id int64) error {
+	h.mu.Lock()
+	_, exists := h.users[id]
+	delete(h.users, id)
+	h.mu.Unlock()
+	if !exists {
+		return response.JSON(ctx, http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Snapshot copies the handler's users for the --snapshot file
+func (h *InMemoryUserHandler) Snapshot() handlers.UserSnapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return handlers.NewUserSnapshot(h.users, h.nextID)
+}
+
+// Restore replaces the handler's users with those in snap
+func (h *InMemoryUserHandler) Restore(snap handlers.UserSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.users = snap.UsersByID()
+	h.nextID = snap.NextID
 }
 
 func main() {
+	// --snapshot keeps users across restarts: loaded on start, saved every
+	// --snapshot-interval and on shutdown
+	snapshotPath := flag.String("snapshot", "", "file to persist users to (default: no persistence)")
+	snapshotInterval := flag.Duration("snapshot-interval", 30*time.Second, "how often to save the --snapshot file")
+	flag.Parse()
+
 	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+
+	// SERVER_NAME is sent in the Server header of JSON responses
+	response.ServerName = os.Getenv("SERVER_NAME")
 
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
-	validationMiddleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	// CORS_ALLOWED_ORIGINS lets browser apps on those origins call the API.
+	// Preflight requests are answered here, before authentication and
+	// validation.
+	corsConfig, corsEnabled, err := server.CORSConfig()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	if corsEnabled {
+		e.Use(middleware.CORSWithConfig(corsConfig))
+	}
+
+	// GZIP=1 compresses larger responses. It wraps metrics, authentication
+	// and validation, so response validation sees the uncompressed body.
+	gzipConfig, gzipEnabled, err := server.GzipConfig()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	if gzipEnabled {
+		e.Use(middleware.GzipWithConfig(gzipConfig))
+	}
+
+	// Metrics wrap validation so rejected requests are counted too
+	serverMetrics := metrics.New()
+	e.Use(serverMetrics.Middleware())
+	e.GET(metrics.Path, serverMetrics.Handler())
+
+	// MAX_BODY_SIZE (default 1M) caps request bodies; larger ones get 413
+	// before validation or a handler reads them
+	bodyLimit, err := server.BodyLimit()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	e.Use(middleware.BodyLimit(bodyLimit))
+
+	// MAX_CONCURRENT_PER_IP caps each client's requests in flight; the
+	// excess gets 429
+	maxConcurrent, limitConcurrency, err := server.ConcurrencyLimitFromEnv()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	if limitConcurrency {
+		e.Use(server.NewConcurrencyLimiter(maxConcurrent).Middleware(handlers.HealthzPath, handlers.ReadyzPath, metrics.Path))
+	}
+
+	// API_KEYS or API_KEYS_FILE require an X-API-Key header on every route
+	// except the health checks and metrics
+	apiKeys, err := auth.LoadKeySet()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	authenticate := openapi3filter.NoopAuthenticationFunc
+	if apiKeys.Enabled() {
+		e.Use(apiKeys.Middleware(handlers.HealthzPath, handlers.ReadyzPath, metrics.Path))
+		authenticate = apiKeys.AuthenticationFunc()
+	} else {
+		log.Println("API key authentication disabled: set API_KEYS or API_KEYS_FILE to enable it")
+	}
+
+	// OPENAPI_SPEC points at another spec; a missing or invalid one stops
+	// startup here
+	specFile := server.SpecPath("openapi.yaml")
+
+	// VALIDATION_TIMING reports validation time in a response header;
+	// responses are validated in dev and staging (APP_ENV, VALIDATE_RESPONSES);
+	// VALIDATION_ALL_ERRORS lists every failure instead of the first
+	validationMiddleware, err := validation.NewValidationMiddlewareWithOptions(specFile, validation.Options{
+		ReportDuration:     os.Getenv("VALIDATION_TIMING") != "",
+		AuthenticationFunc: authenticate,
+		ValidateResponses:  validation.ResponseValidationFromEnv(),
+		OnValidationError:  serverMetrics.ValidationError,
+		MultiError:         os.Getenv("VALIDATION_ALL_ERRORS") != "",
+	})
 	if err != nil {
 		e.Logger.Fatal("Failed to initialize validation middleware:", err)
 	}
 
 	e.Use(validationMiddleware.Validate())
 
+	// Optionally check that the generated types still match the spec
+	if os.Getenv("SPEC_SELF_TEST") != "" {
+		validation.SelfTest(specFile, log.Default())
+	}
+
+	// No external dependencies, so the in-memory server is always ready
+	handlers.RegisterHealthRoutes(e)
+
 	userHandler := NewInMemoryUserHandler()
+	if *snapshotPath != "" {
+		snap, ok, err := handlers.ReadUserSnapshot(*snapshotPath)
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+		if ok {
+			userHandler.Restore(snap)
+			log.Printf("Loaded %d users from %s", len(snap.Users), *snapshotPath)
+		}
+	}
 
 	// Use the generated RegisterHandlers function to register routes
 	generated.RegisterHandlers(e, userHandler)
@@ -96,7 +318,28 @@ func main() {
 	fmt.Printf("API Documentation: http://localhost:%s\n", port)
 	fmt.Println("Test with: make test")
 
-	if err := e.Start(":" + port); err != nil {
-		e.Logger.Fatal("Server failed to start:", err)
+	shutdownTimeout, err := server.ShutdownTimeout()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	// Drain active requests on SIGINT/SIGTERM instead of dropping them
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *snapshotPath != "" {
+		go handlers.RunUserSnapshots(*snapshotPath, *snapshotInterval, userHandler.Snapshot, ctx.Done())
+	}
+
+	runErr := server.Run(ctx, e, ":"+port, shutdownTimeout)
+	if *snapshotPath != "" {
+		if err := handlers.WriteUserSnapshot(*snapshotPath, userHandler.Snapshot()); err != nil {
+			log.Printf("Failed to save user snapshot: %v", err)
+		} else {
+			log.Printf("Saved users to %s", *snapshotPath)
+		}
+	}
+	if runErr != nil {
+		e.Logger.Fatal(runErr)
 	}
 }
\ No newline at end of file
@@ -1,27 +1,50 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
+	"openapi-validation-example/db"
 	"openapi-validation-example/pkg/database"
 	"openapi-validation-example/pkg/jobs"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	args, jsonRequested := parseOutputFlag(os.Args)
+	jsonOutput = jsonRequested
+	args, afterID, paginate, err := parseAfterFlag(args)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	args, jobType, _, err := parseValueFlag(args, "--type")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	args, limits, err := parseCheckFlags(args)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	args, byType := parseBoolFlag(args, "--by-type")
+
+	if len(args) < 2 {
 		printUsage()
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
+	command := args[1]
 	dbPath := "users.db"
-	if len(os.Args) > 2 {
-		dbPath = os.Args[2]
+	if len(args) > 2 {
+		dbPath = args[2]
 	}
 
 	dbService, err := database.NewDatabaseService(dbPath)
@@ -30,25 +53,97 @@ func main() {
 	}
 	defer dbService.Close()
 
+	// The commands manage the SQLite job queue, which GetJobQueue returns
+	// unless another jobs.JobQueue has been installed
+	if dbService.GetJobQueue() == nil {
+		log.Fatal("worker-manager needs the SQLite job queue")
+	}
+
 	switch command {
 	case "stats":
-		showJobStats(dbService)
+		showJobStats(dbService, byType)
+	case "check":
+		if limits.maxPending < 0 && limits.maxFailed < 0 {
+			fmt.Println("Usage: worker-manager check [--max-pending N] [--max-failed N]")
+			os.Exit(1)
+		}
+		if !checkJobQueue(dbService, limits) {
+			os.Exit(1)
+		}
 	case "list":
 		status := "pending"
-		if len(os.Args) > 3 {
-			status = os.Args[3]
+		if len(args) > 3 {
+			status = args[3]
+		}
+		if status == "all" {
+			status = ""
+		}
+		if jobType != "" {
+			jobType = string(parseJobType(jobType))
+		}
+		if paginate {
+			listJobsPage(dbService, status, jobType, afterID)
+		} else {
+			listJobs(dbService, status, jobType)
 		}
-		listJobs(dbService, status)
 	case "enqueue":
-		if len(os.Args) < 5 {
+		if len(args) < 5 {
 			fmt.Println("Usage: worker-manager enqueue <job_type> <message> [priority]")
 			os.Exit(1)
 		}
-		enqueueTestJob(dbService, os.Args[3], os.Args[4], os.Args[5:])
+		enqueueTestJob(dbService, args[3], args[4], args[5:])
+	case "reprioritize":
+		if len(args) < 5 {
+			fmt.Println("Usage: worker-manager reprioritize <job_type> <priority>")
+			os.Exit(1)
+		}
+		reprioritizeJobs(dbService, args[3], args[4])
+	case "show":
+		if len(args) < 4 {
+			fmt.Println("Usage: worker-manager show <job_id>")
+			os.Exit(1)
+		}
+		showJob(dbService, args[3])
+	case "cancel":
+		if len(args) < 4 {
+			fmt.Println("Usage: worker-manager cancel <job_id>")
+			os.Exit(1)
+		}
+		cancelJob(dbService, args[3])
+	case "requeue":
+		if len(args) < 4 {
+			fmt.Println("Usage: worker-manager requeue <job_id> [--reset-retries]")
+			os.Exit(1)
+		}
+		requeueJob(dbService, args[3], len(args) > 4 && args[4] == "--reset-retries")
+	case "replay-dead":
+		var jobType jobs.JobType
+		if len(args) > 3 {
+			jobType = parseJobType(args[3])
+		}
+		replayDeadJobs(dbService, jobType)
+	case "migrate-type":
+		if len(args) < 5 {
+			fmt.Println("Usage: worker-manager migrate-type <old_type> <new_type>")
+			os.Exit(1)
+		}
+		migrateJobType(dbService, args[3], args[4])
+	case "user-jobs":
+		if len(args) < 4 {
+			fmt.Println("Usage: worker-manager user-jobs <user_id>")
+			os.Exit(1)
+		}
+		listUserJobs(dbService, args[3])
+	case "workers":
+		listWorkers(dbService)
+	case "pause-all":
+		pauseQueue(dbService)
+	case "resume-all":
+		resumeQueue(dbService)
 	case "clear":
 		status := "completed"
-		if len(os.Args) > 3 {
-			status = os.Args[3]
+		if len(args) > 3 {
+			status = args[3]
 		}
 		clearJobs(dbService, status)
 	default:
@@ -62,26 +157,96 @@ func printUsage() {
 	fmt.Println("Worker Manager - Job Queue Management Tool")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  worker-manager <command> [database_path] [args...]")
+	fmt.Println("  worker-manager [--json] <command> [database_path] [args...]")
 	fmt.Println()
 	fmt.Println("Commands:")
-	fmt.Println("  stats                     Show job queue statistics")
-	fmt.Println("  list [status]            List jobs by status (default: pending)")
+	fmt.Println("  stats [--by-type]         Show job queue statistics, optionally also per job")
+	fmt.Println("                           type")
+	fmt.Println("  check [--max-pending N] [--max-failed N]")
+	fmt.Println("                           Exit 1 if more jobs than N are pending or failed,")
+	fmt.Println("                           for monitoring")
+	fmt.Println("  list [status|all] [--type <job_type>] [--after <job_id>]")
+	fmt.Println("                           List jobs by status (default: pending) and type")
+	fmt.Println("                           (default: any); with --after, the next 20 by ID")
+	fmt.Println("                           (0 for the first page)")
 	fmt.Println("  enqueue <type> <msg> [p] Enqueue a test job")
+	fmt.Println("  reprioritize <type> <p>  Set the priority of all pending jobs of a type")
+	fmt.Println("  show <job_id>            Show a job with its full payload")
+	fmt.Println("  cancel <job_id>          Cancel a pending job")
+	fmt.Println("  requeue <job_id> [--reset-retries]")
+	fmt.Println("                           Run a failed job again")
+	fmt.Println("  replay-dead [type]       Run all failed (dead-letter) jobs again, optionally")
+	fmt.Println("                           of one type, with their retries reset")
+	fmt.Println("  migrate-type <old> <new> Move pending jobs of a renamed type to its new name")
+	fmt.Println("  user-jobs <user_id>      List all jobs for a user")
+	fmt.Println("  workers                  List workers that sent a heartbeat in the last")
+	fmt.Println("                           minute")
+	fmt.Println("  pause-all                Stop all workers from claiming jobs")
+	fmt.Println("  resume-all               Let workers claim jobs again")
 	fmt.Println("  clear [status]           Clear jobs by status (default: completed)")
 	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --json                   Print stats, list, show, enqueue and workers output")
+	fmt.Println("                           as JSON")
+	fmt.Println("                           (same as OUTPUT=json)")
+	fmt.Println()
 	fmt.Println("Job Types:")
-	fmt.Println("  user_created, data_analysis, email_notification, data_export")
+	fmt.Println("  user_created, user_deleted, data_analysis, email_notification, data_export")
+	fmt.Println()
+	fmt.Println("Job Priorities:")
+	fmt.Println("  0 (lowest, the default) to 9 (highest); workers claim higher priorities first")
 	fmt.Println()
 	fmt.Println("Job Statuses:")
-	fmt.Println("  pending, processing, completed, failed")
+	fmt.Println("  pending, processing, completed, failed, cancelled")
 }
 
-func showJobStats(dbService *database.DatabaseService) {
-	stats, err := dbService.GetJobQueue().GetJobStats()
+func showJobStats(dbService *database.DatabaseService, byType bool) {
+	stats, err := dbService.GetJobQueue().GetJobStats(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to get job stats: %v", err)
 	}
+	total := stats.PendingCount + stats.ProcessingCount + stats.CompletedCount + stats.FailedCount + stats.CancelledCount
+
+	paused, err := dbService.GetJobQueue().IsQueuePaused(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get queue pause: %v", err)
+	}
+
+	durations, err := dbService.GetJobQueue().GetJobDurationStats(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get job duration stats: %v", err)
+	}
+
+	var typeStats map[string]jobs.JobTypeStats
+	if byType {
+		typeStats, err = dbService.GetJobQueue().GetJobStatsByType(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to get job stats by type: %v", err)
+		}
+	}
+
+	if jsonOutput {
+		out := statsJSON{
+			Pending:    stats.PendingCount,
+			Processing: stats.ProcessingCount,
+			Completed:  stats.CompletedCount,
+			Failed:     stats.FailedCount,
+			Cancelled:  stats.CancelledCount,
+			Total:      total,
+			Paused:     paused,
+			Durations:  []durationStatJSON{},
+			ByType:     typeStats,
+		}
+		for _, d := range durations {
+			out.Durations = append(out.Durations, durationStatJSON{
+				JobType:       d.JobType,
+				JobCount:      d.JobCount,
+				AvgDurationMs: d.AvgDurationMs.Float64,
+			})
+		}
+		printJSON(out)
+		return
+	}
 
 	fmt.Println("📊 Job Queue Statistics")
 	fmt.Println(strings.Repeat("=", 40))
@@ -89,25 +254,261 @@ func showJobStats(dbService *database.DatabaseService) {
 	fmt.Printf("Processing: %d jobs\n", stats.ProcessingCount)
 	fmt.Printf("Completed:  %d jobs\n", stats.CompletedCount)
 	fmt.Printf("Failed:     %d jobs\n", stats.FailedCount)
-	fmt.Printf("Total:      %d jobs\n",
-		stats.PendingCount+stats.ProcessingCount+stats.CompletedCount+stats.FailedCount)
+	fmt.Printf("Cancelled:  %d jobs\n", stats.CancelledCount)
+	fmt.Printf("Total:      %d jobs\n", total)
+
+	if paused {
+		fmt.Println("⏸️  Queue is paused (run resume-all to resume)")
+	}
+
+	if byType {
+		printStatsByType(typeStats)
+	}
+
+	if len(durations) > 0 {
+		fmt.Println()
+		fmt.Println("⏱️  Average Processing Duration")
+		fmt.Println(strings.Repeat("=", 40))
+		for _, d := range durations {
+			fmt.Printf("%-20s %8.1f ms (%d jobs)\n", d.JobType, d.AvgDurationMs.Float64, d.JobCount)
+		}
+	}
+}
+
+// printStatsByType prints a row of status counts per job type
+func printStatsByType(typeStats map[string]jobs.JobTypeStats) {
+	fmt.Println()
+	fmt.Println("🗂️  Jobs by Type")
+	fmt.Println(strings.Repeat("=", 72))
+	if len(typeStats) == 0 {
+		fmt.Println("No jobs")
+		return
+	}
+
+	jobTypes := make([]string, 0, len(typeStats))
+	for jobType := range typeStats {
+		jobTypes = append(jobTypes, jobType)
+	}
+	sort.Strings(jobTypes)
+
+	fmt.Printf("%-20s %8s %10s %9s %6s %9s\n", "Type", "Pending", "Processing", "Completed", "Failed", "Cancelled")
+	for _, jobType := range jobTypes {
+		stats := typeStats[jobType]
+		fmt.Printf("%-20s %8d %10d %9d %6d %9d\n", jobType, stats.Pending, stats.Processing, stats.Completed, stats.Failed, stats.Cancelled)
+	}
+}
+
+// parseBoolFlag removes name from args, wherever it appears, and reports
+// whether it was given
+func parseBoolFlag(args []string, name string) ([]string, bool) {
+	remaining := make([]string, 0, len(args))
+	found := false
+	for _, arg := range args {
+		if arg == name {
+			found = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	return remaining, found
+}
+
+// queueLimits are the thresholds check alerts on; -1 leaves one unchecked
+type queueLimits struct {
+	maxPending int64
+	maxFailed  int64
+}
+
+// parseCheckFlags removes --max-pending and --max-failed from args
+func parseCheckFlags(args []string) ([]string, queueLimits, error) {
+	limits := queueLimits{maxPending: -1, maxFailed: -1}
+	for _, flag := range []struct {
+		name  string
+		limit *int64
+	}{
+		{"--max-pending", &limits.maxPending},
+		{"--max-failed", &limits.maxFailed},
+	} {
+		var value string
+		var found bool
+		var err error
+		args, value, found, err = parseValueFlag(args, flag.name)
+		if err != nil {
+			return nil, limits, err
+		}
+		if !found {
+			continue
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, limits, fmt.Errorf("invalid %s: %s", flag.name, value)
+		}
+		*flag.limit = limit
+	}
+	return args, limits, nil
+}
+
+// checkJobQueue compares the pending and failed job counts against limits,
+// printing one line per count checked, and reports whether both are within
+// them
+func checkJobQueue(dbService *database.DatabaseService, limits queueLimits) bool {
+	stats, err := dbService.GetJobQueue().GetJobStats(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get job stats: %v", err)
+	}
+
+	ok := true
+	for _, check := range []struct {
+		status string
+		count  int64
+		max    int64
+	}{
+		{"pending", stats.PendingCount, limits.maxPending},
+		{"failed", stats.FailedCount, limits.maxFailed},
+	} {
+		if check.max < 0 {
+			continue
+		}
+		if check.count > check.max {
+			fmt.Printf("CRITICAL: %d %s jobs (max %d)\n", check.count, check.status, check.max)
+			ok = false
+		} else {
+			fmt.Printf("OK: %d %s jobs (max %d)\n", check.count, check.status, check.max)
+		}
+	}
+	return ok
 }
 
-func listJobs(dbService *database.DatabaseService, status string) {
-	jobs, err := dbService.GetJobQueue().ListJobs(status, 20)
+// listJobs lists the first page of jobs in status and of jobType. Pending
+// jobs are listed in the order workers will pick them up, so the page shows
+// what runs next; jobs in any other status are listed newest first.
+func listJobs(dbService *database.DatabaseService, status, jobType string) {
+	var (
+		jobList []db.JobQueue
+		err     error
+		order   string
+	)
+	if status == "pending" {
+		jobList, err = dbService.GetJobQueue().ListPendingJobsInClaimOrder(context.Background(), jobType, listPageSize)
+		order = fmt.Sprintf("next %d to run", listPageSize)
+	} else {
+		jobList, err = dbService.GetJobQueue().ListJobsFiltered(context.Background(), status, jobType, listPageSize)
+		order = fmt.Sprintf("last %d", listPageSize)
+	}
 	if err != nil {
 		log.Fatalf("Failed to list jobs: %v", err)
 	}
 
-	fmt.Printf("📋 Jobs with status '%s' (last 20)\n", status)
+	if jsonOutput {
+		out := make([]jobJSON, 0, len(jobList))
+		for _, job := range jobList {
+			out = append(out, newJobJSON(job))
+		}
+		printJSON(out)
+		return
+	}
+
+	fmt.Printf("📋 Jobs%s (%s)\n", describeJobs(status, jobType), order)
 	fmt.Println(strings.Repeat("=", 60))
 
-	if len(jobs) == 0 {
-		fmt.Printf("No jobs found with status '%s'\n", status)
+	if len(jobList) == 0 {
+		fmt.Printf("No jobs found%s\n", describeJobs(status, jobType))
+		return
+	}
+
+	printJobs(jobList)
+}
+
+// listPageSize is how many jobs list shows, with or without --after
+const listPageSize = 20
+
+// parseValueFlag removes name <value> (or name=<value>) from args, wherever
+// it appears, and reports the value and whether the flag was given
+func parseValueFlag(args []string, name string) ([]string, string, bool, error) {
+	remaining := make([]string, 0, len(args))
+	var value string
+	found := false
+	for i := 0; i < len(args); i++ {
+		v, ok := strings.CutPrefix(args[i], name+"=")
+		if args[i] == name {
+			if i+1 == len(args) {
+				return nil, "", false, fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			v, ok = args[i], true
+		}
+		if !ok {
+			remaining = append(remaining, args[i])
+			continue
+		}
+		value, found = v, true
+	}
+	return remaining, value, found, nil
+}
+
+// parseAfterFlag removes --after <job_id> (or --after=<job_id>) from args and
+// reports the ID and whether the flag was given
+func parseAfterFlag(args []string) ([]string, int64, bool, error) {
+	args, value, found, err := parseValueFlag(args, "--after")
+	if err != nil || !found {
+		return args, 0, false, err
+	}
+
+	afterID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || afterID < 0 {
+		return nil, 0, false, fmt.Errorf("invalid --after job ID: %s", value)
+	}
+	return args, afterID, true, nil
+}
+
+// describeJobs describes the filter list applies, e.g. " with status 'failed'
+// of type 'data_export'"; an empty status or jobType is left out
+func describeJobs(status, jobType string) string {
+	var description string
+	if status != "" {
+		description += fmt.Sprintf(" with status '%s'", status)
+	}
+	if jobType != "" {
+		description += fmt.Sprintf(" of type '%s'", jobType)
+	}
+	return description
+}
+
+// listJobsPage lists the jobs in status and of jobType with an ID above
+// afterID, in ID order, and prints the --after value for the next page
+func listJobsPage(dbService *database.DatabaseService, status, jobType string, afterID int64) {
+	jobList, err := dbService.GetJobQueue().ListJobsFilteredPaginated(context.Background(), status, jobType, listPageSize, afterID)
+	if err != nil {
+		log.Fatalf("Failed to list jobs: %v", err)
+	}
+
+	if jsonOutput {
+		out := make([]jobJSON, 0, len(jobList))
+		for _, job := range jobList {
+			out = append(out, newJobJSON(job))
+		}
+		printJSON(out)
 		return
 	}
 
-	for _, job := range jobs {
+	fmt.Printf("📋 Jobs%s after ID %d\n", describeJobs(status, jobType), afterID)
+	fmt.Println(strings.Repeat("=", 60))
+
+	if len(jobList) == 0 {
+		fmt.Printf("No more jobs found%s\n", describeJobs(status, jobType))
+		return
+	}
+
+	printJobs(jobList)
+	if len(jobList) == listPageSize {
+		fmt.Printf("Next page: --after %d\n", jobList[len(jobList)-1].ID)
+	}
+}
+
+// printJobs prints a line for each job, with its error, payload preview and
+// request ID
+func printJobs(jobList []db.JobQueue) {
+	for _, job := range jobList {
 		var priority, retryCount, maxRetries int64
 		if job.Priority.Valid {
 			priority = job.Priority.Int64
@@ -126,19 +527,12 @@ func listJobs(dbService *database.DatabaseService, status string) {
 			fmt.Printf("  Error: %s\n", job.ErrorMessage.String)
 		}
 
-		// Show payload preview
-		type JobPayloadPreview struct {
-			UserID  *int64 `json:"user_id,omitempty"`
-			Message string `json:"message,omitempty"`
+		// Show payload preview, or the raw payload if it can't be decoded
+		for _, line := range jobs.PayloadPreview(job.Payload, jobs.DefaultPreviewRawLength) {
+			fmt.Printf("  %s\n", line)
 		}
-		var payload JobPayloadPreview
-		if err := json.Unmarshal([]byte(job.Payload), &payload); err == nil {
-			if payload.UserID != nil {
-				fmt.Printf("  User ID: %d\n", *payload.UserID)
-			}
-			if payload.Message != "" {
-				fmt.Printf("  Message: %s\n", payload.Message)
-			}
+		if job.RequestID.Valid {
+			fmt.Printf("  Request ID: %s\n", job.RequestID.String)
 		}
 
 		if job.CreatedAt.Valid {
@@ -148,37 +542,165 @@ func listJobs(dbService *database.DatabaseService, status string) {
 	}
 }
 
-func enqueueTestJob(dbService *database.DatabaseService, jobTypeStr, message string, args []string) {
-	priority := 0
-	if len(args) > 0 {
-		if p, err := strconv.Atoi(args[0]); err == nil {
-			priority = p
-		}
+func showJob(dbService *database.DatabaseService, jobIDStr string) {
+	jobID, err := strconv.ParseInt(jobIDStr, 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid job ID: %s\n", jobIDStr)
+		os.Exit(1)
 	}
 
-	var jobType jobs.JobType
-	switch jobTypeStr {
-	case "user_created":
-		jobType = jobs.JobUserCreated
-	case "data_analysis":
-		jobType = jobs.JobDataAnalysis
-	case "email_notification":
-		jobType = jobs.JobEmailNotification
-	case "data_export":
-		jobType = jobs.JobDataExport
-	default:
-		fmt.Printf("Invalid job type: %s\n", jobTypeStr)
-		fmt.Println("Valid types: user_created, data_analysis, email_notification, data_export")
+	job, err := dbService.GetJobQueue().GetJob(context.Background(), jobID)
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		fmt.Printf("Job %d not found\n", jobID)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Failed to get job: %v", err)
+	}
+
+	if jsonOutput {
+		printJSON(newJobJSON(*job))
+		return
+	}
+
+	var priority, retryCount, maxRetries int64
+	if job.Priority.Valid {
+		priority = job.Priority.Int64
+	}
+	if job.RetryCount.Valid {
+		retryCount = job.RetryCount.Int64
+	}
+	if job.MaxRetries.Valid {
+		maxRetries = job.MaxRetries.Int64
+	}
+
+	fmt.Printf("📄 Job %d\n", job.ID)
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Printf("Type:       %s\n", job.JobType)
+	fmt.Printf("Status:     %s\n", job.Status)
+	fmt.Printf("Priority:   %d\n", priority)
+	fmt.Printf("Retries:    %d/%d\n", retryCount, maxRetries)
+	fmt.Printf("Request ID: %s\n", orDash(job.RequestID))
+	fmt.Printf("Dedup key:  %s\n", orDash(job.DedupKey))
+	if job.WorkerID.Valid {
+		fmt.Printf("Worker:     %d\n", job.WorkerID.Int64)
+	} else {
+		fmt.Println("Worker:     -")
+	}
+	fmt.Printf("Error:      %s\n", orDash(job.ErrorMessage))
+	fmt.Printf("Created:    %s\n", formatTime(job.CreatedAt))
+	fmt.Printf("Scheduled:  %s\n", formatTime(job.ScheduledAt))
+	fmt.Printf("Started:    %s\n", formatTime(job.StartedAt))
+	fmt.Printf("Completed:  %s\n", formatTime(job.CompletedAt))
+	if job.DurationMs.Valid {
+		fmt.Printf("Duration:   %d ms\n", job.DurationMs.Int64)
+	} else {
+		fmt.Println("Duration:   -")
+	}
+
+	// Indented JSON, or the raw bytes if the payload is not valid JSON
+	fmt.Println("Payload:")
+	fmt.Println(jobs.FormatPayload(job.Payload))
+}
+
+// orDash returns s, or "-" when it is NULL or empty
+func orDash(s sql.NullString) string {
+	if !s.Valid || s.String == "" {
+		return "-"
+	}
+	return s.String
+}
+
+// formatTime formats t for display, or "-" when it is NULL
+func formatTime(t sql.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.Format("2006-01-02 15:04:05")
+}
+
+func cancelJob(dbService *database.DatabaseService, jobIDStr string) {
+	jobID, err := strconv.ParseInt(jobIDStr, 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid job ID: %s\n", jobIDStr)
+		os.Exit(1)
+	}
+
+	if err := dbService.GetJobQueue().CancelJob(context.Background(), jobID); err != nil {
+		log.Fatalf("Failed to cancel job %d: %v", jobID, err)
+	}
+
+	fmt.Printf("✅ Cancelled job %d\n", jobID)
+}
+
+func requeueJob(dbService *database.DatabaseService, jobIDStr string, resetRetries bool) {
+	jobID, err := strconv.ParseInt(jobIDStr, 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid job ID: %s\n", jobIDStr)
 		os.Exit(1)
 	}
 
+	if err := dbService.GetJobQueue().RequeueJob(context.Background(), jobID, resetRetries); err != nil {
+		log.Fatalf("Failed to requeue job %d: %v", jobID, err)
+	}
+
+	fmt.Printf("🔁 Requeued job %d\n", jobID)
+}
+
+func listUserJobs(dbService *database.DatabaseService, userIDStr string) {
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid user ID: %s\n", userIDStr)
+		os.Exit(1)
+	}
+
+	jobList, err := dbService.GetJobQueue().GetJobsForUser(context.Background(), userID)
+	if err != nil {
+		log.Fatalf("Failed to get jobs for user: %v", err)
+	}
+
+	fmt.Printf("📋 Jobs for user %d\n", userID)
+	fmt.Println(strings.Repeat("=", 60))
+
+	if len(jobList) == 0 {
+		fmt.Printf("No jobs found for user %d\n", userID)
+		return
+	}
+
+	for _, job := range jobList {
+		fmt.Printf("ID: %d | Type: %s | Status: %s\n", job.ID, job.JobType, job.Status)
+
+		if job.ErrorMessage.Valid && job.ErrorMessage.String != "" {
+			fmt.Printf("  Error: %s\n", job.ErrorMessage.String)
+		}
+		if job.RequestID.Valid {
+			fmt.Printf("  Request ID: %s\n", job.RequestID.String)
+		}
+		if job.CreatedAt.Valid {
+			fmt.Printf("  Created: %s\n", job.CreatedAt.Time.Format("2006-01-02 15:04:05"))
+		}
+		if job.CompletedAt.Valid {
+			fmt.Printf("  Completed: %s\n", job.CompletedAt.Time.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Println()
+	}
+}
+
+func enqueueTestJob(dbService *database.DatabaseService, jobTypeStr, message string, args []string) {
+	priority := jobs.MinPriority
+	if len(args) > 0 {
+		priority = parsePriority(args[0])
+	}
+
+	jobType := parseJobType(jobTypeStr)
+
 	payload := jobs.JobPayload{
 		Message: message,
 	}
 
 	// Add specific payload data based on job type
 	switch jobType {
-	case jobs.JobUserCreated:
+	case jobs.JobUserCreated, jobs.JobUserDeleted:
 		userID := int64(999)
 		payload.UserID = &userID
 		payload.UserData = map[string]interface{}{
@@ -194,11 +716,16 @@ func enqueueTestJob(dbService *database.DatabaseService, jobTypeStr, message str
 		payload.Recipients = []string{"admin@example.com", "user@example.com"}
 	}
 
-	job, err := dbService.GetJobQueue().EnqueueJob(jobType, payload, priority)
+	job, err := dbService.GetJobQueue().EnqueueJob(context.Background(), jobType, payload, priority)
 	if err != nil {
 		log.Fatalf("Failed to enqueue job: %v", err)
 	}
 
+	if jsonOutput {
+		printJSON(newJobJSON(*job))
+		return
+	}
+
 	fmt.Printf("✅ Job enqueued successfully!\n")
 	var jobPriority int64
 	if job.Priority.Valid {
@@ -210,8 +737,122 @@ func enqueueTestJob(dbService *database.DatabaseService, jobTypeStr, message str
 	}
 }
 
+func replayDeadJobs(dbService *database.DatabaseService, jobType jobs.JobType) {
+	replayed, err := dbService.GetJobQueue().ReplayDeadJobs(context.Background(), jobType)
+	if err != nil {
+		log.Fatalf("Failed to replay dead-letter jobs: %v", err)
+	}
+
+	if jobType == "" {
+		fmt.Printf("🔁 Replayed %d dead-letter jobs\n", replayed)
+		return
+	}
+	fmt.Printf("🔁 Replayed %d dead-letter '%s' jobs\n", replayed, jobType)
+}
+
+// migrateJobType takes the old type as given, since a renamed type is no
+// longer one parseJobType knows
+func migrateJobType(dbService *database.DatabaseService, oldType, newTypeStr string) {
+	newType := parseJobType(newTypeStr)
+
+	migrated, err := dbService.GetJobQueue().MigrateJobType(context.Background(), jobs.JobType(oldType), newType)
+	if err != nil {
+		log.Fatalf("Failed to migrate job type: %v", err)
+	}
+
+	fmt.Printf("✅ Migrated %d pending '%s' jobs to '%s'\n", migrated, oldType, newType)
+}
+
+func reprioritizeJobs(dbService *database.DatabaseService, jobTypeStr, priorityStr string) {
+	jobType := parseJobType(jobTypeStr)
+
+	priority := parsePriority(priorityStr)
+
+	updated, err := dbService.GetJobQueue().SetPriorityForStatus(context.Background(), jobType, "pending", priority)
+	if err != nil {
+		log.Fatalf("Failed to reprioritize jobs: %v", err)
+	}
+
+	fmt.Printf("✅ Set priority %d on %d pending '%s' jobs\n", priority, updated, jobType)
+}
+
+// parsePriority parses a job priority, exiting if it is not a number from
+// jobs.MinPriority to jobs.MaxPriority
+func parsePriority(priorityStr string) int {
+	priority, err := strconv.Atoi(priorityStr)
+	if err == nil {
+		err = jobs.ValidatePriority(priority)
+	}
+	if err != nil {
+		fmt.Printf("Invalid priority: %s\n", priorityStr)
+		fmt.Printf("Priorities run from %d (lowest) to %d (highest)\n", jobs.MinPriority, jobs.MaxPriority)
+		os.Exit(1)
+	}
+	return priority
+}
+
+func parseJobType(jobTypeStr string) jobs.JobType {
+	switch jobTypeStr {
+	case "user_created":
+		return jobs.JobUserCreated
+	case "user_deleted":
+		return jobs.JobUserDeleted
+	case "data_analysis":
+		return jobs.JobDataAnalysis
+	case "email_notification":
+		return jobs.JobEmailNotification
+	case "data_export":
+		return jobs.JobDataExport
+	default:
+		fmt.Printf("Invalid job type: %s\n", jobTypeStr)
+		fmt.Println("Valid types: user_created, user_deleted, data_analysis, email_notification, data_export")
+		os.Exit(1)
+		return ""
+	}
+}
+
+func listWorkers(dbService *database.DatabaseService) {
+	workers, err := dbService.GetJobQueue().ListLiveWorkers(context.Background(), jobs.WorkerLivenessTimeout)
+	if err != nil {
+		log.Fatalf("Failed to list workers: %v", err)
+	}
+
+	if jsonOutput {
+		printJSON(workers)
+		return
+	}
+
+	fmt.Printf("👷 Workers seen in the last %s\n", jobs.WorkerLivenessTimeout)
+	fmt.Println(strings.Repeat("=", 60))
+
+	if len(workers) == 0 {
+		fmt.Println("No live workers")
+		return
+	}
+
+	for _, w := range workers {
+		fmt.Printf("Worker %d | Last seen: %s\n", w.WorkerID, w.LastSeenAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func pauseQueue(dbService *database.DatabaseService) {
+	if err := dbService.GetJobQueue().PauseQueue(context.Background()); err != nil {
+		log.Fatalf("Failed to pause queue: %v", err)
+	}
+
+	fmt.Println("⏸️  Queue paused: workers will not claim new jobs until resume-all")
+}
+
+func resumeQueue(dbService *database.DatabaseService) {
+	if err := dbService.GetJobQueue().ResumeQueue(context.Background()); err != nil {
+		log.Fatalf("Failed to resume queue: %v", err)
+	}
+
+	fmt.Println("▶️  Queue resumed")
+}
+
 func clearJobs(dbService *database.DatabaseService, status string) {
-	jobs, err := dbService.GetJobQueue().ListJobs(status, 1000)
+	jobs, err := dbService.GetJobQueue().ListJobs(context.Background(), status, 1000)
 	if err != nil {
 		log.Fatalf("Failed to list jobs: %v", err)
 	}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"openapi-validation-example/db"
+	"openapi-validation-example/pkg/jobs"
+)
+
+// jsonOutput makes stats, list, show and enqueue print JSON instead of text.
+// It is set by the --json flag or OUTPUT=json.
+var jsonOutput bool
+
+// parseOutputFlag removes --json from args, wherever it appears, and reports
+// whether JSON output was requested by the flag or the OUTPUT variable.
+func parseOutputFlag(args []string) ([]string, bool) {
+	enabled := os.Getenv("OUTPUT") == "json"
+
+	remaining := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--json" {
+			enabled = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	return remaining, enabled
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("Failed to encode JSON: %v", err)
+	}
+}
+
+// jobJSON is a job_queue row with the nullable columns flattened: NULL
+// becomes a JSON null (or 0 for the counters). The payload is embedded as
+// JSON when it is valid JSON and as a string otherwise.
+type jobJSON struct {
+	ID           int64           `json:"id"`
+	JobType      string          `json:"job_type"`
+	Status       string          `json:"status"`
+	Priority     int64           `json:"priority"`
+	RetryCount   int64           `json:"retry_count"`
+	MaxRetries   int64           `json:"max_retries"`
+	ErrorMessage *string         `json:"error_message"`
+	ScheduledAt  *time.Time      `json:"scheduled_at"`
+	StartedAt    *time.Time      `json:"started_at"`
+	CompletedAt  *time.Time      `json:"completed_at"`
+	CreatedAt    *time.Time      `json:"created_at"`
+	DurationMs   *int64          `json:"duration_ms"`
+	RequestID    *string         `json:"request_id"`
+	DedupKey     *string         `json:"dedup_key"`
+	WorkerID     *int64          `json:"worker_id"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+func newJobJSON(job db.JobQueue) jobJSON {
+	payload := json.RawMessage(job.Payload)
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(job.Payload)
+	}
+
+	return jobJSON{
+		ID:           job.ID,
+		JobType:      job.JobType,
+		Status:       job.Status,
+		Priority:     job.Priority.Int64,
+		RetryCount:   job.RetryCount.Int64,
+		MaxRetries:   job.MaxRetries.Int64,
+		ErrorMessage: nullString(job.ErrorMessage),
+		ScheduledAt:  nullTime(job.ScheduledAt),
+		StartedAt:    nullTime(job.StartedAt),
+		CompletedAt:  nullTime(job.CompletedAt),
+		CreatedAt:    nullTime(job.CreatedAt),
+		DurationMs:   nullInt64(job.DurationMs),
+		RequestID:    nullString(job.RequestID),
+		DedupKey:     nullString(job.DedupKey),
+		WorkerID:     nullInt64(job.WorkerID),
+		Payload:      payload,
+	}
+}
+
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullInt64(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+// statsJSON is the JSON form of the stats command
+type statsJSON struct {
+	Pending    int64              `json:"pending"`
+	Processing int64              `json:"processing"`
+	Completed  int64              `json:"completed"`
+	Failed     int64              `json:"failed"`
+	Cancelled  int64              `json:"cancelled"`
+	Total      int64              `json:"total"`
+	Paused     bool               `json:"paused"`
+	Durations  []durationStatJSON `json:"durations"`
+	// ByType is only set by stats --by-type
+	ByType map[string]jobs.JobTypeStats `json:"by_type,omitempty"`
+}
+
+type durationStatJSON struct {
+	JobType       string  `json:"job_type"`
+	JobCount      int64   `json:"job_count"`
+	AvgDurationMs float64 `json:"avg_duration_ms"`
+}
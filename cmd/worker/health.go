@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"openapi-validation-example/pkg/database"
+)
+
+const defaultHealthCheckInterval = 30 * time.Second
+
+// healthCheckConfig reads DB_HEALTH_CHECK_INTERVAL (a Go duration, default
+// 30s) and DB_RECONNECT (reopen the database when a check fails).
+func healthCheckConfig() (time.Duration, bool) {
+	interval := defaultHealthCheckInterval
+	if intervalStr := os.Getenv("DB_HEALTH_CHECK_INTERVAL"); intervalStr != "" {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			slog.Warn("Invalid DB_HEALTH_CHECK_INTERVAL, using default",
+				"value", intervalStr, "default", defaultHealthCheckInterval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	reconnect := false
+	switch os.Getenv("DB_RECONNECT") {
+	case "1", "true", "TRUE", "yes":
+		reconnect = true
+	}
+
+	return interval, reconnect
+}
+
+// runHealthCheck pings the database every interval until stopCh is closed.
+// Failures are logged and, if reconnect is set, a reconnect is attempted.
+func runHealthCheck(dbService *database.DatabaseService, interval time.Duration, reconnect bool, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			checkDatabase(dbService, interval, reconnect)
+		}
+	}
+}
+
+func checkDatabase(dbService *database.DatabaseService, timeout time.Duration, reconnect bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := dbService.Ping(ctx)
+	if err == nil {
+		return
+	}
+
+	slog.Error("Database health check failed", "error", err)
+	if !reconnect {
+		return
+	}
+
+	slog.Info("Attempting to reconnect to database")
+	if err := dbService.Reconnect(ctx); err != nil {
+		slog.Error("Database reconnect failed", "error", err)
+		return
+	}
+	slog.Info("Reconnected to database")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogger installs a key=value slog logger as the default logger. The
+// level comes from LOG_LEVEL (DEBUG, INFO, WARN or ERROR) and defaults to
+// INFO; the human-friendly processing messages are only shown at DEBUG.
+func setupLogger() {
+	level := slog.LevelInfo
+	levelStr := os.Getenv("LOG_LEVEL")
+	invalidLevel := false
+	if levelStr != "" {
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(levelStr))); err != nil {
+			level = slog.LevelInfo
+			invalidLevel = true
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+
+	if invalidLevel {
+		slog.Warn("Invalid LOG_LEVEL, using INFO", "log_level", levelStr)
+	}
+}
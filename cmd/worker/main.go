@@ -1,277 +1,168 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
-	"openapi-validation-example/db"
+	"openapi-validation-example/internal/worker"
 	"openapi-validation-example/pkg/database"
 	"openapi-validation-example/pkg/jobs"
 )
 
-type Worker struct {
-	id           int
-	jobQueue     *jobs.JobQueueService
-	stopCh       chan struct{}
-	wg           *sync.WaitGroup
-	processingWg *sync.WaitGroup
-}
-
-type JobProcessor interface {
-	Process(job *db.JobQueue, payload jobs.JobPayload) error
-	JobType() jobs.JobType
-}
-
-// UserCreatedProcessor handles user creation jobs
-type UserCreatedProcessor struct{}
-
-func (p *UserCreatedProcessor) JobType() jobs.JobType {
-	return jobs.JobUserCreated
-}
-
-func (p *UserCreatedProcessor) Process(job *db.JobQueue, payload jobs.JobPayload) error {
-	log.Printf("Processing user created job %d for user %d", job.ID, *payload.UserID)
-
-	// Simulate various processing tasks
-	time.Sleep(time.Millisecond * 500) // Simulate work
-
-	// Example processing tasks:
-	fmt.Printf("📧 Sending welcome email to user %d (%s)\n", *payload.UserID, payload.UserData["email"])
-
-	if len(payload.AdditionalProps) > 0 {
-		fmt.Printf("🔍 Analyzing additional user properties: %v\n", payload.AdditionalProps)
-
-		// Example: Log interesting additional properties
-		for key, value := range payload.AdditionalProps {
-			switch key {
-			case "hobby":
-				fmt.Printf("   - User's hobby: %v\n", value)
-			case "location":
-				fmt.Printf("   - User's location: %v\n", value)
-			case "score":
-				fmt.Printf("   - User's score: %v\n", value)
-			default:
-				fmt.Printf("   - Custom field %s: %v\n", key, value)
-			}
-		}
+func main() {
+	dbPath := "workers.db"
+	if len(os.Args) > 1 && os.Args[1] != "" {
+		dbPath = os.Args[1]
 	}
 
-	// Simulate analytics
-	fmt.Printf("📊 Recording user signup metrics for user %d\n", *payload.UserID)
-
-	// Simulate profile setup
-	fmt.Printf("⚙️  Setting up user profile for user %d\n", *payload.UserID)
-
-	return nil
-}
-
-// DataAnalysisProcessor handles data analysis jobs
-type DataAnalysisProcessor struct{}
-
-func (p *DataAnalysisProcessor) JobType() jobs.JobType {
-	return jobs.JobDataAnalysis
-}
+	setupLogger()
 
-func (p *DataAnalysisProcessor) Process(job *db.JobQueue, payload jobs.JobPayload) error {
-	log.Printf("Processing data analysis job %d", job.ID)
+	slog.Info("Starting worker manager", "database", dbPath)
 
-	time.Sleep(time.Second * 2) // Simulate longer analysis
-
-	fmt.Printf("📈 Performing data analysis: %s\n", payload.Message)
-	fmt.Printf("📊 Analysis completed with insights\n")
-
-	return nil
-}
-
-// EmailNotificationProcessor handles email notification jobs
-type EmailNotificationProcessor struct{}
-
-func (p *EmailNotificationProcessor) JobType() jobs.JobType {
-	return jobs.JobEmailNotification
-}
-
-func (p *EmailNotificationProcessor) Process(job *db.JobQueue, payload jobs.JobPayload) error {
-	log.Printf("Processing email notification job %d", job.ID)
-
-	time.Sleep(time.Millisecond * 300)
-
-	for _, recipient := range payload.Recipients {
-		fmt.Printf("📬 Sending email to %s: %s\n", recipient, payload.Message)
+	// Initialize database
+	dbService, err := database.NewDatabaseService(dbPath)
+	if err != nil {
+		slog.Error("Failed to initialize database", "error", err)
+		os.Exit(1)
 	}
+	defer dbService.Close()
 
-	return nil
-}
-
-func NewWorker(id int, jobQueue *jobs.JobQueueService, wg *sync.WaitGroup) *Worker {
-	return &Worker{
-		id:           id,
-		jobQueue:     jobQueue,
-		stopCh:       make(chan struct{}),
-		wg:           wg,
-		processingWg: &sync.WaitGroup{},
+	// The workers use the SQLite job queue's scheduling settings and reaping,
+	// which GetJobQueue returns unless another jobs.JobQueue has been installed
+	if dbService.GetJobQueue() == nil {
+		slog.Error("The worker needs the SQLite job queue")
+		os.Exit(1)
 	}
-}
 
-func (w *Worker) Start() {
-	defer w.wg.Done()
-
-	processors := map[jobs.JobType]JobProcessor{
-		jobs.JobUserCreated:       &UserCreatedProcessor{},
-		jobs.JobDataAnalysis:      &DataAnalysisProcessor{},
-		jobs.JobEmailNotification: &EmailNotificationProcessor{},
+	// Number of concurrent workers
+	numWorkers := 3
+	if workerCount := os.Getenv("WORKER_COUNT"); workerCount != "" {
+		fmt.Sscanf(workerCount, "%d", &numWorkers)
 	}
 
-	log.Printf("Worker %d started", w.id)
+	// Jobs claimed per poll
+	batchSize := 1
+	if workerBatchSize := os.Getenv("WORKER_BATCH_SIZE"); workerBatchSize != "" {
+		fmt.Sscanf(workerBatchSize, "%d", &batchSize)
+	}
 
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	slog.Info("Starting workers", "count", numWorkers, "batch_size", batchSize)
 
-	for {
-		select {
-		case <-w.stopCh:
-			log.Printf("Worker %d received stop signal", w.id)
-			w.processingWg.Wait() // Wait for current jobs to complete
-			log.Printf("Worker %d stopped", w.id)
-			return
-		case <-ticker.C:
-			w.processNextJob(processors)
+	// Optionally serve the periodic stats from a cache refreshed in the background
+	if ttlStr := os.Getenv("JOB_STATS_CACHE_TTL"); ttlStr != "" {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			slog.Warn("Invalid JOB_STATS_CACHE_TTL, stats are not cached", "value", ttlStr)
+		} else {
+			dbService.GetJobQueue().SetStatsCacheTTL(ttl)
 		}
 	}
-}
 
-func (w *Worker) processNextJob(processors map[jobs.JobType]JobProcessor) {
-	job, err := w.jobQueue.GetNextJob()
-	if err != nil {
-		log.Printf("Worker %d: Error getting next job: %v", w.id, err)
-		return
-	}
-
-	if job == nil {
-		// No jobs available
-		return
+	// Optionally share claims between job types instead of strict priority order
+	if os.Getenv("WORKER_SCHEDULING") == "fair" {
+		weights, err := jobs.ParseFairWeights(os.Getenv("WORKER_FAIR_WEIGHTS"))
+		if err == nil {
+			err = dbService.GetJobQueue().SetFairScheduling(weights)
+		}
+		if err != nil {
+			slog.Error("Invalid WORKER_FAIR_WEIGHTS", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Using fair scheduling across job types", "weights", os.Getenv("WORKER_FAIR_WEIGHTS"))
 	}
 
-	w.processingWg.Add(1)
-	go func() {
-		defer w.processingWg.Done()
-
-		log.Printf("Worker %d: Processing job %d (type: %s)", w.id, job.ID, job.JobType)
-
-		// Parse payload
-		var payload jobs.JobPayload
-		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
-			log.Printf("Worker %d: Error parsing job payload: %v", w.id, err)
-			w.jobQueue.FailJob(job.ID, fmt.Sprintf("Failed to parse payload: %v", err), false)
-			return
+	// Optionally cap how many jobs of a type run at once across all workers
+	if limitsStr := os.Getenv("WORKER_CONCURRENCY_LIMITS"); limitsStr != "" {
+		limits, err := jobs.ParseConcurrencyLimits(limitsStr)
+		if err == nil {
+			err = dbService.GetJobQueue().SetConcurrencyLimits(limits)
 		}
-
-		// Find processor
-		processor, exists := processors[jobs.JobType(job.JobType)]
-		if !exists {
-			log.Printf("Worker %d: No processor found for job type: %s", w.id, job.JobType)
-			w.jobQueue.FailJob(job.ID, fmt.Sprintf("No processor for job type: %s", job.JobType), false)
-			return
+		if err != nil {
+			slog.Error("Invalid WORKER_CONCURRENCY_LIMITS", "error", err)
+			os.Exit(1)
 		}
+		slog.Info("Limiting concurrent jobs per type", "limits", limitsStr)
+	}
 
-		// Process the job
-		if err := processor.Process(job, payload); err != nil {
-			log.Printf("Worker %d: Job %d failed: %v", w.id, job.ID, err)
-
-			// Retry logic
-			var retryCount, maxRetries int64
-			if job.RetryCount.Valid {
-				retryCount = job.RetryCount.Int64
-			}
-			if job.MaxRetries.Valid {
-				maxRetries = job.MaxRetries.Int64
-			}
-			shouldRetry := retryCount < maxRetries
-			w.jobQueue.FailJob(job.ID, err.Error(), shouldRetry)
-		} else {
-			log.Printf("Worker %d: Job %d completed successfully", w.id, job.ID)
-			w.jobQueue.CompleteJob(job.ID)
+	// Per-type job timeouts; WORKER_JOB_TIMEOUTS overrides the defaults
+	jobTypeConfigs := defaultJobTypeConfigs()
+	if timeouts := os.Getenv("WORKER_JOB_TIMEOUTS"); timeouts != "" {
+		overrides, err := worker.ParseJobTimeouts(timeouts)
+		if err != nil {
+			slog.Error("Invalid WORKER_JOB_TIMEOUTS", "error", err)
+			os.Exit(1)
+		}
+		for jobType, config := range overrides {
+			jobTypeConfigs[jobType] = config
 		}
-	}()
-}
-
-func (w *Worker) Stop() {
-	close(w.stopCh)
-}
-
-func main() {
-	dbPath := "workers.db"
-	if len(os.Args) > 1 && os.Args[1] != "" {
-		dbPath = os.Args[1]
 	}
 
-	log.Printf("Starting worker manager with database: %s", dbPath)
+	// Jobs left processing by a crashed worker are retried after this long
+	staleAfter := staleJobAge(jobTypeConfigs)
 
-	// Initialize database
-	dbService, err := database.NewDatabaseService(dbPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer dbService.Close()
+	manager := worker.NewManager(dbService.GetJobQueue(), defaultProcessors(), numWorkers, batchSize)
+	manager.SetJobTypeConfigs(jobTypeConfigs)
 
-	// Number of concurrent workers
-	numWorkers := 3
-	if workerCount := os.Getenv("WORKER_COUNT"); workerCount != "" {
-		fmt.Sscanf(workerCount, "%d", &numWorkers)
+	// Optionally keep jobs of types this build has no processor for, e.g.
+	// during a rolling deploy, instead of failing them
+	if delayStr := os.Getenv("WORKER_DEFER_UNKNOWN_TYPES"); delayStr != "" {
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil || delay <= 0 {
+			slog.Error("Invalid WORKER_DEFER_UNKNOWN_TYPES: must be a positive duration", "value", delayStr)
+			os.Exit(1)
+		}
+		manager.SetDeferUnknownJobTypes(delay)
+		slog.Info("Deferring jobs of types without a processor", "delay", delay)
 	}
 
-	log.Printf("Starting %d workers...", numWorkers)
+	manager.Start()
 
-	var wg sync.WaitGroup
-	workers := make([]*Worker, numWorkers)
+	manager.Go(dbService.GetJobQueue().RunStatsRefresh)
 
-	// Start workers
-	for i := 0; i < numWorkers; i++ {
-		workers[i] = NewWorker(i+1, dbService.GetJobQueue(), &wg)
-		wg.Add(1)
-		go workers[i].Start()
-	}
+	// Periodically check that the database is still reachable
+	healthInterval, reconnect := healthCheckConfig()
+	manager.Go(func(done <-chan struct{}) {
+		runHealthCheck(dbService, healthInterval, reconnect, done)
+	})
 
 	// Set up signal handling for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	log.Printf("Worker manager started. Press Ctrl+C to stop.")
+	slog.Info("Worker manager started. Press Ctrl+C to stop.")
+
+	// Cancelled when shutdown begins, so periodic queries don't hold it up
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Print job stats periodically
-	go func() {
+	// Print job stats and reap stale jobs periodically
+	manager.Go(func(done <-chan struct{}) {
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-sigCh:
+			case <-done:
 				return
 			case <-ticker.C:
-				stats, err := dbService.GetJobQueue().GetJobStats()
-				if err == nil {
-					log.Printf("Job Stats - Pending: %d, Processing: %d, Completed: %d, Failed: %d",
-						stats.PendingCount, stats.ProcessingCount, stats.CompletedCount, stats.FailedCount)
-				}
+				worker.LogJobStats(ctx, slog.Default(), dbService.GetJobQueue().GetJobStats)
+				reapStaleJobs(ctx, dbService.GetJobQueue(), staleAfter)
+				reapJobsOfDeadWorkers(ctx, dbService.GetJobQueue())
 			}
 		}
-	}()
+	})
 
-	// Wait for shutdown signal
+	// Wait for shutdown signal; only this goroutine reads sigCh
 	<-sigCh
-	log.Println("Received shutdown signal. Stopping workers...")
-
-	// Stop all workers
-	for _, worker := range workers {
-		worker.Stop()
-	}
+	slog.Info("Received shutdown signal. Stopping workers...")
+	cancel()
 
-	// Wait for all workers to finish
-	wg.Wait()
-	log.Println("All workers stopped. Goodbye!")
+	// Stops background tasks and workers, then waits for all of them
+	manager.Shutdown()
+	slog.Info("All workers stopped. Goodbye!")
 }
\ No newline at end of file
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"openapi-validation-example/db"
+	"openapi-validation-example/internal/worker"
+	"openapi-validation-example/pkg/jobs"
+)
+
+// defaultProcessors returns the processor for each job type the worker handles
+func defaultProcessors() map[jobs.JobType]worker.JobProcessor {
+	return map[jobs.JobType]worker.JobProcessor{
+		jobs.JobUserCreated:       &UserCreatedProcessor{},
+		jobs.JobUserDeleted:       &UserDeletedProcessor{},
+		jobs.JobDataAnalysis:      &DataAnalysisProcessor{},
+		jobs.JobEmailNotification: &EmailNotificationProcessor{},
+	}
+}
+
+// defaultJobTypeConfigs returns the timeout of each job type; quick jobs
+// such as emails fail fast, analysis may run longer. WORKER_JOB_TIMEOUTS
+// overrides them.
+func defaultJobTypeConfigs() map[jobs.JobType]worker.JobTypeConfig {
+	return map[jobs.JobType]worker.JobTypeConfig{
+		jobs.JobUserCreated:       {Timeout: time.Minute},
+		jobs.JobUserDeleted:       {Timeout: time.Minute},
+		jobs.JobDataAnalysis:      {Timeout: 10 * time.Minute},
+		jobs.JobEmailNotification: {Timeout: 30 * time.Second},
+	}
+}
+
+// simulateWork waits for d, or returns ctx's error if the job's timeout
+// expires first
+func simulateWork(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UserCreatedProcessor handles user creation jobs
+type UserCreatedProcessor struct{}
+
+func (p *UserCreatedProcessor) JobType() jobs.JobType {
+	return jobs.JobUserCreated
+}
+
+func (p *UserCreatedProcessor) Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error {
+	logger := worker.JobLogger(slog.Default(), job)
+
+	logger.Debug("Processing user created job", "user_id", *payload.UserID)
+
+	// Simulate various processing tasks
+	if err := simulateWork(ctx, time.Millisecond*500); err != nil {
+		return err
+	}
+
+	// Example processing tasks:
+	logger.Debug("📧 Sending welcome email", "user_id", *payload.UserID, "email", payload.UserData["email"])
+
+	if len(payload.AdditionalProps) > 0 {
+		logger.Debug("🔍 Analyzing additional user properties", "user_id", *payload.UserID, "additional_props", payload.AdditionalProps)
+
+		// Example: Log interesting additional properties, skipping any of an
+		// unexpected type
+		if hobby, ok := payload.StringProp("hobby"); ok {
+			logger.Debug("User's hobby", "user_id", *payload.UserID, "value", hobby)
+		}
+		if location, ok := payload.StringProp("location"); ok {
+			logger.Debug("User's location", "user_id", *payload.UserID, "value", location)
+		}
+		if score, ok := payload.FloatProp("score"); ok {
+			logger.Debug("User's score", "user_id", *payload.UserID, "value", score)
+		}
+		for key, value := range payload.AdditionalProps {
+			switch key {
+			case "hobby", "location", "score":
+			default:
+				logger.Debug("Custom field", "user_id", *payload.UserID, "field", key, "value", value)
+			}
+		}
+	}
+
+	// Simulate analytics
+	logger.Debug("📊 Recording user signup metrics", "user_id", *payload.UserID)
+
+	// Simulate profile setup
+	logger.Debug("⚙️ Setting up user profile", "user_id", *payload.UserID)
+
+	return nil
+}
+
+// UserDeletedProcessor handles user deletion cleanup jobs
+type UserDeletedProcessor struct{}
+
+func (p *UserDeletedProcessor) JobType() jobs.JobType {
+	return jobs.JobUserDeleted
+}
+
+func (p *UserDeletedProcessor) Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error {
+	logger := worker.JobLogger(slog.Default(), job)
+
+	if payload.UserID == nil {
+		return fmt.Errorf("user deleted job %d has no user_id", job.ID)
+	}
+
+	logger.Debug("Processing user deleted job", "user_id", *payload.UserID)
+
+	if err := simulateWork(ctx, time.Millisecond*300); err != nil {
+		return err
+	}
+
+	logger.Debug("🧹 Cleaning up data for deleted user", "user_id", *payload.UserID, "email", payload.UserData["email"])
+
+	return nil
+}
+
+// DataAnalysisProcessor handles data analysis jobs
+type DataAnalysisProcessor struct{}
+
+func (p *DataAnalysisProcessor) JobType() jobs.JobType {
+	return jobs.JobDataAnalysis
+}
+
+func (p *DataAnalysisProcessor) Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error {
+	logger := worker.JobLogger(slog.Default(), job)
+
+	logger.Debug("Processing data analysis job")
+
+	// Simulate longer analysis
+	if err := simulateWork(ctx, time.Second*2); err != nil {
+		return err
+	}
+
+	logger.Debug("📈 Performing data analysis", "message", payload.Message)
+	logger.Debug("📊 Analysis completed with insights")
+
+	return nil
+}
+
+// EmailNotificationProcessor handles email notification jobs
+type EmailNotificationProcessor struct{}
+
+func (p *EmailNotificationProcessor) JobType() jobs.JobType {
+	return jobs.JobEmailNotification
+}
+
+func (p *EmailNotificationProcessor) Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error {
+	logger := worker.JobLogger(slog.Default(), job)
+
+	logger.Debug("Processing email notification job", "recipients", len(payload.Recipients))
+
+	if err := simulateWork(ctx, time.Millisecond*300); err != nil {
+		return err
+	}
+
+	for _, recipient := range payload.Recipients {
+		logger.Debug("📬 Sending email", "recipient", recipient, "message", payload.Message)
+	}
+
+	return nil
+}
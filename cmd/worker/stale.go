@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"openapi-validation-example/internal/worker"
+	"openapi-validation-example/pkg/jobs"
+)
+
+// staleJobAge reads WORKER_STALE_JOB_AGE (a Go duration): how long a job may
+// stay processing before it's taken to be abandoned by a crashed worker and
+// reaped. The default is twice the longest job timeout in configs, so jobs
+// still running are never reaped.
+func staleJobAge(configs map[jobs.JobType]worker.JobTypeConfig) time.Duration {
+	longest := worker.DefaultJobTimeout
+	for _, config := range configs {
+		longest = max(longest, config.Timeout)
+	}
+	age := 2 * longest
+
+	if ageStr := os.Getenv("WORKER_STALE_JOB_AGE"); ageStr != "" {
+		parsed, err := time.ParseDuration(ageStr)
+		if err != nil || parsed <= 0 {
+			slog.Warn("Invalid WORKER_STALE_JOB_AGE, using default", "value", ageStr, "default", age)
+		} else {
+			if parsed <= longest {
+				slog.Warn("WORKER_STALE_JOB_AGE is not longer than the longest job timeout; running jobs may be reaped",
+					"value", parsed, "longest_timeout", longest)
+			}
+			age = parsed
+		}
+	}
+	return age
+}
+
+// reapStaleJobs returns jobs processing for longer than olderThan to the
+// queue, logging how many there were
+func reapStaleJobs(ctx context.Context, jobQueue *jobs.JobQueueService, olderThan time.Duration) {
+	reaped, err := jobQueue.ReapStaleJobs(ctx, olderThan)
+	if err != nil {
+		slog.Error("Failed to reap stale jobs", "error", err)
+		return
+	}
+	if reaped > 0 {
+		slog.Warn("Reaped jobs stuck processing", "count", reaped, "older_than", olderThan)
+	}
+}
+
+// reapJobsOfDeadWorkers returns jobs claimed by workers that stopped sending
+// heartbeats to the queue, logging how many there were
+func reapJobsOfDeadWorkers(ctx context.Context, jobQueue *jobs.JobQueueService) {
+	reaped, err := jobQueue.ReapJobsOfDeadWorkers(ctx, jobs.WorkerLivenessTimeout)
+	if err != nil {
+		slog.Error("Failed to reap jobs of dead workers", "error", err)
+		return
+	}
+	if reaped > 0 {
+		slog.Warn("Reaped jobs of dead workers", "count", reaped, "dead_after", jobs.WorkerLivenessTimeout)
+	}
+}
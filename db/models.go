@@ -6,8 +6,16 @@ package db
 
 import (
 	"database/sql"
+	"time"
 )
 
+type IdempotencyKey struct {
+	IdempotencyKey string       `db:"idempotency_key" json:"idempotency_key"`
+	UserID         int64        `db:"user_id" json:"user_id"`
+	CreatedAt      sql.NullTime `db:"created_at" json:"created_at"`
+	ExpiresAt      time.Time    `db:"expires_at" json:"expires_at"`
+}
+
 type JobQueue struct {
 	ID           int64          `db:"id" json:"id"`
 	JobType      string         `db:"job_type" json:"job_type"`
@@ -21,6 +29,16 @@ type JobQueue struct {
 	StartedAt    sql.NullTime   `db:"started_at" json:"started_at"`
 	CompletedAt  sql.NullTime   `db:"completed_at" json:"completed_at"`
 	CreatedAt    sql.NullTime   `db:"created_at" json:"created_at"`
+	DurationMs   sql.NullInt64  `db:"duration_ms" json:"duration_ms"`
+	RequestID    sql.NullString `db:"request_id" json:"request_id"`
+	DedupKey     sql.NullString `db:"dedup_key" json:"dedup_key"`
+	WorkerID     sql.NullInt64  `db:"worker_id" json:"worker_id"`
+}
+
+type QueueSetting struct {
+	Name      string       `db:"name" json:"name"`
+	Value     string       `db:"value" json:"value"`
+	UpdatedAt sql.NullTime `db:"updated_at" json:"updated_at"`
 }
 
 type User struct {
@@ -34,3 +52,8 @@ type User struct {
 	CreatedAt      sql.NullTime   `db:"created_at" json:"created_at"`
 	UpdatedAt      sql.NullTime   `db:"updated_at" json:"updated_at"`
 }
+
+type WorkerHeartbeat struct {
+	WorkerID   int64     `db:"worker_id" json:"worker_id"`
+	LastSeenAt time.Time `db:"last_seen_at" json:"last_seen_at"`
+}
@@ -8,20 +8,124 @@ package db
 import (
 	"context"
 	"database/sql"
+	"strings"
+	"time"
 )
 
+const CancelJob = `-- name: CancelJob :execrows
+UPDATE job_queue
+SET status = 'cancelled'
+WHERE id = ? AND status = 'pending'
+`
+
+func (q *Queries) CancelJob(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, CancelJob, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const CheckJobQueue = `-- name: CheckJobQueue :exec
+SELECT id FROM job_queue LIMIT 1
+`
+
+func (q *Queries) CheckJobQueue(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, CheckJobQueue)
+	return err
+}
+
+const CountPendingJobs = `-- name: CountPendingJobs :one
+SELECT COUNT(*) FROM job_queue WHERE status = 'pending'
+`
+
+func (q *Queries) CountPendingJobs(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, CountPendingJobs)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const CountProcessingJobsByType = `-- name: CountProcessingJobsByType :many
+SELECT job_type, COUNT(*) as processing_count FROM job_queue
+WHERE status = 'processing'
+GROUP BY job_type
+ORDER BY job_type
+`
+
+type CountProcessingJobsByTypeRow struct {
+	JobType         string `db:"job_type" json:"job_type"`
+	ProcessingCount int64  `db:"processing_count" json:"processing_count"`
+}
+
+func (q *Queries) CountProcessingJobsByType(ctx context.Context) ([]CountProcessingJobsByTypeRow, error) {
+	rows, err := q.db.QueryContext(ctx, CountProcessingJobsByType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CountProcessingJobsByTypeRow{}
+	for rows.Next() {
+		var i CountProcessingJobsByTypeRow
+		if err := rows.Scan(&i.JobType, &i.ProcessingCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const CountUsers = `-- name: CountUsers :one
+SELECT COUNT(*) FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, CountUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const CountUsersByActive = `-- name: CountUsersByActive :one
+SELECT
+    COUNT(CASE WHEN is_active THEN 1 END) as active_count,
+    COUNT(CASE WHEN NOT is_active THEN 1 END) as inactive_count
+FROM users
+`
+
+type CountUsersByActiveRow struct {
+	ActiveCount   int64 `db:"active_count" json:"active_count"`
+	InactiveCount int64 `db:"inactive_count" json:"inactive_count"`
+}
+
+func (q *Queries) CountUsersByActive(ctx context.Context) (CountUsersByActiveRow, error) {
+	row := q.db.QueryRowContext(ctx, CountUsersByActive)
+	var i CountUsersByActiveRow
+	err := row.Scan(&i.ActiveCount, &i.InactiveCount)
+	return i, err
+}
+
 const CreateJob = `-- name: CreateJob :one
-INSERT INTO job_queue (job_type, payload, priority, max_retries, scheduled_at)
-VALUES (?, ?, ?, ?, ?)
-RETURNING id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at
+INSERT INTO job_queue (job_type, payload, priority, max_retries, scheduled_at, request_id, dedup_key)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (dedup_key) WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing') DO NOTHING
+RETURNING id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id
 `
 
 type CreateJobParams struct {
-	JobType     string        `db:"job_type" json:"job_type"`
-	Payload     string        `db:"payload" json:"payload"`
-	Priority    sql.NullInt64 `db:"priority" json:"priority"`
-	MaxRetries  sql.NullInt64 `db:"max_retries" json:"max_retries"`
-	ScheduledAt sql.NullTime  `db:"scheduled_at" json:"scheduled_at"`
+	JobType     string         `db:"job_type" json:"job_type"`
+	Payload     string         `db:"payload" json:"payload"`
+	Priority    sql.NullInt64  `db:"priority" json:"priority"`
+	MaxRetries  sql.NullInt64  `db:"max_retries" json:"max_retries"`
+	ScheduledAt sql.NullTime   `db:"scheduled_at" json:"scheduled_at"`
+	RequestID   sql.NullString `db:"request_id" json:"request_id"`
+	DedupKey    sql.NullString `db:"dedup_key" json:"dedup_key"`
 }
 
 // Job Queue Operations
@@ -32,6 +136,8 @@ func (q *Queries) CreateJob(ctx context.Context, arg CreateJobParams) (JobQueue,
 		arg.Priority,
 		arg.MaxRetries,
 		arg.ScheduledAt,
+		arg.RequestID,
+		arg.DedupKey,
 	)
 	var i JobQueue
 	err := row.Scan(
@@ -47,6 +153,10 @@ func (q *Queries) CreateJob(ctx context.Context, arg CreateJobParams) (JobQueue,
 		&i.StartedAt,
 		&i.CompletedAt,
 		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
 	)
 	return i, err
 }
@@ -90,23 +200,484 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 	return i, err
 }
 
-const DeleteUser = `-- name: DeleteUser :exec
+const DeferJob = `-- name: DeferJob :execrows
+UPDATE job_queue
+SET status = 'pending',
+    scheduled_at = ?1,
+    error_message = ?2,
+    started_at = NULL,
+    worker_id = NULL
+WHERE id = ?3 AND status = 'processing'
+`
+
+type DeferJobParams struct {
+	ScheduledAt  sql.NullTime   `db:"scheduled_at" json:"scheduled_at"`
+	ErrorMessage sql.NullString `db:"error_message" json:"error_message"`
+	ID           int64          `db:"id" json:"id"`
+}
+
+func (q *Queries) DeferJob(ctx context.Context, arg DeferJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, DeferJob, arg.ScheduledAt, arg.ErrorMessage, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const DeleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at <= CURRENT_TIMESTAMP
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, DeleteExpiredIdempotencyKeys)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const DeleteUser = `-- name: DeleteUser :one
 DELETE FROM users
 WHERE id = ?
+RETURNING id, email, age, name, bio, is_active, additional_data, created_at, updated_at
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, DeleteUser, id)
-	return err
+func (q *Queries) DeleteUser(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRowContext(ctx, DeleteUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.Age,
+		&i.Name,
+		&i.Bio,
+		&i.IsActive,
+		&i.AdditionalData,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const GetIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT idempotency_key, user_id, created_at, expires_at FROM idempotency_keys
+WHERE idempotency_key = ? AND expires_at > CURRENT_TIMESTAMP
+`
+
+// Idempotency Keys
+func (q *Queries) GetIdempotencyKey(ctx context.Context, idempotencyKey string) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, GetIdempotencyKey, idempotencyKey)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.IdempotencyKey,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const GetJobByID = `-- name: GetJobByID :one
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE id = ?
+`
+
+func (q *Queries) GetJobByID(ctx context.Context, id int64) (JobQueue, error) {
+	row := q.db.QueryRowContext(ctx, GetJobByID, id)
+	var i JobQueue
+	err := row.Scan(
+		&i.ID,
+		&i.JobType,
+		&i.Payload,
+		&i.Status,
+		&i.Priority,
+		&i.MaxRetries,
+		&i.RetryCount,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
+	)
+	return i, err
+}
+
+const GetJobDurationStats = `-- name: GetJobDurationStats :many
+SELECT
+    job_type,
+    COUNT(duration_ms) as job_count,
+    AVG(duration_ms) as avg_duration_ms
+FROM job_queue
+WHERE duration_ms IS NOT NULL
+GROUP BY job_type
+ORDER BY job_type
+`
+
+type GetJobDurationStatsRow struct {
+	JobType       string          `db:"job_type" json:"job_type"`
+	JobCount      int64           `db:"job_count" json:"job_count"`
+	AvgDurationMs sql.NullFloat64 `db:"avg_duration_ms" json:"avg_duration_ms"`
+}
+
+func (q *Queries) GetJobDurationStats(ctx context.Context) ([]GetJobDurationStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, GetJobDurationStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetJobDurationStatsRow{}
+	for rows.Next() {
+		var i GetJobDurationStatsRow
+		if err := rows.Scan(&i.JobType, &i.JobCount, &i.AvgDurationMs); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetJobStats = `-- name: GetJobStats :one
+SELECT
+    COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_count,
+    COUNT(CASE WHEN status = 'processing' THEN 1 END) as processing_count,
+    COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_count,
+    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_count,
+    COUNT(CASE WHEN status = 'cancelled' THEN 1 END) as cancelled_count
+FROM job_queue
+`
+
+type GetJobStatsRow struct {
+	PendingCount    int64 `db:"pending_count" json:"pending_count"`
+	ProcessingCount int64 `db:"processing_count" json:"processing_count"`
+	CompletedCount  int64 `db:"completed_count" json:"completed_count"`
+	FailedCount     int64 `db:"failed_count" json:"failed_count"`
+	CancelledCount  int64 `db:"cancelled_count" json:"cancelled_count"`
+}
+
+func (q *Queries) GetJobStats(ctx context.Context) (GetJobStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, GetJobStats)
+	var i GetJobStatsRow
+	err := row.Scan(
+		&i.PendingCount,
+		&i.ProcessingCount,
+		&i.CompletedCount,
+		&i.FailedCount,
+		&i.CancelledCount,
+	)
+	return i, err
+}
+
+const GetJobStatsByType = `-- name: GetJobStatsByType :many
+SELECT
+    job_type,
+    COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_count,
+    COUNT(CASE WHEN status = 'processing' THEN 1 END) as processing_count,
+    COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_count,
+    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_count,
+    COUNT(CASE WHEN status = 'cancelled' THEN 1 END) as cancelled_count
+FROM job_queue
+GROUP BY job_type
+ORDER BY job_type
+`
+
+type GetJobStatsByTypeRow struct {
+	JobType         string `db:"job_type" json:"job_type"`
+	PendingCount    int64  `db:"pending_count" json:"pending_count"`
+	ProcessingCount int64  `db:"processing_count" json:"processing_count"`
+	CompletedCount  int64  `db:"completed_count" json:"completed_count"`
+	FailedCount     int64  `db:"failed_count" json:"failed_count"`
+	CancelledCount  int64  `db:"cancelled_count" json:"cancelled_count"`
+}
+
+func (q *Queries) GetJobStatsByType(ctx context.Context) ([]GetJobStatsByTypeRow, error) {
+	rows, err := q.db.QueryContext(ctx, GetJobStatsByType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetJobStatsByTypeRow{}
+	for rows.Next() {
+		var i GetJobStatsByTypeRow
+		if err := rows.Scan(
+			&i.JobType,
+			&i.PendingCount,
+			&i.ProcessingCount,
+			&i.CompletedCount,
+			&i.FailedCount,
+			&i.CancelledCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetJobStatuses = `-- name: GetJobStatuses :many
+SELECT id, status, error_message FROM job_queue
+WHERE id IN (/*SLICE:ids*/?)
+ORDER BY id
+`
+
+type GetJobStatusesRow struct {
+	ID           int64          `db:"id" json:"id"`
+	Status       string         `db:"status" json:"status"`
+	ErrorMessage sql.NullString `db:"error_message" json:"error_message"`
+}
+
+func (q *Queries) GetJobStatuses(ctx context.Context, ids []int64) ([]GetJobStatusesRow, error) {
+	query := GetJobStatuses
+	var queryParams []interface{}
+	if len(ids) > 0 {
+		for _, v := range ids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:ids*/?", strings.Repeat(",?", len(ids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:ids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetJobStatusesRow{}
+	for rows.Next() {
+		var i GetJobStatusesRow
+		if err := rows.Scan(&i.ID, &i.Status, &i.ErrorMessage); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetJobsForUser = `-- name: GetJobsForUser :many
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE json_extract(payload, '$.user_id') = ?
+ORDER BY created_at ASC, id ASC
+`
+
+func (q *Queries) GetJobsForUser(ctx context.Context, userID interface{}) ([]JobQueue, error) {
+	rows, err := q.db.QueryContext(ctx, GetJobsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobQueue{}
+	for rows.Next() {
+		var i JobQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.JobType,
+			&i.Payload,
+			&i.Status,
+			&i.Priority,
+			&i.MaxRetries,
+			&i.RetryCount,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestID,
+			&i.DedupKey,
+			&i.WorkerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetNextPendingJob = `-- name: GetNextPendingJob :one
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= ?
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT 1
+`
+
+func (q *Queries) GetNextPendingJob(ctx context.Context, now sql.NullTime) (JobQueue, error) {
+	row := q.db.QueryRowContext(ctx, GetNextPendingJob, now)
+	var i JobQueue
+	err := row.Scan(
+		&i.ID,
+		&i.JobType,
+		&i.Payload,
+		&i.Status,
+		&i.Priority,
+		&i.MaxRetries,
+		&i.RetryCount,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
+	)
+	return i, err
+}
+
+const GetNextPendingJobs = `-- name: GetNextPendingJobs :many
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= ?
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT ?
+`
+
+type GetNextPendingJobsParams struct {
+	Now   sql.NullTime `db:"now" json:"now"`
+	Limit int64        `db:"limit" json:"limit"`
+}
+
+func (q *Queries) GetNextPendingJobs(ctx context.Context, arg GetNextPendingJobsParams) ([]JobQueue, error) {
+	rows, err := q.db.QueryContext(ctx, GetNextPendingJobs, arg.Now, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobQueue{}
+	for rows.Next() {
+		var i JobQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.JobType,
+			&i.Payload,
+			&i.Status,
+			&i.Priority,
+			&i.MaxRetries,
+			&i.RetryCount,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestID,
+			&i.DedupKey,
+			&i.WorkerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetNextPendingJobsForType = `-- name: GetNextPendingJobsForType :many
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE status = 'pending'
+  AND job_type = ?
+  AND scheduled_at <= ?
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT ?
+`
+
+type GetNextPendingJobsForTypeParams struct {
+	JobType string       `db:"job_type" json:"job_type"`
+	Now     sql.NullTime `db:"now" json:"now"`
+	Limit   int64        `db:"limit" json:"limit"`
+}
+
+func (q *Queries) GetNextPendingJobsForType(ctx context.Context, arg GetNextPendingJobsForTypeParams) ([]JobQueue, error) {
+	rows, err := q.db.QueryContext(ctx, GetNextPendingJobsForType, arg.JobType, arg.Now, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobQueue{}
+	for rows.Next() {
+		var i JobQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.JobType,
+			&i.Payload,
+			&i.Status,
+			&i.Priority,
+			&i.MaxRetries,
+			&i.RetryCount,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestID,
+			&i.DedupKey,
+			&i.WorkerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const GetJobByID = `-- name: GetJobByID :one
-SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at FROM job_queue
-WHERE id = ?
+const GetOldestDuePendingJob = `-- name: GetOldestDuePendingJob :one
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= ?
+  AND retry_count < max_retries
+ORDER BY scheduled_at ASC, id ASC
+LIMIT 1
 `
 
-func (q *Queries) GetJobByID(ctx context.Context, id int64) (JobQueue, error) {
-	row := q.db.QueryRowContext(ctx, GetJobByID, id)
+func (q *Queries) GetOldestDuePendingJob(ctx context.Context, now sql.NullTime) (JobQueue, error) {
+	row := q.db.QueryRowContext(ctx, GetOldestDuePendingJob, now)
 	var i JobQueue
 	err := row.Scan(
 		&i.ID,
@@ -121,49 +692,67 @@ func (q *Queries) GetJobByID(ctx context.Context, id int64) (JobQueue, error) {
 		&i.StartedAt,
 		&i.CompletedAt,
 		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
 	)
 	return i, err
 }
 
-const GetJobStats = `-- name: GetJobStats :one
-SELECT
-    COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_count,
-    COUNT(CASE WHEN status = 'processing' THEN 1 END) as processing_count,
-    COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_count,
-    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_count
-FROM job_queue
+const GetPendingJobTypes = `-- name: GetPendingJobTypes :many
+SELECT DISTINCT job_type FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= ?
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY job_type
 `
 
-type GetJobStatsRow struct {
-	PendingCount    int64 `db:"pending_count" json:"pending_count"`
-	ProcessingCount int64 `db:"processing_count" json:"processing_count"`
-	CompletedCount  int64 `db:"completed_count" json:"completed_count"`
-	FailedCount     int64 `db:"failed_count" json:"failed_count"`
+func (q *Queries) GetPendingJobTypes(ctx context.Context, now sql.NullTime) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, GetPendingJobTypes, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var job_type string
+		if err := rows.Scan(&job_type); err != nil {
+			return nil, err
+		}
+		items = append(items, job_type)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetJobStats(ctx context.Context) (GetJobStatsRow, error) {
-	row := q.db.QueryRowContext(ctx, GetJobStats)
-	var i GetJobStatsRow
-	err := row.Scan(
-		&i.PendingCount,
-		&i.ProcessingCount,
-		&i.CompletedCount,
-		&i.FailedCount,
-	)
+const GetQueueSetting = `-- name: GetQueueSetting :one
+SELECT name, value, updated_at FROM queue_settings
+WHERE name = ?
+`
+
+// Queue Settings
+func (q *Queries) GetQueueSetting(ctx context.Context, name string) (QueueSetting, error) {
+	row := q.db.QueryRowContext(ctx, GetQueueSetting, name)
+	var i QueueSetting
+	err := row.Scan(&i.Name, &i.Value, &i.UpdatedAt)
 	return i, err
 }
 
-const GetNextPendingJob = `-- name: GetNextPendingJob :one
-SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at FROM job_queue
-WHERE status = 'pending'
-  AND scheduled_at <= CURRENT_TIMESTAMP
-  AND retry_count < max_retries
-ORDER BY priority DESC, scheduled_at ASC
+const GetUnhandledJobByDedupKey = `-- name: GetUnhandledJobByDedupKey :one
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE dedup_key = ? AND status IN ('pending', 'processing')
 LIMIT 1
 `
 
-func (q *Queries) GetNextPendingJob(ctx context.Context) (JobQueue, error) {
-	row := q.db.QueryRowContext(ctx, GetNextPendingJob)
+func (q *Queries) GetUnhandledJobByDedupKey(ctx context.Context, dedupKey sql.NullString) (JobQueue, error) {
+	row := q.db.QueryRowContext(ctx, GetUnhandledJobByDedupKey, dedupKey)
 	var i JobQueue
 	err := row.Scan(
 		&i.ID,
@@ -178,6 +767,10 @@ func (q *Queries) GetNextPendingJob(ctx context.Context) (JobQueue, error) {
 		&i.StartedAt,
 		&i.CompletedAt,
 		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
 	)
 	return i, err
 }
@@ -230,19 +823,20 @@ const IncrementJobRetry = `-- name: IncrementJobRetry :one
 UPDATE job_queue
 SET retry_count = retry_count + 1,
     status = 'pending',
-    scheduled_at = datetime(CURRENT_TIMESTAMP, '+' || (retry_count + 1) * 5 || ' minutes'),
+    scheduled_at = datetime(?, '+' || ((retry_count + 1) * 5) || ' minutes'),
     error_message = ?
 WHERE id = ?
-RETURNING id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at
+RETURNING id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id
 `
 
 type IncrementJobRetryParams struct {
+	Now          interface{}    `db:"now" json:"now"`
 	ErrorMessage sql.NullString `db:"error_message" json:"error_message"`
 	ID           int64          `db:"id" json:"id"`
 }
 
 func (q *Queries) IncrementJobRetry(ctx context.Context, arg IncrementJobRetryParams) (JobQueue, error) {
-	row := q.db.QueryRowContext(ctx, IncrementJobRetry, arg.ErrorMessage, arg.ID)
+	row := q.db.QueryRowContext(ctx, IncrementJobRetry, arg.Now, arg.ErrorMessage, arg.ID)
 	var i JobQueue
 	err := row.Scan(
 		&i.ID,
@@ -257,24 +851,139 @@ func (q *Queries) IncrementJobRetry(ctx context.Context, arg IncrementJobRetryPa
 		&i.StartedAt,
 		&i.CompletedAt,
 		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
 	)
 	return i, err
 }
 
 const ListJobs = `-- name: ListJobs :many
-SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at FROM job_queue
-WHERE status = ?
-ORDER BY created_at DESC
-LIMIT ?
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE (?1 = '' OR status = ?1)
+  AND (?2 = '' OR job_type = ?2)
+ORDER BY created_at DESC, id DESC
+LIMIT ?3
 `
 
 type ListJobsParams struct {
-	Status string `db:"status" json:"status"`
-	Limit  int64  `db:"limit" json:"limit"`
+	Status  string `db:"status" json:"status"`
+	JobType string `db:"job_type" json:"job_type"`
+	Limit   int64  `db:"limit" json:"limit"`
 }
 
 func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]JobQueue, error) {
-	rows, err := q.db.QueryContext(ctx, ListJobs, arg.Status, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, ListJobs, arg.Status, arg.JobType, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobQueue{}
+	for rows.Next() {
+		var i JobQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.JobType,
+			&i.Payload,
+			&i.Status,
+			&i.Priority,
+			&i.MaxRetries,
+			&i.RetryCount,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestID,
+			&i.DedupKey,
+			&i.WorkerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListJobsAfterID = `-- name: ListJobsAfterID :many
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE (?1 = '' OR status = ?1)
+  AND (?2 = '' OR job_type = ?2)
+  AND id > ?3
+ORDER BY id ASC
+LIMIT ?4
+`
+
+type ListJobsAfterIDParams struct {
+	Status  string `db:"status" json:"status"`
+	JobType string `db:"job_type" json:"job_type"`
+	AfterID int64  `db:"after_id" json:"after_id"`
+	Limit   int64  `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListJobsAfterID(ctx context.Context, arg ListJobsAfterIDParams) ([]JobQueue, error) {
+	rows, err := q.db.QueryContext(ctx, ListJobsAfterID, arg.Status, arg.JobType, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobQueue{}
+	for rows.Next() {
+		var i JobQueue
+		if err := rows.Scan(
+			&i.ID,
+			&i.JobType,
+			&i.Payload,
+			&i.Status,
+			&i.Priority,
+			&i.MaxRetries,
+			&i.RetryCount,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestID,
+			&i.DedupKey,
+			&i.WorkerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListPendingJobsInClaimOrder = `-- name: ListPendingJobsInClaimOrder :many
+SELECT id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id FROM job_queue
+WHERE status = 'pending'
+  AND (?1 = '' OR job_type = ?1)
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT ?2
+`
+
+type ListPendingJobsInClaimOrderParams struct {
+	JobType string `db:"job_type" json:"job_type"`
+	Limit   int64  `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListPendingJobsInClaimOrder(ctx context.Context, arg ListPendingJobsInClaimOrderParams) ([]JobQueue, error) {
+	rows, err := q.db.QueryContext(ctx, ListPendingJobsInClaimOrder, arg.JobType, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -295,6 +1004,10 @@ func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]JobQueue,
 			&i.StartedAt,
 			&i.CompletedAt,
 			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestID,
+			&i.DedupKey,
+			&i.WorkerID,
 		); err != nil {
 			return nil, err
 		}
@@ -311,13 +1024,17 @@ func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]JobQueue,
 
 const ListUsers = `-- name: ListUsers :many
 SELECT id, email, age, name, bio, is_active, additional_data, created_at, updated_at FROM users
-WHERE is_active = true
-ORDER BY created_at DESC
-LIMIT ?
+ORDER BY id ASC
+LIMIT ? OFFSET ?
 `
 
-func (q *Queries) ListUsers(ctx context.Context, limit int64) ([]User, error) {
-	rows, err := q.db.QueryContext(ctx, ListUsers, limit)
+type ListUsersParams struct {
+	Limit  int64 `db:"limit" json:"limit"`
+	Offset int64 `db:"offset" json:"offset"`
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, ListUsers, arg.Limit, arg.Offset)
 	if err != nil {
 		return nil, err
 	}
@@ -349,11 +1066,214 @@ func (q *Queries) ListUsers(ctx context.Context, limit int64) ([]User, error) {
 	return items, nil
 }
 
+const ListWorkerHeartbeats = `-- name: ListWorkerHeartbeats :many
+SELECT worker_id, last_seen_at FROM worker_heartbeats
+WHERE last_seen_at >= ?1
+ORDER BY worker_id
+`
+
+func (q *Queries) ListWorkerHeartbeats(ctx context.Context, seenSince time.Time) ([]WorkerHeartbeat, error) {
+	rows, err := q.db.QueryContext(ctx, ListWorkerHeartbeats, seenSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []WorkerHeartbeat{}
+	for rows.Next() {
+		var i WorkerHeartbeat
+		if err := rows.Scan(&i.WorkerID, &i.LastSeenAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ReapJobsOfDeadWorkers = `-- name: ReapJobsOfDeadWorkers :execrows
+UPDATE job_queue
+SET retry_count = retry_count + 1,
+    status = CASE WHEN retry_count + 1 < max_retries THEN 'pending' ELSE 'failed' END,
+    scheduled_at = ?1,
+    started_at = NULL,
+    completed_at = CASE WHEN retry_count + 1 < max_retries THEN NULL ELSE ?1 END,
+    error_message = ?2
+WHERE status = 'processing' AND worker_id IS NOT NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM worker_heartbeats h
+    WHERE h.worker_id = job_queue.worker_id AND h.last_seen_at >= ?3
+  )
+`
+
+type ReapJobsOfDeadWorkersParams struct {
+	Now          sql.NullTime   `db:"now" json:"now"`
+	ErrorMessage sql.NullString `db:"error_message" json:"error_message"`
+	SeenSince    time.Time      `db:"seen_since" json:"seen_since"`
+}
+
+func (q *Queries) ReapJobsOfDeadWorkers(ctx context.Context, arg ReapJobsOfDeadWorkersParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, ReapJobsOfDeadWorkers, arg.Now, arg.ErrorMessage, arg.SeenSince)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const ReapStaleJobs = `-- name: ReapStaleJobs :execrows
+UPDATE job_queue
+SET retry_count = retry_count + 1,
+    status = CASE WHEN retry_count + 1 < max_retries THEN 'pending' ELSE 'failed' END,
+    scheduled_at = ?1,
+    started_at = NULL,
+    completed_at = CASE WHEN retry_count + 1 < max_retries THEN NULL ELSE ?1 END,
+    error_message = ?2
+WHERE status = 'processing' AND started_at < ?3
+`
+
+type ReapStaleJobsParams struct {
+	Now           sql.NullTime   `db:"now" json:"now"`
+	ErrorMessage  sql.NullString `db:"error_message" json:"error_message"`
+	StartedBefore sql.NullTime   `db:"started_before" json:"started_before"`
+}
+
+func (q *Queries) ReapStaleJobs(ctx context.Context, arg ReapStaleJobsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, ReapStaleJobs, arg.Now, arg.ErrorMessage, arg.StartedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const RenamePendingJobType = `-- name: RenamePendingJobType :execrows
+UPDATE job_queue
+SET job_type = ?1
+WHERE job_type = ?2 AND status = 'pending'
+`
+
+type RenamePendingJobTypeParams struct {
+	NewType string `db:"new_type" json:"new_type"`
+	OldType string `db:"old_type" json:"old_type"`
+}
+
+func (q *Queries) RenamePendingJobType(ctx context.Context, arg RenamePendingJobTypeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, RenamePendingJobType, arg.NewType, arg.OldType)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const ReplayFailedJobs = `-- name: ReplayFailedJobs :execrows
+UPDATE job_queue
+SET status = 'pending',
+    retry_count = 0,
+    scheduled_at = ?1,
+    error_message = NULL,
+    started_at = NULL,
+    completed_at = NULL
+WHERE status = 'failed' AND (?2 = '' OR job_type = ?2)
+`
+
+type ReplayFailedJobsParams struct {
+	Now     sql.NullTime `db:"now" json:"now"`
+	JobType string       `db:"job_type" json:"job_type"`
+}
+
+func (q *Queries) ReplayFailedJobs(ctx context.Context, arg ReplayFailedJobsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, ReplayFailedJobs, arg.Now, arg.JobType)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const RequeueJob = `-- name: RequeueJob :execrows
+UPDATE job_queue
+SET status = 'pending',
+    retry_count = ?,
+    scheduled_at = ?,
+    error_message = NULL,
+    started_at = NULL,
+    completed_at = NULL
+WHERE id = ? AND status = 'failed'
+`
+
+type RequeueJobParams struct {
+	RetryCount  sql.NullInt64 `db:"retry_count" json:"retry_count"`
+	ScheduledAt sql.NullTime  `db:"scheduled_at" json:"scheduled_at"`
+	ID          int64         `db:"id" json:"id"`
+}
+
+func (q *Queries) RequeueJob(ctx context.Context, arg RequeueJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, RequeueJob, arg.RetryCount, arg.ScheduledAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const SetJobPriorityForStatus = `-- name: SetJobPriorityForStatus :execrows
+UPDATE job_queue
+SET priority = ?
+WHERE job_type = ? AND status = ?
+`
+
+type SetJobPriorityForStatusParams struct {
+	Priority sql.NullInt64 `db:"priority" json:"priority"`
+	JobType  string        `db:"job_type" json:"job_type"`
+	Status   string        `db:"status" json:"status"`
+}
+
+func (q *Queries) SetJobPriorityForStatus(ctx context.Context, arg SetJobPriorityForStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, SetJobPriorityForStatus, arg.Priority, arg.JobType, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const SetJobWorker = `-- name: SetJobWorker :exec
+UPDATE job_queue
+SET worker_id = ?
+WHERE id = ?
+`
+
+type SetJobWorkerParams struct {
+	WorkerID sql.NullInt64 `db:"worker_id" json:"worker_id"`
+	ID       int64         `db:"id" json:"id"`
+}
+
+func (q *Queries) SetJobWorker(ctx context.Context, arg SetJobWorkerParams) error {
+	_, err := q.db.ExecContext(ctx, SetJobWorker, arg.WorkerID, arg.ID)
+	return err
+}
+
+const UpdateJobDuration = `-- name: UpdateJobDuration :exec
+UPDATE job_queue
+SET duration_ms = ?
+WHERE id = ?
+`
+
+type UpdateJobDurationParams struct {
+	DurationMs sql.NullInt64 `db:"duration_ms" json:"duration_ms"`
+	ID         int64         `db:"id" json:"id"`
+}
+
+func (q *Queries) UpdateJobDuration(ctx context.Context, arg UpdateJobDurationParams) error {
+	_, err := q.db.ExecContext(ctx, UpdateJobDuration, arg.DurationMs, arg.ID)
+	return err
+}
+
 const UpdateJobStatus = `-- name: UpdateJobStatus :one
 UPDATE job_queue
 SET status = ?, started_at = ?, completed_at = ?, error_message = ?
 WHERE id = ?
-RETURNING id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at
+RETURNING id, job_type, payload, status, priority, max_retries, retry_count, error_message, scheduled_at, started_at, completed_at, created_at, duration_ms, request_id, dedup_key, worker_id
 `
 
 type UpdateJobStatusParams struct {
@@ -386,6 +1306,10 @@ func (q *Queries) UpdateJobStatus(ctx context.Context, arg UpdateJobStatusParams
 		&i.StartedAt,
 		&i.CompletedAt,
 		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestID,
+		&i.DedupKey,
+		&i.WorkerID,
 	)
 	return i, err
 }
@@ -431,3 +1355,56 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 	)
 	return i, err
 }
+
+const UpsertIdempotencyKey = `-- name: UpsertIdempotencyKey :exec
+INSERT INTO idempotency_keys (idempotency_key, user_id, expires_at)
+VALUES (?, ?, datetime(CURRENT_TIMESTAMP, ?))
+ON CONFLICT (idempotency_key) DO UPDATE
+SET user_id = excluded.user_id, created_at = CURRENT_TIMESTAMP, expires_at = excluded.expires_at
+`
+
+type UpsertIdempotencyKeyParams struct {
+	IdempotencyKey string      `db:"idempotency_key" json:"idempotency_key"`
+	UserID         int64       `db:"user_id" json:"user_id"`
+	ExpiresIn      interface{} `db:"expires_in" json:"expires_in"`
+}
+
+func (q *Queries) UpsertIdempotencyKey(ctx context.Context, arg UpsertIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, UpsertIdempotencyKey, arg.IdempotencyKey, arg.UserID, arg.ExpiresIn)
+	return err
+}
+
+const UpsertQueueSetting = `-- name: UpsertQueueSetting :exec
+INSERT INTO queue_settings (name, value)
+VALUES (?, ?)
+ON CONFLICT (name) DO UPDATE
+SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+`
+
+type UpsertQueueSettingParams struct {
+	Name  string `db:"name" json:"name"`
+	Value string `db:"value" json:"value"`
+}
+
+func (q *Queries) UpsertQueueSetting(ctx context.Context, arg UpsertQueueSettingParams) error {
+	_, err := q.db.ExecContext(ctx, UpsertQueueSetting, arg.Name, arg.Value)
+	return err
+}
+
+const UpsertWorkerHeartbeat = `-- name: UpsertWorkerHeartbeat :exec
+INSERT INTO worker_heartbeats (worker_id, last_seen_at)
+VALUES (?, ?)
+ON CONFLICT (worker_id) DO UPDATE
+SET last_seen_at = excluded.last_seen_at
+`
+
+type UpsertWorkerHeartbeatParams struct {
+	WorkerID   int64     `db:"worker_id" json:"worker_id"`
+	LastSeenAt time.Time `db:"last_seen_at" json:"last_seen_at"`
+}
+
+// Worker Heartbeats
+func (q *Queries) UpsertWorkerHeartbeat(ctx context.Context, arg UpsertWorkerHeartbeatParams) error {
+	_, err := q.db.ExecContext(ctx, UpsertWorkerHeartbeat, arg.WorkerID, arg.LastSeenAt)
+	return err
+}
@@ -4,11 +4,42 @@
 package generated
 
 import (
+	"time"
+
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
+const (
+	ApiKeyAuthScopes = "ApiKeyAuth.Scopes"
+)
+
+// BatchUserResponse defines model for BatchUserResponse.
+type BatchUserResponse struct {
+	// Created Number of users created
+	Created int `json:"created"`
+
+	// Failed Number of users not created
+	Failed int `json:"failed"`
+
+	// Results One result per user, in request order
+	Results []BatchUserResult `json:"results"`
+}
+
+// BatchUserResult defines model for BatchUserResult.
+type BatchUserResult struct {
+	// Error Why the user was not created
+	Error *string `json:"error,omitempty"`
+
+	// Index Position of the user in the request
+	Index int   `json:"index"`
+	User  *User `json:"user,omitempty"`
+}
+
 // ErrorResponse defines model for ErrorResponse.
 type ErrorResponse struct {
+	// Details Every validation failure, when the server reports them all
+	Details *[]string `json:"details,omitempty"`
+
 	// Error Error message
 	Error string `json:"error"`
 }
@@ -21,6 +52,9 @@ type User struct {
 	// Bio User biography (optional)
 	Bio *string `json:"bio,omitempty"`
 
+	// CreatedAt When the user was created
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
 	// Email User email address
 	Email openapi_types.Email `json:"email"`
 
@@ -32,6 +66,16 @@ type User struct {
 
 	// Name User name (optional)
 	Name *string `json:"name,omitempty"`
+
+	// UpdatedAt When the user was last modified
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// UserList defines model for UserList.
+type UserList struct {
+	// Total Total number of users
+	Total int64  `json:"total"`
+	Users []User `json:"users"`
 }
 
 // UserRequest defines model for UserRequest.
@@ -52,5 +96,29 @@ type UserRequest struct {
 	Name *string `json:"name,omitempty"`
 }
 
+// ListUsersParams defines parameters for ListUsers.
+type ListUsersParams struct {
+	// Limit Maximum number of users to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Offset Number of users to skip
+	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
+}
+
+// CreateUsersBatchJSONBody defines parameters for CreateUsersBatch.
+type CreateUsersBatchJSONBody = []UserRequest
+
+// CreateUsersBatchParams defines parameters for CreateUsersBatch.
+type CreateUsersBatchParams struct {
+	// AllOrNothing Create none of the users if any of them can't be created
+	AllOrNothing *bool `form:"all_or_nothing,omitempty" json:"all_or_nothing,omitempty"`
+}
+
 // CreateUserJSONRequestBody defines body for CreateUser for application/json ContentType.
 type CreateUserJSONRequestBody = UserRequest
+
+// CreateUserFormdataRequestBody defines body for CreateUser for application/x-www-form-urlencoded ContentType.
+type CreateUserFormdataRequestBody = UserRequest
+
+// CreateUsersBatchJSONRequestBody defines body for CreateUsersBatch for application/json ContentType.
+type CreateUsersBatchJSONRequestBody = CreateUsersBatchJSONBody
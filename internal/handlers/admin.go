@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminPrefix groups operator endpoints. Like the health probes they are not
+// part of the OpenAPI spec and are not validated.
+const AdminPrefix = "/admin"
+
+// JobStatsResponse is the JSON body of GET /admin/jobs/stats
+type JobStatsResponse struct {
+	Pending    int64 `json:"pending"`
+	Processing int64 `json:"processing"`
+	Completed  int64 `json:"completed"`
+	Failed     int64 `json:"failed"`
+	Cancelled  int64 `json:"cancelled"`
+	Total      int64 `json:"total"`
+}
+
+// UserStatsResponse is the JSON body of GET /admin/user-stats
+type UserStatsResponse struct {
+	Active   int64 `json:"active"`
+	Inactive int64 `json:"inactive"`
+	Total    int64 `json:"total"`
+}
+
+// RegisterAdminRoutes adds the admin route group. Middleware passed here
+// (e.g. authentication) applies to the admin routes only.
+func RegisterAdminRoutes(e *echo.Echo, db *database.DatabaseService, middleware ...echo.MiddlewareFunc) *echo.Group {
+	admin := e.Group(AdminPrefix, middleware...)
+	admin.GET("/jobs/stats", JobStats(db.JobQueue()))
+	admin.GET("/user-stats", UserStats(db))
+	return admin
+}
+
+// JobStats returns the job queue counts per status
+func JobStats(jobQueue jobs.Queue) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		stats, err := jobQueue.GetJobStats(ctx.Request().Context())
+		if err != nil {
+			return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+				"error": fmt.Sprintf("Failed to get job stats: %v", err),
+			})
+		}
+
+		return response.JSON(ctx, http.StatusOK, JobStatsResponse{
+			Pending:    stats.PendingCount,
+			Processing: stats.ProcessingCount,
+			Completed:  stats.CompletedCount,
+			Failed:     stats.FailedCount,
+			Cancelled:  stats.CancelledCount,
+			Total:      stats.PendingCount + stats.ProcessingCount + stats.CompletedCount + stats.FailedCount + stats.CancelledCount,
+		})
+	}
+}
+
+// UserStats returns the number of active and inactive users
+func UserStats(db *database.DatabaseService) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		active, inactive, err := db.CountUsersByActive()
+		if err != nil {
+			return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+				"error": fmt.Sprintf("Failed to get user stats: %v", err),
+			})
+		}
+
+		return response.JSON(ctx, http.StatusOK, UserStatsResponse{
+			Active:   active,
+			Inactive: inactive,
+			Total:    active + inactive,
+		})
+	}
+}
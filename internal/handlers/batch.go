@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"openapi-validation-example/generated"
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BatchCreator creates the users of a POST /users/batch request, like
+// database.DatabaseService.CreateUsersContext
+type BatchCreator func(users []database.BatchUser, allOrNothing bool) ([]database.BatchResult, error)
+
+// CreateUsersBatch serves POST /users/batch. Each user is decoded like
+// CreateUser decodes its body, those that pass ValidateAge are created with
+// create, and the response lists one result per user, in request order: 200,
+// or 409 when all_or_nothing is set and some user failed, so none were
+// created.
+func CreateUsersBatch(ctx echo.Context, params generated.CreateUsersBatchParams, create BatchCreator) error {
+	var rawUsers []map[string]interface{}
+	if err := ctx.Bind(&rawUsers); err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+	allOrNothing := params.AllOrNothing != nil && *params.AllOrNothing
+
+	results := make([]generated.BatchUserResult, len(rawUsers))
+	users := make([]database.BatchUser, 0, len(rawUsers))
+	// indexes holds the position in the request of each of users
+	indexes := make([]int, 0, len(rawUsers))
+	for i, raw := range rawUsers {
+		results[i].Index = i
+
+		req, additionalProps, err := ExtractAdditionalProps(raw)
+		if err == nil {
+			err = ValidateAge(req.Age)
+		}
+		if err != nil {
+			results[i].Error = batchError(err)
+			continue
+		}
+		users = append(users, database.BatchUser{Request: req, AdditionalProps: additionalProps})
+		indexes = append(indexes, i)
+	}
+
+	var created []database.BatchResult
+	if allOrNothing && len(users) < len(rawUsers) {
+		created = make([]database.BatchResult, len(users))
+		for j := range created {
+			created[j].Err = database.ErrBatchRolledBack
+		}
+	} else if len(users) > 0 {
+		var err error
+		created, err = create(users, allOrNothing)
+		if err != nil {
+			return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+	for j, result := range created {
+		if result.Err != nil {
+			results[indexes[j]].Error = batchError(result.Err)
+		} else {
+			results[indexes[j]].User = result.User
+		}
+	}
+
+	resp := generated.BatchUserResponse{Results: results}
+	for _, result := range results {
+		if result.User != nil {
+			resp.Created++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	status := http.StatusOK
+	if allOrNothing && resp.Failed > 0 {
+		status = http.StatusConflict
+	}
+	return response.JSON(ctx, status, resp)
+}
+
+// batchError describes why a user in a batch was not created, in the words
+// POST /users would use
+func batchError(err error) *string {
+	message := err.Error()
+	switch {
+	case errors.Is(err, database.ErrEmailTaken):
+		message = emailTakenMessage
+	case errors.Is(err, jobs.ErrQueueFull):
+		message = queueFullMessage
+	}
+	return &message
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"openapi-validation-example/generated"
+	"openapi-validation-example/pkg/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HTTPErrorHandler renders errors that escape the handlers (unknown routes,
+// malformed path parameters, panics caught by Recover) with the same
+// ErrorResponse envelope the OpenAPI spec declares for 4XX/5XX responses,
+// instead of Echo's default {"message": ...} body.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	code := http.StatusInternalServerError
+	message := http.StatusText(code)
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code = he.Code
+		if msg, ok := he.Message.(string); ok {
+			message = msg
+		} else {
+			message = fmt.Sprint(he.Message)
+		}
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(code)
+	} else {
+		err = response.JSON(c, code, generated.ErrorResponse{Error: message})
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"openapi-validation-example/generated"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userRequestFields holds the JSON keys of generated.UserRequest. It is
+// derived from the struct tags, so regenerating the types from the spec keeps
+// it in sync.
+var userRequestFields = sync.OnceValue(func() map[string]bool {
+	return JSONFieldNames(reflect.TypeOf(generated.UserRequest{}))
+})
+
+// JSONFieldNames returns the object keys encoding/json uses for struct type
+// t, including the promoted fields of embedded structs. Fields tagged "-" and
+// unexported fields are left out.
+func JSONFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	addJSONFieldNames(t, names)
+	return names
+}
+
+func addJSONFieldNames(t reflect.Type, names map[string]bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			addJSONFieldNames(field.Type, names)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+}
+
+// UserRequestFields returns the JSON keys of generated.UserRequest. The map
+// is shared and must not be modified.
+func UserRequestFields() map[string]bool {
+	return userRequestFields()
+}
+
+// AdditionalProps returns the entries of a decoded request body whose keys
+// are not in known, i.e. the additional properties to store with the user.
+// Handlers pass UserRequestFields().
+func AdditionalProps(body map[string]interface{}, known map[string]bool) map[string]interface{} {
+	additionalProps := make(map[string]interface{})
+	for key, value := range body {
+		if !known[key] {
+			additionalProps[key] = value
+		}
+	}
+	return additionalProps
+}
+
+// ExtractAdditionalProps splits a decoded POST /users body into the
+// UserRequest it describes and its additional properties. Handlers bind the
+// body once, into a map, since it can only be read once.
+func ExtractAdditionalProps(raw map[string]interface{}) (generated.UserRequest, map[string]interface{}, error) {
+	var req generated.UserRequest
+	reqBytes, err := json.Marshal(raw)
+	if err != nil {
+		return req, nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	if err := json.Unmarshal(reqBytes, &req); err != nil {
+		return req, nil, fmt.Errorf("failed to decode user request: %w", err)
+	}
+	return req, AdditionalProps(raw, UserRequestFields()), nil
+}
+
+// userRequestKinds maps the JSON keys of generated.UserRequest to the kind of
+// value each holds, for converting form fields
+var userRequestKinds = sync.OnceValue(func() map[string]reflect.Kind {
+	kinds := make(map[string]reflect.Kind)
+	t := reflect.TypeOf(generated.UserRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		kinds[name] = fieldType.Kind()
+	}
+	return kinds
+})
+
+// BindUserBody decodes a POST /users body into the map ExtractAdditionalProps
+// takes. JSON is bound as is; a form-encoded body is converted to the map the
+// same fields in JSON would decode to.
+func BindUserBody(ctx echo.Context) (map[string]interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(ctx.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != echo.MIMEApplicationForm {
+		var body map[string]interface{}
+		if err := ctx.Bind(&body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+
+	values, err := ctx.FormParams()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse form: %w", err)
+	}
+	return formUserBody(values)
+}
+
+// formUserBody converts form fields to the types of the UserRequest fields
+// they set. Other fields, stored as additional properties, stay strings.
+func formUserBody(values url.Values) (map[string]interface{}, error) {
+	body := make(map[string]interface{}, len(values))
+	for name := range values {
+		value := values.Get(name)
+		switch userRequestKinds()[name] {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("form field %q is not an integer: %w", name, err)
+			}
+			body[name] = n
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("form field %q is not a boolean: %w", name, err)
+			}
+			body[name] = b
+		default:
+			body[name] = value
+		}
+	}
+	return body, nil
+}
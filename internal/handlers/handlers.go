@@ -1,16 +1,139 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 
 	"openapi-validation-example/generated"
 	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/response"
 
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// DefaultListLimit is the page size used when ListUsers is called without a limit
+	DefaultListLimit = 20
+	// MaxListLimit caps the page size, matching the limit maximum in the OpenAPI spec
+	MaxListLimit = 100
+)
+
+// IdempotencyKeyHeader lets clients retry POST /users safely: a repeated key
+// returns the user created by the first request with 200 instead of
+// creating another one. Only the database handler supports it.
+const (
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	MaxIdempotencyKeyLength = 255
+)
+
+// QueueFullRetryAfter is the Retry-After, in seconds, sent with the 503 a
+// create gets while the job queue is at its cap (see jobs.ErrQueueFull)
+const QueueFullRetryAfter = 5
+
+const (
+	queueFullMessage  = "Job queue is full, try again later"
+	emailTakenMessage = "A user with this email address already exists"
+)
+
+// RespondQueueFull rejects a request whose job could not be enqueued because
+// the queue is full, asking the client to retry later.
+func RespondQueueFull(ctx echo.Context) error {
+	ctx.Response().Header().Set("Retry-After", strconv.Itoa(QueueFullRetryAfter))
+	return response.JSON(ctx, http.StatusServiceUnavailable, map[string]string{
+		"error": queueFullMessage,
+	})
+}
+
+// RespondEmailTaken rejects a create whose email address another user already
+// has (see database.ErrEmailTaken)
+func RespondEmailTaken(ctx echo.Context) error {
+	return response.JSON(ctx, http.StatusConflict, map[string]string{
+		"error": emailTakenMessage,
+	})
+}
+
+// Age is an int in the generated types but an INTEGER (int64) column in the
+// database. Bounding it keeps the value meaningful and well clear of int
+// overflow on 32-bit platforms.
+const (
+	MinAge = 0
+	MaxAge = 150
+)
+
+// ValidateAge checks that age is within MinAge..MaxAge
+func ValidateAge(age int) error {
+	if age < MinAge || age > MaxAge {
+		return fmt.Errorf("age must be between %d and %d", MinAge, MaxAge)
+	}
+	return nil
+}
+
+// RequestContext returns the request's context carrying the X-Request-ID set
+// by Echo's RequestID middleware, so jobs enqueued while serving the request
+// record it.
+func RequestContext(ctx echo.Context) context.Context {
+	requestCtx := ctx.Request().Context()
+	if requestID := ctx.Response().Header().Get(echo.HeaderXRequestID); requestID != "" {
+		return jobs.ContextWithRequestID(requestCtx, requestID)
+	}
+	return requestCtx
+}
+
+// ListPage resolves the optional pagination params to a concrete limit and offset
+func ListPage(params generated.ListUsersParams) (int, int) {
+	limit := DefaultListLimit
+	if params.Limit != nil && *params.Limit > 0 {
+		limit = *params.Limit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	offset := 0
+	if params.Offset != nil && *params.Offset > 0 {
+		offset = *params.Offset
+	}
+
+	return limit, offset
+}
+
+// MergeAdditionalProps flattens the additional properties stored for a user
+// into the user's JSON object. Defined fields always win over additional
+// properties with the same name.
+func MergeAdditionalProps(user *generated.User, additionalProps map[string]interface{}) (interface{}, error) {
+	if len(additionalProps) == 0 {
+		return user, nil
+	}
+
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	var merged map[string]interface{}
+	if err := json.Unmarshal(userJSON, &merged); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	for key, value := range additionalProps {
+		if _, exists := merged[key]; !exists {
+			merged[key] = value
+		}
+	}
+
+	return merged, nil
+}
+
 // InMemoryUserHandler implements the generated.ServerInterface (in-memory version)
 type InMemoryUserHandler struct {
+	mu     sync.RWMutex
 	Users  map[int64]generated.User
 	NextID int64
 }
@@ -24,13 +147,58 @@ func NewInMemoryUserHandler() *InMemoryUserHandler {
 
 // CreateUser implements the generated.ServerInterface.CreateUser method
 func (h *InMemoryUserHandler) CreateUser(ctx echo.Context) error {
-	var req generated.UserRequest
-	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
+	contentType, err := Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := BindUserBody(ctx)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+	req, _, err := ExtractAdditionalProps(body)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
 			"error": "Invalid JSON format",
 		})
 	}
 
+	if err := ValidateAge(req.Age); err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	h.mu.Lock()
+	user := h.addUser(req)
+	h.mu.Unlock()
+
+	return RespondUser(ctx, http.StatusCreated, contentType, &user, nil)
+}
+
+// CreateUsersBatch implements the generated.ServerInterface.CreateUsersBatch method
+func (h *InMemoryUserHandler) CreateUsersBatch(ctx echo.Context, params generated.CreateUsersBatchParams) error {
+	return CreateUsersBatch(ctx, params, h.createUsers)
+}
+
+// createUsers adds users under one lock. Adding a user can't fail, so
+// allOrNothing makes no difference.
+func (h *InMemoryUserHandler) createUsers(users []database.BatchUser, allOrNothing bool) ([]database.BatchResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	results := make([]database.BatchResult, len(users))
+	for i, batchUser := range users {
+		user := h.addUser(batchUser.Request)
+		results[i].User = &user
+	}
+	return results, nil
+}
+
+// addUser stores a new user for req. The caller holds h.mu.
+func (h *InMemoryUserHandler) addUser(req generated.UserRequest) generated.User {
 	user := generated.User{
 		Id:    h.NextID,
 		Email: req.Email,
@@ -50,20 +218,64 @@ func (h *InMemoryUserHandler) CreateUser(ctx echo.Context) error {
 
 	h.Users[h.NextID] = user
 	h.NextID++
-
-	return ctx.JSON(http.StatusCreated, user)
+	return user
 }
 
 // GetUserById implements the generated.ServerInterface.GetUserById method
 func (h *InMemoryUserHandler) GetUserById(ctx echo.Context, id int64) error {
+	contentType, err := Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
 	user, exists := h.Users[id]
+	h.mu.RUnlock()
+	if !exists {
+		return response.JSON(ctx, http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	}
+
+	return RespondUser(ctx, http.StatusOK, contentType, &user, nil)
+}
+
+// ListUsers implements the generated.ServerInterface.ListUsers method
+func (h *InMemoryUserHandler) ListUsers(ctx echo.Context, params generated.ListUsersParams) error {
+	limit, offset := ListPage(params)
+
+	h.mu.RLock()
+	ids := make([]int64, 0, len(h.Users))
+	for id := range h.Users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	users := []generated.User{}
+	for i := offset; i < len(ids) && len(users) < limit; i++ {
+		users = append(users, h.Users[ids[i]])
+	}
+	h.mu.RUnlock()
+
+	return response.JSON(ctx, http.StatusOK, generated.UserList{
+		Users: users,
+		Total: int64(len(ids)),
+	})
+}
+
+// DeleteUser implements the generated.ServerInterface.DeleteUser method
+func (h *InMemoryUserHandler) DeleteUser(ctx echo.Context, id int64) error {
+	h.mu.Lock()
+	_, exists := h.Users[id]
+	delete(h.Users, id)
+	h.mu.Unlock()
 	if !exists {
-		return ctx.JSON(http.StatusNotFound, map[string]string{
+		return response.JSON(ctx, http.StatusNotFound, map[string]string{
 			"error": "User not found",
 		})
 	}
 
-	return ctx.JSON(http.StatusOK, user)
+	return ctx.NoContent(http.StatusNoContent)
 }
 
 // UserHandler implements the generated.ServerInterface (database version)
@@ -79,53 +291,124 @@ func NewUserHandler(db *database.DatabaseService) *UserHandler {
 
 // CreateUser implements the generated.ServerInterface.CreateUser method
 func (h *UserHandler) CreateUser(ctx echo.Context) error {
-	var req generated.UserRequest
-	if err := ctx.Bind(&req); err != nil {
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
+	contentType, err := Negotiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The request body can only be read once, so bind it to a map and decode
+	// the known fields from that
+	rawData, err := BindUserBody(ctx)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
 			"error": "Invalid JSON format",
 		})
 	}
 
-	// Extract additional properties (properties not defined in UserRequest)
-	var rawData map[string]interface{}
-	if err := ctx.Bind(&rawData); err == nil {
-		// Remove known fields
-		delete(rawData, "email")
-		delete(rawData, "age")
-		delete(rawData, "name")
-		delete(rawData, "bio")
-		delete(rawData, "is_active")
-
-		// Create user with additional properties
-		user, err := h.db.CreateUser(req, rawData)
-		if err != nil {
-			return ctx.JSON(http.StatusInternalServerError, map[string]string{
-				"error": err.Error(),
-			})
-		}
+	req, additionalProps, err := ExtractAdditionalProps(rawData)
+	if err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
 
-		return ctx.JSON(http.StatusCreated, user)
+	if err := ValidateAge(req.Age); err != nil {
+		return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
 	}
 
-	// Fallback: create without additional properties
-	user, err := h.db.CreateUser(req, nil)
+	var (
+		user    *generated.User
+		created = true
+	)
+	if key := ctx.Request().Header.Get(IdempotencyKeyHeader); key != "" {
+		if len(key) > MaxIdempotencyKeyLength {
+			return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, MaxIdempotencyKeyLength),
+			})
+		}
+		user, created, err = h.db.CreateUserIdempotentContext(RequestContext(ctx), key, req, additionalProps)
+	} else {
+		user, err = h.db.CreateUserContext(RequestContext(ctx), req, additionalProps)
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+		if errors.Is(err, jobs.ErrQueueFull) {
+			return RespondQueueFull(ctx)
+		}
+		if errors.Is(err, database.ErrEmailTaken) {
+			return RespondEmailTaken(ctx)
+		}
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
 		})
 	}
 
-	return ctx.JSON(http.StatusCreated, user)
+	if !created {
+		return RespondUser(ctx, http.StatusOK, contentType, user, nil)
+	}
+
+	return RespondUser(ctx, http.StatusCreated, contentType, user, nil)
+}
+
+// CreateUsersBatch implements the generated.ServerInterface.CreateUsersBatch method
+func (h *UserHandler) CreateUsersBatch(ctx echo.Context, params generated.CreateUsersBatchParams) error {
+	return CreateUsersBatch(ctx, params, func(users []database.BatchUser, allOrNothing bool) ([]database.BatchResult, error) {
+		return h.db.CreateUsersContext(RequestContext(ctx), users, allOrNothing)
+	})
 }
 
 // GetUserById implements the generated.ServerInterface.GetUserById method
 func (h *UserHandler) GetUserById(ctx echo.Context, id int64) error {
-	user, err := h.db.GetUserByID(id)
+	contentType, err := Negotiate(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, map[string]string{
-			"error": "User not found",
+		return err
+	}
+
+	user, additionalProps, err := h.db.GetUserByIDWithAdditionalProps(id)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return response.JSON(ctx, http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		}
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return RespondUser(ctx, http.StatusOK, contentType, user, additionalProps)
+}
+
+// ListUsers implements the generated.ServerInterface.ListUsers method
+func (h *UserHandler) ListUsers(ctx echo.Context, params generated.ListUsersParams) error {
+	limit, offset := ListPage(params)
+
+	users, total, err := h.db.ListUsers(limit, offset)
+	if err != nil {
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return response.JSON(ctx, http.StatusOK, generated.UserList{
+		Users: users,
+		Total: total,
+	})
+}
+
+// DeleteUser implements the generated.ServerInterface.DeleteUser method
+func (h *UserHandler) DeleteUser(ctx echo.Context, id int64) error {
+	if err := h.db.DeleteUserContext(RequestContext(ctx), id); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return response.JSON(ctx, http.StatusNotFound, map[string]string{
+				"error": "User not found",
+			})
+		}
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
 		})
 	}
 
-	return ctx.JSON(http.StatusOK, user)
+	return ctx.NoContent(http.StatusNoContent)
 }
\ No newline at end of file
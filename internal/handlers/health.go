@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Probe endpoints for container orchestration. They are not part of the
+// OpenAPI spec, so the validation middleware passes them through unchecked.
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck is a named dependency check run by Readyz
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// DatabaseReadinessChecks pings the SQLite database and, when the service
+// uses the SQLite job queue, the job_queue table
+func DatabaseReadinessChecks(db *database.DatabaseService) []ReadinessCheck {
+	checks := []ReadinessCheck{
+		{Name: "database", Check: db.Ping},
+	}
+	if jobQueue := db.GetJobQueue(); jobQueue != nil {
+		checks = append(checks, ReadinessCheck{Name: "job queue", Check: jobQueue.Ping})
+	}
+	return checks
+}
+
+// QueueThresholds mark an instance not ready while its job queue is backed
+// up, so orchestrators route traffic elsewhere. A zero field disables that
+// check.
+type QueueThresholds struct {
+	// MaxPending is the most pending jobs the queue may hold
+	MaxPending int64
+	// MaxPendingAge is the longest a due job may wait to be claimed
+	MaxPendingAge time.Duration
+}
+
+// Enabled reports whether any threshold is set
+func (t QueueThresholds) Enabled() bool {
+	return t.MaxPending > 0 || t.MaxPendingAge > 0
+}
+
+// QueueThresholdsFromEnv reads READY_MAX_PENDING_JOBS (a count) and
+// READY_MAX_PENDING_AGE (a duration such as "5m"). Both are off when unset.
+func QueueThresholdsFromEnv() (QueueThresholds, error) {
+	var t QueueThresholds
+	if value := os.Getenv("READY_MAX_PENDING_JOBS"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return QueueThresholds{}, fmt.Errorf("invalid READY_MAX_PENDING_JOBS %q: must be a non-negative number", value)
+		}
+		t.MaxPending = n
+	}
+	if value := os.Getenv("READY_MAX_PENDING_AGE"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return QueueThresholds{}, fmt.Errorf("invalid READY_MAX_PENDING_AGE %q: must be a non-negative duration such as 5m", value)
+		}
+		t.MaxPendingAge = d
+	}
+	return t, nil
+}
+
+// QueueBacklogCheck fails while the job queue exceeds thresholds. A paused
+// queue is expected to back up, so it counts as ready.
+func QueueBacklogCheck(jobQueue *jobs.JobQueueService, thresholds QueueThresholds) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "job queue backlog",
+		Check: func(ctx context.Context) error {
+			paused, err := jobQueue.IsQueuePaused(ctx)
+			if err != nil {
+				return err
+			}
+			if paused {
+				return nil
+			}
+
+			pending, oldestWait, err := jobQueue.PendingBacklog(ctx)
+			if err != nil {
+				return err
+			}
+			if thresholds.MaxPending > 0 && pending > thresholds.MaxPending {
+				return fmt.Errorf("%d pending jobs exceed the limit of %d", pending, thresholds.MaxPending)
+			}
+			if thresholds.MaxPendingAge > 0 && oldestWait > thresholds.MaxPendingAge {
+				return fmt.Errorf("oldest pending job has waited %s, over the limit of %s",
+					oldestWait.Round(time.Second), thresholds.MaxPendingAge)
+			}
+			return nil
+		},
+	}
+}
+
+// RegisterHealthRoutes adds /healthz, which always returns 200 once the
+// process is serving, and /readyz, which returns 503 if any check fails.
+func RegisterHealthRoutes(e *echo.Echo, checks ...ReadinessCheck) {
+	e.GET(HealthzPath, Healthz)
+	e.GET(ReadyzPath, Readyz(checks...))
+}
+
+// Healthz reports that the process is up
+func Healthz(ctx echo.Context) error {
+	return response.JSON(ctx, http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
+// Readyz runs the checks in order and reports the first failure
+func Readyz(checks ...ReadinessCheck) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		checkCtx, cancel := context.WithTimeout(ctx.Request().Context(), readinessTimeout)
+		defer cancel()
+
+		for _, check := range checks {
+			if err := check.Check(checkCtx); err != nil {
+				return response.JSON(ctx, http.StatusServiceUnavailable, map[string]string{
+					"error": fmt.Sprintf("%s not ready: %v", check.Name, err),
+				})
+			}
+		}
+
+		return response.JSON(ctx, http.StatusOK, map[string]string{
+			"status": "ready",
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MaxJobStatusIDs caps the number of jobs one POST /jobs/status can look up
+const MaxJobStatusIDs = 100
+
+// JobStatusRequest is the JSON body of POST /jobs/status
+type JobStatusRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// JobStatus is one entry of the POST /jobs/status response. Error is the
+// job's last error message, or null.
+type JobStatus struct {
+	ID     int64   `json:"id"`
+	Status string  `json:"status"`
+	Error  *string `json:"error"`
+}
+
+// RegisterJobRoutes adds the job endpoints for API clients. Like the admin
+// routes they are not part of the OpenAPI spec and are not validated by it.
+func RegisterJobRoutes(e *echo.Echo, jobQueue *jobs.JobQueueService) {
+	e.POST("/jobs/status", JobStatuses(jobQueue))
+}
+
+// JobStatuses looks up the status of several jobs in one call, so clients
+// that enqueue many jobs can poll them together. Jobs that don't exist are
+// left out of the response.
+func JobStatuses(jobQueue *jobs.JobQueueService) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var req JobStatusRequest
+		if err := ctx.Bind(&req); err != nil {
+			return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+				"error": "Invalid JSON format",
+			})
+		}
+		if len(req.IDs) == 0 {
+			return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+				"error": "ids must contain at least one job ID",
+			})
+		}
+		if len(req.IDs) > MaxJobStatusIDs {
+			return response.JSON(ctx, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("ids must contain at most %d job IDs", MaxJobStatusIDs),
+			})
+		}
+
+		rows, err := jobQueue.GetJobStatuses(ctx.Request().Context(), req.IDs)
+		if err != nil {
+			return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		statuses := make([]JobStatus, 0, len(rows))
+		for _, row := range rows {
+			status := JobStatus{ID: row.ID, Status: row.Status}
+			if row.ErrorMessage.Valid {
+				status.Error = &row.ErrorMessage.String
+			}
+			statuses = append(statuses, status)
+		}
+
+		return response.JSON(ctx, http.StatusOK, statuses)
+	}
+}
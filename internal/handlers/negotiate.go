@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"openapi-validation-example/generated"
+	"openapi-validation-example/pkg/response"
+	"openapi-validation-example/pkg/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+// encodableContentTypes are the response media types the handlers can
+// produce, in order of preference
+var encodableContentTypes = []string{echo.MIMEApplicationJSON, echo.MIMEApplicationXML}
+
+// UserXML is the XML representation of generated.User. Element names match
+// the JSON field names. Additional properties are only included in JSON.
+type UserXML struct {
+	XMLName   xml.Name   `xml:"user"`
+	Id        int64      `xml:"id"`
+	Email     string     `xml:"email"`
+	Age       int        `xml:"age"`
+	Name      *string    `xml:"name,omitempty"`
+	Bio       *string    `xml:"bio,omitempty"`
+	IsActive  *bool      `xml:"is_active,omitempty"`
+	CreatedAt *time.Time `xml:"created_at,omitempty"`
+	UpdatedAt *time.Time `xml:"updated_at,omitempty"`
+}
+
+func NewUserXML(user *generated.User) UserXML {
+	return UserXML{
+		Id:        user.Id,
+		Email:     string(user.Email),
+		Age:       user.Age,
+		Name:      user.Name,
+		Bio:       user.Bio,
+		IsActive:  user.IsActive,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
+
+// OfferedContentTypes returns the media types the response may use: those the
+// OpenAPI spec declares for the operation (stored by the validation
+// middleware) that the handlers can encode. Without the middleware only JSON
+// is offered.
+func OfferedContentTypes(ctx echo.Context) []string {
+	declared, ok := ctx.Get(validation.ResponseContentTypesKey).([]string)
+	if !ok {
+		return []string{echo.MIMEApplicationJSON}
+	}
+
+	var offered []string
+	for _, contentType := range encodableContentTypes {
+		for _, d := range declared {
+			if d == contentType {
+				offered = append(offered, contentType)
+				break
+			}
+		}
+	}
+	return offered
+}
+
+// NegotiateContentType picks the offered media type the Accept header
+// prefers, honoring q-values and wildcards. Ties go to the earlier offer; an
+// empty Accept header accepts the first offer. It returns false when nothing
+// offered is acceptable.
+func NegotiateContentType(accept string, offered []string) (string, bool) {
+	if len(offered) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offered[0], true
+	}
+
+	best, bestQ := "", 0.0
+	for _, contentType := range offered {
+		if q := acceptQuality(accept, contentType); q > bestQ {
+			best, bestQ = contentType, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// acceptQuality returns the q-value the Accept header gives contentType,
+// taken from the most specific matching media range, or 0 if none matches.
+func acceptQuality(accept, contentType string) float64 {
+	typ, subtype, _ := strings.Cut(contentType, "/")
+
+	q, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSubtype, _ := strings.Cut(mediaType, "/")
+
+		var s int
+		switch {
+		case rangeType == typ && rangeSubtype == subtype:
+			s = 2
+		case rangeType == typ && rangeSubtype == "*":
+			s = 1
+		case rangeType == "*" && rangeSubtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+
+		rangeQ := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				rangeQ = parsed
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+	return q
+}
+
+// Negotiate picks the response media type from the Accept header. When none
+// of the offered types is acceptable it returns a 406 *echo.HTTPError, which
+// handlers return as is. Call it before doing any work, so a request that
+// would be refused does not create anything.
+func Negotiate(ctx echo.Context) (string, error) {
+	offered := OfferedContentTypes(ctx)
+	contentType, ok := NegotiateContentType(ctx.Request().Header.Get(echo.HeaderAccept), offered)
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusNotAcceptable,
+			fmt.Sprintf("Not acceptable; supported media types: %s", strings.Join(offered, ", ")))
+	}
+	return contentType, nil
+}
+
+// RespondUser writes the user as contentType, a media type returned by
+// Negotiate. The JSON representation includes additionalProps.
+func RespondUser(ctx echo.Context, status int, contentType string, user *generated.User, additionalProps map[string]interface{}) error {
+	if contentType == echo.MIMEApplicationXML {
+		return ctx.XML(status, NewUserXML(user))
+	}
+
+	body, err := MergeAdditionalProps(user, additionalProps)
+	if err != nil {
+		return response.JSON(ctx, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return response.JSON(ctx, status, body)
+}
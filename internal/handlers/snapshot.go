@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"openapi-validation-example/generated"
+)
+
+// UserSnapshot is the on-disk form of an in-memory user store, so the
+// in-memory server can keep its users across restarts
+type UserSnapshot struct {
+	NextID int64            `json:"next_id"`
+	Users  []generated.User `json:"users"`
+}
+
+// NewUserSnapshot copies an in-memory user store, ordered by ID. The caller
+// holds the store's lock.
+func NewUserSnapshot(users map[int64]generated.User, nextID int64) UserSnapshot {
+	snapUsers := make([]generated.User, 0, len(users))
+	for _, user := range users {
+		snapUsers = append(snapUsers, user)
+	}
+	sort.Slice(snapUsers, func(i, j int) bool { return snapUsers[i].Id < snapUsers[j].Id })
+
+	return UserSnapshot{NextID: nextID, Users: snapUsers}
+}
+
+// UsersByID returns the snapshot's users keyed by ID, for restoring a store
+func (snap UserSnapshot) UsersByID() map[int64]generated.User {
+	users := make(map[int64]generated.User, len(snap.Users))
+	for _, user := range snap.Users {
+		users[user.Id] = user
+	}
+	return users
+}
+
+// Snapshot copies the handler's users, ordered by ID
+func (h *InMemoryUserHandler) Snapshot() UserSnapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return NewUserSnapshot(h.Users, h.NextID)
+}
+
+// Restore replaces the handler's users with those in snap
+func (h *InMemoryUserHandler) Restore(snap UserSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Users = snap.UsersByID()
+	h.NextID = snap.NextID
+}
+
+// WriteUserSnapshot saves snap to path as JSON. It writes a temporary file
+// and renames it over path, so a crash mid-write leaves the previous
+// snapshot intact.
+func WriteUserSnapshot(path string, snap UserSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return nil
+}
+
+// ReadUserSnapshot loads a snapshot written by WriteUserSnapshot. A missing
+// file is not an error and yields ok == false, so the first start begins
+// with no users.
+func ReadUserSnapshot(path string) (snap UserSnapshot, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return UserSnapshot{}, false, nil
+	}
+	if err != nil {
+		return UserSnapshot{}, false, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return UserSnapshot{}, false, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+
+	// Never hand out an ID that is already taken
+	snap.NextID = max(snap.NextID, 1)
+	for _, user := range snap.Users {
+		snap.NextID = max(snap.NextID, user.Id+1)
+	}
+	return snap, true, nil
+}
+
+// RunUserSnapshots saves snapshot() to path every interval until done is
+// closed. Failures are logged and retried at the next tick.
+func RunUserSnapshots(path string, interval time.Duration, snapshot func() UserSnapshot, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := WriteUserSnapshot(path, snapshot()); err != nil {
+				log.Printf("Failed to save user snapshot: %v", err)
+			}
+		}
+	}
+}
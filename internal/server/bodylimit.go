@@ -0,0 +1,25 @@
+package server
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/labstack/gommon/bytes"
+)
+
+// DefaultBodyLimit caps request bodies when MAX_BODY_SIZE is not set
+const DefaultBodyLimit = "1M"
+
+// BodyLimit reads the request body cap from MAX_BODY_SIZE, in the size format
+// of Echo's BodyLimit middleware (e.g. "512K", "2M"; default "1M"). Larger
+// bodies are rejected with 413 before they are read into memory.
+func BodyLimit() (string, error) {
+	limit := os.Getenv("MAX_BODY_SIZE")
+	if limit == "" {
+		return DefaultBodyLimit, nil
+	}
+	if n, err := bytes.Parse(limit); err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid MAX_BODY_SIZE %q: must be a positive size such as 512K or 2M", limit)
+	}
+	return limit, nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConcurrencyLimiter caps how many requests each client IP may have in
+// flight at once, e.g. so one client can't tie up the server with slow bulk
+// creates. Unlike a rate limit it doesn't care how many requests a client
+// sends, only how many are running.
+type ConcurrencyLimiter struct {
+	max int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// NewConcurrencyLimiter allows each client IP max concurrent requests
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		max:      max,
+		inFlight: make(map[string]int),
+	}
+}
+
+// ConcurrencyLimitFromEnv reads MAX_CONCURRENT_PER_IP. ok is false when it is
+// unset or 0, which disables the limit.
+func ConcurrencyLimitFromEnv() (max int, ok bool, err error) {
+	value := os.Getenv("MAX_CONCURRENT_PER_IP")
+	if value == "" {
+		return 0, false, nil
+	}
+	max, err = strconv.Atoi(value)
+	if err != nil || max < 0 {
+		return 0, false, fmt.Errorf("invalid MAX_CONCURRENT_PER_IP %q: must be a non-negative number", value)
+	}
+	return max, max > 0, nil
+}
+
+func (l *ConcurrencyLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[ip] >= l.max {
+		return false
+	}
+	l.inFlight[ip]++
+	return true
+}
+
+func (l *ConcurrencyLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop idle clients so the map only holds IPs with requests in flight
+	if l.inFlight[ip]--; l.inFlight[ip] <= 0 {
+		delete(l.inFlight, ip)
+	}
+}
+
+// Middleware answers a request with 429 while its client IP (c.RealIP())
+// already has the maximum number of requests in flight. Requests to the
+// exempt paths (e.g. health checks) are not limited.
+func (l *ConcurrencyLimiter) Middleware(exempt ...string) echo.MiddlewareFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip[c.Request().URL.Path] {
+				return next(c)
+			}
+
+			ip := c.RealIP()
+			if !l.acquire(ip) {
+				c.Response().Header().Set(echo.HeaderRetryAfter, "1")
+				return response.JSON(c, http.StatusTooManyRequests, map[string]string{
+					"error": "Too many concurrent requests",
+				})
+			}
+			defer l.release(ip)
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"openapi-validation-example/pkg/auth"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Defaults for CORS_ALLOWED_METHODS and CORS_ALLOWED_HEADERS: the methods the
+// API serves and the request headers it reads
+var (
+	DefaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+	DefaultCORSHeaders = []string{
+		echo.HeaderContentType, echo.HeaderAccept, auth.HeaderAPIKey,
+		echo.HeaderXRequestID, "Idempotency-Key",
+	}
+)
+
+// CORSConfig reads the CORS settings from the environment:
+// CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS and CORS_ALLOWED_HEADERS
+// (comma-separated) and CORS_ALLOW_CREDENTIALS ("true"). ok is false when no
+// origins are set: responses then carry no CORS headers, so browsers block
+// every cross-origin request.
+func CORSConfig() (config middleware.CORSConfig, ok bool, err error) {
+	origins := splitList(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if len(origins) == 0 {
+		return middleware.CORSConfig{}, false, nil
+	}
+
+	config = middleware.CORSConfig{
+		AllowOrigins:  origins,
+		AllowMethods:  DefaultCORSMethods,
+		AllowHeaders:  DefaultCORSHeaders,
+		ExposeHeaders: []string{echo.HeaderXRequestID, echo.HeaderRetryAfter},
+	}
+	if methods := splitList(os.Getenv("CORS_ALLOWED_METHODS")); len(methods) > 0 {
+		config.AllowMethods = methods
+	}
+	if headers := splitList(os.Getenv("CORS_ALLOWED_HEADERS")); len(headers) > 0 {
+		config.AllowHeaders = headers
+	}
+	config.AllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+
+	if config.AllowCredentials {
+		for _, origin := range origins {
+			if origin == "*" {
+				return middleware.CORSConfig{}, false, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*")
+			}
+		}
+	}
+	return config, true, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// DefaultGzipMinLength is the smallest response body, in bytes, that is
+// compressed when GZIP_MIN_LENGTH is not set
+const DefaultGzipMinLength = 1024
+
+// GzipConfig reads the compression settings from the environment. GZIP=1
+// enables gzip for clients that send Accept-Encoding: gzip; bodies shorter
+// than GZIP_MIN_LENGTH bytes (default 1024) are sent uncompressed, since
+// compressing them saves little. ok is false when compression is off.
+func GzipConfig() (config middleware.GzipConfig, ok bool, err error) {
+	switch os.Getenv("GZIP") {
+	case "1", "true":
+	default:
+		return middleware.GzipConfig{}, false, nil
+	}
+
+	config = middleware.GzipConfig{
+		MinLength: DefaultGzipMinLength,
+	}
+	if value := os.Getenv("GZIP_MIN_LENGTH"); value != "" {
+		minLength, err := strconv.Atoi(value)
+		if err != nil || minLength < 0 {
+			return middleware.GzipConfig{}, false, fmt.Errorf("invalid GZIP_MIN_LENGTH %q: must be a non-negative number of bytes", value)
+		}
+		config.MinLength = minLength
+	}
+	return config, true, nil
+}
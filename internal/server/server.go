@@ -0,0 +1,63 @@
+// Package server runs the HTTP servers until they are told to stop, then
+// shuts them down gracefully.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultShutdownTimeout is how long Run waits for in-flight requests when
+// SHUTDOWN_TIMEOUT is not set
+const DefaultShutdownTimeout = 10 * time.Second
+
+// ShutdownTimeout reads SHUTDOWN_TIMEOUT, a Go duration such as "30s"
+func ShutdownTimeout() (time.Duration, error) {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return DefaultShutdownTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: must be a positive duration", value)
+	}
+	return timeout, nil
+}
+
+// Run serves e on address until ctx is done (main passes a context from
+// signal.NotifyContext), then stops accepting connections and waits up to
+// timeout for in-flight requests to finish. It returns early with the error
+// if the server fails to start.
+func Run(ctx context.Context, e *echo.Echo, address string, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- e.Start(address)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server failed to start: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down server, waiting up to %s for active requests...", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	log.Println("Server shut down")
+	return nil
+}
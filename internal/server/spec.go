@@ -0,0 +1,13 @@
+package server
+
+import "os"
+
+// SpecPath returns the OpenAPI spec the server validates against:
+// OPENAPI_SPEC when set, otherwise defaultPath. The spec must describe the
+// routes the server registers, since requests are matched against its paths.
+func SpecPath(defaultPath string) string {
+	if path := os.Getenv("OPENAPI_SPEC"); path != "" {
+		return path
+	}
+	return defaultPath
+}
@@ -0,0 +1,24 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+
+	"openapi-validation-example/db"
+)
+
+// LogJobStats logs the job counts per status from getStats (usually
+// JobQueueService.GetJobStats). A failed query is logged at Warn, so a broken
+// stats path shows up in the logs instead of the stats just going missing.
+func LogJobStats(ctx context.Context, logger *slog.Logger, getStats func(ctx context.Context) (*db.GetJobStatsRow, error)) {
+	stats, err := getStats(ctx)
+	if err != nil {
+		logger.Warn("Failed to get job stats", "error", err)
+		return
+	}
+
+	logger.Info("Job stats",
+		"pending", stats.PendingCount, "processing", stats.ProcessingCount,
+		"completed", stats.CompletedCount, "failed", stats.FailedCount,
+		"cancelled", stats.CancelledCount)
+}
@@ -0,0 +1,407 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"openapi-validation-example/db"
+	"openapi-validation-example/pkg/jobs"
+)
+
+// Workers poll every MinPollInterval while jobs are available and double the
+// interval up to MaxPollInterval while the queue is empty.
+const (
+	MinPollInterval = time.Second
+	MaxPollInterval = 10 * time.Second
+)
+
+// DefaultJobTimeout bounds a job whose type has no configured Timeout
+const DefaultJobTimeout = 5 * time.Minute
+
+// JobProcessor runs jobs of one type. Process must return once ctx is done;
+// its deadline is the job type's timeout.
+type JobProcessor interface {
+	Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error
+	JobType() jobs.JobType
+}
+
+// JobTypeConfig holds the worker settings for one job type
+type JobTypeConfig struct {
+	// Timeout bounds each Process call. Zero means DefaultJobTimeout.
+	Timeout time.Duration
+}
+
+// ParseJobTimeouts parses per-type timeouts such as
+// "email_notification=30s,data_analysis=10m". An empty string yields no
+// configs.
+func ParseJobTimeouts(s string) (map[jobs.JobType]JobTypeConfig, error) {
+	configs := make(map[jobs.JobType]JobTypeConfig)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid timeout %q: expected type=duration", entry)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q: must be a positive duration", entry)
+		}
+		configs[jobs.JobType(strings.TrimSpace(name))] = JobTypeConfig{Timeout: timeout}
+	}
+	return configs, nil
+}
+
+// A Queue may also implement these to support the worker features built on
+// them. JobQueueService implements them all.
+type (
+	// batchClaimer claims several jobs at once for a worker, recording the
+	// worker on them. Without it, batches are claimed one GetNextJob at a
+	// time.
+	batchClaimer interface {
+		GetNextJobsForWorker(ctx context.Context, workerID int, limit int) ([]db.JobQueue, error)
+	}
+	// heartbeater receives the worker's heartbeats
+	heartbeater interface {
+		Heartbeat(ctx context.Context, workerID int) error
+	}
+	// enqueueNotifier wakes idle workers when a job is enqueued
+	enqueueNotifier interface {
+		Subscribe() <-chan struct{}
+	}
+	// jobDeferrer returns jobs to pending without using up a retry; without
+	// it, jobs of unknown types are always failed
+	jobDeferrer interface {
+		DeferJob(ctx context.Context, jobID int64, reason string, delay time.Duration) error
+	}
+	// durationRecorder stores how long each job took
+	durationRecorder interface {
+		RecordJobDuration(ctx context.Context, jobID int64, d time.Duration) error
+	}
+)
+
+// Checked here, since a queue missing one of them only loses the feature
+var _ interface {
+	batchClaimer
+	heartbeater
+	enqueueNotifier
+	jobDeferrer
+	durationRecorder
+} = (*jobs.JobQueueService)(nil)
+
+type Worker struct {
+	id           int
+	batchSize    int
+	logger       *slog.Logger
+	jobQueue     jobs.Queue
+	processors   map[jobs.JobType]JobProcessor
+	configs      map[jobs.JobType]JobTypeConfig
+	stopCh       chan struct{}
+	wg           *sync.WaitGroup
+	processingWg *sync.WaitGroup
+	// ctx is cancelled by Stop, abandoning a claim still in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// unknownTypeDelay, if positive, defers jobs of types without a
+	// processor by this long instead of failing them
+	unknownTypeDelay time.Duration
+}
+
+func NewWorker(id int, jobQueue jobs.Queue, processors map[jobs.JobType]JobProcessor, wg *sync.WaitGroup) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Worker{
+		id:           id,
+		batchSize:    1,
+		logger:       slog.Default().With("worker_id", id),
+		jobQueue:     jobQueue,
+		processors:   processors,
+		stopCh:       make(chan struct{}),
+		wg:           wg,
+		processingWg: &sync.WaitGroup{},
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+func (w *Worker) Start() {
+	defer w.wg.Done()
+
+	w.logger.Info("Worker started")
+
+	// Heartbeat before the first claim, so the worker's jobs are never
+	// taken for a dead worker's
+	w.heartbeat()
+	heartbeat := time.NewTicker(jobs.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// Jobs enqueued in this process wake the worker at once; jobs from other
+	// processes are found by polling. A nil channel never fires.
+	var enqueued <-chan struct{}
+	if notifier, ok := w.jobQueue.(enqueueNotifier); ok {
+		enqueued = notifier.Subscribe()
+	}
+
+	pollInterval := MinPollInterval
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			w.heartbeat()
+		case <-enqueued:
+			if w.processNextJobs() > 0 {
+				pollInterval = MinPollInterval
+				timer.Reset(pollInterval)
+			}
+		case <-w.stopCh:
+			w.logger.Info("Worker received stop signal")
+			w.processingWg.Wait() // Wait for current jobs to complete
+			w.logger.Info("Worker stopped")
+			return
+		case <-timer.C:
+			if w.processNextJobs() > 0 {
+				pollInterval = MinPollInterval
+			} else {
+				// Idle (or the claim failed): back off
+				pollInterval = min(pollInterval*2, MaxPollInterval)
+			}
+			timer.Reset(pollInterval)
+		}
+	}
+}
+
+// heartbeat tells the queue the worker is alive, if it tracks workers
+func (w *Worker) heartbeat() {
+	hb, ok := w.jobQueue.(heartbeater)
+	if !ok {
+		return
+	}
+	if err := hb.Heartbeat(w.ctx, w.id); err != nil {
+		w.logger.Warn("Failed to send heartbeat", "error", err)
+	}
+}
+
+// processNextJobs claims up to batchSize jobs and starts processing them. It
+// returns the number of jobs claimed.
+func (w *Worker) processNextJobs() int {
+	batch, err := w.claimJobs()
+	if err != nil {
+		if w.ctx.Err() != nil {
+			// Stopped mid-claim; the claim was rolled back
+			return 0
+		}
+		w.logger.Error("Error getting next jobs", "error", err)
+		return 0
+	}
+
+	for i := range batch {
+		job := &batch[i]
+		w.processingWg.Add(1)
+		go w.processJob(job)
+	}
+
+	return len(batch)
+}
+
+// claimJobs claims up to batchSize jobs
+func (w *Worker) claimJobs() ([]db.JobQueue, error) {
+	if claimer, ok := w.jobQueue.(batchClaimer); ok {
+		return claimer.GetNextJobsForWorker(w.ctx, w.id, w.batchSize)
+	}
+
+	var batch []db.JobQueue
+	for len(batch) < w.batchSize {
+		job, err := w.jobQueue.GetNextJob(w.ctx)
+		if err != nil {
+			if len(batch) > 0 {
+				// Process what was claimed; the error recurs on the next poll
+				break
+			}
+			return nil, err
+		}
+		if job == nil {
+			break
+		}
+		batch = append(batch, *job)
+	}
+	return batch, nil
+}
+
+func (w *Worker) processJob(job *db.JobQueue) {
+	defer w.processingWg.Done()
+
+	// Shutdown waits for claimed jobs, so their outcome is recorded even once
+	// the worker is stopped
+	ctx := context.Background()
+
+	logger := JobLogger(w.logger, job)
+	logger.Info("Processing job")
+
+	// Parse payload
+	var payload jobs.JobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		logger.Error("Error parsing job payload", "error", err)
+		w.jobQueue.FailJob(ctx, job.ID, fmt.Sprintf("Failed to parse payload: %v", err), false)
+		return
+	}
+
+	// Find processor
+	processor, exists := w.processors[jobs.JobType(job.JobType)]
+	if !exists {
+		reason := fmt.Sprintf("No processor for job type: %s", job.JobType)
+		if deferrer, ok := w.jobQueue.(jobDeferrer); ok && w.unknownTypeDelay > 0 {
+			// Likely registered by a deploy still rolling out
+			logger.Warn("No processor found for job type, deferring job", "delay", w.unknownTypeDelay)
+			if err := deferrer.DeferJob(ctx, job.ID, reason, w.unknownTypeDelay); err != nil {
+				logger.Error("Error deferring job", "error", err)
+			}
+			return
+		}
+		logger.Error("No processor found for job type")
+		w.jobQueue.FailJob(ctx, job.ID, reason, false)
+		return
+	}
+
+	// Process the job within its type's timeout
+	timeout := w.jobTimeout(jobs.JobType(job.JobType))
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := processor.Process(jobCtx, job, payload)
+	duration := time.Since(start)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("job timed out after %s: %w", timeout, err)
+	}
+
+	if recorder, ok := w.jobQueue.(durationRecorder); ok {
+		if recordErr := recorder.RecordJobDuration(ctx, job.ID, duration); recordErr != nil {
+			logger.Error("Error recording job duration", "error", recordErr)
+		}
+	}
+
+	if err != nil {
+		logger.Warn("Job failed", "error", err, "duration_ms", duration.Milliseconds())
+
+		// Retry logic
+		var retryCount, maxRetries int64
+		if job.RetryCount.Valid {
+			retryCount = job.RetryCount.Int64
+		}
+		if job.MaxRetries.Valid {
+			maxRetries = job.MaxRetries.Int64
+		}
+		shouldRetry := retryCount < maxRetries
+		w.jobQueue.FailJob(ctx, job.ID, err.Error(), shouldRetry)
+	} else {
+		logger.Info("Job completed successfully", "duration_ms", duration.Milliseconds())
+		w.jobQueue.CompleteJob(ctx, job.ID)
+	}
+}
+
+// JobLogger returns logger with the job's ID and type attached, plus the
+// request ID of the HTTP request that enqueued it, if any
+func JobLogger(logger *slog.Logger, job *db.JobQueue) *slog.Logger {
+	logger = logger.With("job_id", job.ID, "job_type", job.JobType)
+	if job.RequestID.Valid {
+		logger = logger.With("request_id", job.RequestID.String)
+	}
+	return logger
+}
+
+// jobTimeout returns the configured timeout for jobType
+func (w *Worker) jobTimeout(jobType jobs.JobType) time.Duration {
+	if config, ok := w.configs[jobType]; ok && config.Timeout > 0 {
+		return config.Timeout
+	}
+	return DefaultJobTimeout
+}
+
+func (w *Worker) Stop() {
+	w.cancel()
+	close(w.stopCh)
+}
+
+// Manager runs a pool of workers together with background tasks (periodic
+// stats, health checks) and shuts them all down in a fixed order.
+type Manager struct {
+	workers  []*Worker
+	workerWg sync.WaitGroup
+	taskWg   sync.WaitGroup
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewManager(jobQueue jobs.Queue, processors map[jobs.JobType]JobProcessor, numWorkers, batchSize int) *Manager {
+	m := &Manager{
+		workers: make([]*Worker, numWorkers),
+		done:    make(chan struct{}),
+	}
+	for i := range m.workers {
+		m.workers[i] = NewWorker(i+1, jobQueue, processors, &m.workerWg)
+		m.workers[i].batchSize = batchSize
+	}
+	return m
+}
+
+// SetJobTypeConfigs sets the per-type settings, such as timeouts, of every
+// worker. Types without a config use the defaults. Call it before Start.
+func (m *Manager) SetJobTypeConfigs(configs map[jobs.JobType]JobTypeConfig) {
+	for _, w := range m.workers {
+		w.configs = configs
+	}
+}
+
+// SetDeferUnknownJobTypes makes the workers return jobs whose type has no
+// processor to pending, due again after delay, instead of failing them. Such
+// jobs keep their retries, so a processor that is registered later, e.g. by
+// the next deploy, still runs them. 0 fails them, the default, as does a
+// queue that can't defer jobs (JobQueueService can). Call it before Start.
+func (m *Manager) SetDeferUnknownJobTypes(delay time.Duration) {
+	for _, w := range m.workers {
+		w.unknownTypeDelay = delay
+	}
+}
+
+// Start starts the workers
+func (m *Manager) Start() {
+	for _, w := range m.workers {
+		m.workerWg.Add(1)
+		go w.Start()
+	}
+}
+
+// Go runs task in the background until Shutdown. The task must return once
+// done is closed.
+func (m *Manager) Go(task func(done <-chan struct{})) {
+	m.taskWg.Add(1)
+	go func() {
+		defer m.taskWg.Done()
+		task(m.done)
+	}()
+}
+
+// Shutdown closes the done channel for background tasks, stops the workers,
+// waits for them to finish their in-flight jobs and then waits for the
+// background tasks. It is safe to call more than once.
+func (m *Manager) Shutdown() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+		for _, w := range m.workers {
+			w.Stop()
+		}
+	})
+
+	m.workerWg.Wait()
+	m.taskWg.Wait()
+}
@@ -0,0 +1,1955 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"openapi-validation-example/db"
+	"openapi-validation-example/generated"
+	"openapi-validation-example/internal/worker"
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestDatabase creates a database service on a fresh database in the
+// test's temporary directory, so tests never share a file. It also returns
+// the database path, for tests that open it again.
+func setupTestDatabase(t testing.TB) (*database.DatabaseService, string) {
+	testDBPath := filepath.Join(t.TempDir(), "test_job_queue.db")
+
+	db, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db, testDBPath
+}
+
+// setupTestJobQueue creates a job queue backed by a fresh test database
+func setupTestJobQueue(t testing.TB) *jobs.JobQueueService {
+	db, _ := setupTestDatabase(t)
+	return db.GetJobQueue()
+}
+
+func TestJobQueueService_ListJobsPaginated(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	var pendingIDs []int64
+	for i := 0; i < 5; i++ {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: fmt.Sprintf("page test %d", i)}, i%2)
+		require.NoError(t, err)
+		pendingIDs = append(pendingIDs, job.ID)
+	}
+	cancelled, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "other status"}, 0)
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.CancelJob(context.Background(), cancelled.ID))
+
+	// Walk the pages, enqueueing another job part way through; it goes at
+	// the end instead of shifting the pages
+	var listedIDs []int64
+	var afterID int64
+	for page := 0; ; page++ {
+		listed, err := jobQueue.ListJobsPaginated(context.Background(), "pending", 2, afterID)
+		require.NoError(t, err)
+		if len(listed) == 0 {
+			break
+		}
+		for _, job := range listed {
+			listedIDs = append(listedIDs, job.ID)
+		}
+		afterID = listed[len(listed)-1].ID
+
+		if page == 0 {
+			job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "enqueued while paging"}, 5)
+			require.NoError(t, err)
+			pendingIDs = append(pendingIDs, job.ID)
+		}
+	}
+	assert.Equal(t, pendingIDs, listedIDs)
+
+	listed, err := jobQueue.ListJobsPaginated(context.Background(), "cancelled", 2, 0)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, cancelled.ID, listed[0].ID)
+}
+
+func TestJobQueueService_ListJobsFiltered(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	enqueue := func(jobType jobs.JobType) int64 {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobType, jobs.JobPayload{Message: "filter test", Recipients: []string{"ops@example.com"}}, 0)
+		require.NoError(t, err)
+		return job.ID
+	}
+	pendingEmail := enqueue(jobs.JobEmailNotification)
+	pendingAnalysis := enqueue(jobs.JobDataAnalysis)
+	cancelledEmail := enqueue(jobs.JobEmailNotification)
+	cancelledAnalysis := enqueue(jobs.JobDataAnalysis)
+	require.NoError(t, jobQueue.CancelJob(context.Background(), cancelledEmail))
+	require.NoError(t, jobQueue.CancelJob(context.Background(), cancelledAnalysis))
+
+	tests := []struct {
+		name    string
+		status  string
+		jobType string
+		want    []int64
+	}{
+		{"any status and type", "", "", []int64{cancelledAnalysis, cancelledEmail, pendingAnalysis, pendingEmail}},
+		{"status only", "pending", "", []int64{pendingAnalysis, pendingEmail}},
+		{"type only", "", string(jobs.JobEmailNotification), []int64{cancelledEmail, pendingEmail}},
+		{"status and type", "cancelled", string(jobs.JobDataAnalysis), []int64{cancelledAnalysis}},
+		{"no match", "failed", string(jobs.JobEmailNotification), nil},
+		{"unknown type", "", "no_such_type", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed, err := jobQueue.ListJobsFiltered(context.Background(), tt.status, tt.jobType, 20)
+			require.NoError(t, err)
+
+			var listedIDs []int64
+			for _, job := range listed {
+				listedIDs = append(listedIDs, job.ID)
+			}
+			assert.Equal(t, tt.want, listedIDs)
+		})
+	}
+
+	// The limit applies after filtering
+	listed, err := jobQueue.ListJobsFiltered(context.Background(), "", string(jobs.JobDataAnalysis), 1)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, cancelledAnalysis, listed[0].ID)
+
+	// Pages are filtered the same way
+	listed, err = jobQueue.ListJobsFilteredPaginated(context.Background(), "", string(jobs.JobEmailNotification), 20, pendingEmail)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, cancelledEmail, listed[0].ID)
+}
+
+func TestJobQueueService_GetJobStatsByType(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	stats, err := jobQueue.GetJobStatsByType(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, stats)
+
+	enqueue := func(jobType jobs.JobType) int64 {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobType, jobs.JobPayload{Message: "by type", Recipients: []string{"ops@example.com"}}, 0)
+		require.NoError(t, err)
+		return job.ID
+	}
+	enqueue(jobs.JobEmailNotification)
+	enqueue(jobs.JobEmailNotification)
+	require.NoError(t, jobQueue.CancelJob(context.Background(), enqueue(jobs.JobEmailNotification)))
+	analysis := enqueue(jobs.JobDataAnalysis)
+	require.NoError(t, jobQueue.FailJob(context.Background(), analysis, "broken", false))
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), enqueue(jobs.JobDataAnalysis)))
+
+	stats, err = jobQueue.GetJobStatsByType(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]jobs.JobTypeStats{
+		string(jobs.JobEmailNotification): {Pending: 2, Cancelled: 1},
+		string(jobs.JobDataAnalysis):      {Completed: 1, Failed: 1},
+	}, stats)
+
+	// The per-type counts add up to the overall ones
+	totals, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	var pending, failed int64
+	for _, typeStats := range stats {
+		pending += typeStats.Pending
+		failed += typeStats.Failed
+	}
+	assert.Equal(t, totals.PendingCount, pending)
+	assert.Equal(t, totals.FailedCount, failed)
+}
+
+func TestJobQueueService_SortByClaimOrder(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// More jobs than worker-manager lists (listPageSize, 20), with the
+	// highest priorities enqueued first, so the jobs that run next are not
+	// the newest ones
+	priorities := []int{0, 2, 1, 2, 0}
+	for i := 0; i < 20; i++ {
+		priorities = append(priorities, i%2)
+	}
+	for _, priority := range priorities {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "order test"}, priority)
+		require.NoError(t, err)
+	}
+
+	// Order shown by the CLI: the first page of pending jobs in claim order
+	listed, err := jobQueue.ListPendingJobsInClaimOrder(context.Background(), "", 20)
+	require.NoError(t, err)
+	require.Len(t, listed, 20)
+
+	var listedIDs []int64
+	for _, job := range listed {
+		listedIDs = append(listedIDs, job.ID)
+	}
+
+	// SortByClaimOrder agrees on every pending job
+	all, err := jobQueue.ListJobs(context.Background(), "pending", len(priorities))
+	require.NoError(t, err)
+	require.Len(t, all, len(priorities))
+	jobs.SortByClaimOrder(all)
+
+	var sortedIDs []int64
+	for _, job := range all {
+		sortedIDs = append(sortedIDs, job.ID)
+	}
+
+	// scheduled_at has sub-second precision while the claim query compares it
+	// against CURRENT_TIMESTAMP (whole seconds), so wait until the jobs are due
+	time.Sleep(1100 * time.Millisecond)
+
+	// Order in which workers claim the same jobs
+	var claimedIDs []int64
+	for {
+		job, err := jobQueue.GetNextJob(context.Background())
+		require.NoError(t, err)
+		if job == nil {
+			break
+		}
+		claimedIDs = append(claimedIDs, job.ID)
+	}
+
+	assert.Equal(t, claimedIDs, sortedIDs)
+	assert.Equal(t, claimedIDs[:20], listedIDs)
+	assert.Equal(t, []int64{2, 4, 3}, listedIDs[:3])
+	assert.Contains(t, listedIDs, int64(1))
+}
+
+func TestJobQueueService_GetNextJobPriorityOrder(t *testing.T) {
+	dbService, testDBPath := setupTestDatabase(t)
+	jobQueue := dbService.GetJobQueue()
+
+	for _, priority := range []int{0, 1, 2} {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "priority test"}, priority)
+		require.NoError(t, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	var claimed []int64
+	for {
+		job, err := jobQueue.GetNextJob(context.Background())
+		require.NoError(t, err)
+		if job == nil {
+			break
+		}
+		claimed = append(claimed, job.Priority.Int64)
+	}
+	assert.Equal(t, []int64{2, 1, 0}, claimed)
+
+	// Jobs with the same priority and scheduled_at are claimed in creation order
+	var tied []int64
+	for i := 0; i < 3; i++ {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "tie"}, 1)
+		require.NoError(t, err)
+		tied = append(tied, job.ID)
+	}
+	rawDB, err := sql.Open("sqlite", testDBPath)
+	require.NoError(t, err)
+	defer rawDB.Close()
+	_, err = rawDB.Exec("UPDATE job_queue SET scheduled_at = ? WHERE status = 'pending'",
+		time.Now().Add(-time.Minute).UTC())
+	require.NoError(t, err)
+
+	var claimedIDs []int64
+	for {
+		job, err := jobQueue.GetNextJob(context.Background())
+		require.NoError(t, err)
+		if job == nil {
+			break
+		}
+		claimedIDs = append(claimedIDs, job.ID)
+	}
+	assert.Equal(t, tied, claimedIDs)
+}
+
+func TestJobQueueService_GetNextJobs(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// An empty queue is not an error
+	batch, err := jobQueue.GetNextJobs(context.Background(), 5)
+	require.NoError(t, err)
+	require.NotNil(t, batch)
+	assert.Empty(t, batch)
+
+	for _, priority := range []int{0, 2, 1} {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "batch test"}, priority)
+		require.NoError(t, err)
+	}
+
+	// scheduled_at is compared against CURRENT_TIMESTAMP, which has one second resolution
+	time.Sleep(1100 * time.Millisecond)
+
+	batch, err = jobQueue.GetNextJobs(context.Background(), 2)
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, int64(2), batch[0].Priority.Int64)
+	assert.Equal(t, int64(1), batch[1].Priority.Int64)
+	for _, job := range batch {
+		assert.Equal(t, "processing", job.Status)
+	}
+
+	batch, err = jobQueue.GetNextJobs(context.Background(), 2)
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+
+	// Everything is claimed
+	batch, err = jobQueue.GetNextJobs(context.Background(), 2)
+	require.NoError(t, err)
+	require.NotNil(t, batch)
+	assert.Empty(t, batch)
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.ProcessingCount)
+}
+
+func TestJobQueueService_SetPriorityForStatus(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// A high priority job that currently runs first
+	urgent, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "urgent"}, 5)
+	require.NoError(t, err)
+
+	var exportIDs []int64
+	for i := 0; i < 3; i++ {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataExport, jobs.JobPayload{Message: "export"}, 0)
+		require.NoError(t, err)
+		exportIDs = append(exportIDs, job.ID)
+	}
+
+	updated, err := jobQueue.SetPriorityForStatus(context.Background(), jobs.JobDataExport, "pending", 9)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), updated)
+
+	// Only the data_export jobs changed
+	pending, err := jobQueue.ListJobs(context.Background(), "pending", 20)
+	require.NoError(t, err)
+	for _, job := range pending {
+		if job.JobType == string(jobs.JobDataExport) {
+			assert.Equal(t, int64(9), job.Priority.Int64)
+		} else {
+			assert.Equal(t, int64(5), job.Priority.Int64)
+		}
+	}
+
+	// Other statuses are rejected
+	_, err = jobQueue.SetPriorityForStatus(context.Background(), jobs.JobDataExport, "completed", 9)
+	assert.Error(t, err)
+
+	// Wait until the jobs are due (see TestJobQueueService_SortByClaimOrder)
+	time.Sleep(1100 * time.Millisecond)
+
+	// The reprioritized jobs are now claimed before the urgent job
+	var claimedIDs []int64
+	for {
+		job, err := jobQueue.GetNextJob(context.Background())
+		require.NoError(t, err)
+		if job == nil {
+			break
+		}
+		claimedIDs = append(claimedIDs, job.ID)
+	}
+	assert.Equal(t, append(exportIDs, urgent.ID), claimedIDs)
+}
+
+func TestJobQueueService_PriorityBounds(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	for _, priority := range []int{jobs.MinPriority, jobs.MaxPriority} {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "in range"}, priority)
+		require.NoError(t, err)
+		assert.Equal(t, int64(priority), job.Priority.Int64)
+	}
+
+	for _, priority := range []int{jobs.MinPriority - 1, jobs.MaxPriority + 1, 1 << 40} {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "out of range"}, priority)
+		assert.ErrorIs(t, err, jobs.ErrPriorityOutOfRange, "priority %d", priority)
+
+		_, err = jobQueue.EnqueueJobs(context.Background(), []jobs.JobSpec{{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: "out of range"}, Priority: priority}})
+		assert.ErrorIs(t, err, jobs.ErrPriorityOutOfRange, "priority %d", priority)
+
+		_, err = jobQueue.SetPriorityForStatus(context.Background(), jobs.JobDataAnalysis, "pending", priority)
+		assert.ErrorIs(t, err, jobs.ErrPriorityOutOfRange, "priority %d", priority)
+	}
+
+	// Rejected jobs are not stored, and the stored ones keep their priority
+	pending, err := jobQueue.ListJobs(context.Background(), "pending", 20)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	updated, err := jobQueue.SetPriorityForStatus(context.Background(), jobs.JobDataAnalysis, "pending", jobs.MaxPriority)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated)
+}
+
+func TestJobQueueService_ValidateEmailRecipients(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, jobs.JobPayload{
+		Message:    "Welcome!",
+		Recipients: []string{"admin@example.com", "user@example.com"},
+	}, 0)
+	require.NoError(t, err)
+
+	invalid := map[string][]string{
+		"no recipients":     nil,
+		"empty recipient":   {""},
+		"malformed":         {"admin@example.com", "not-an-email"},
+		"display name":      {"Admin <admin@example.com>"},
+		"surrounding space": {" user@example.com"},
+	}
+	for name, recipients := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, jobs.JobPayload{Message: "Welcome!", Recipients: recipients}, 0)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid email_notification payload")
+		})
+	}
+
+	// Rejected jobs are not stored
+	pending, err := jobQueue.ListJobs(context.Background(), "pending", 20)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	// Other job types don't need recipients, and the check can be turned off
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "no recipients"}, 0)
+	require.NoError(t, err)
+
+	jobQueue.SetPayloadValidator(jobs.JobEmailNotification, nil)
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, jobs.JobPayload{Message: "Welcome!"}, 0)
+	require.NoError(t, err)
+}
+
+func TestJobQueueService_ValidatePayloads(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	userID := int64(7)
+	zero := int64(0)
+	tests := []struct {
+		jobType jobs.JobType
+		valid   jobs.JobPayload
+		invalid map[string]jobs.JobPayload
+	}{
+		{
+			jobType: jobs.JobUserCreated,
+			valid:   jobs.JobPayload{UserID: &userID},
+			invalid: map[string]jobs.JobPayload{
+				"no user_id":   {Message: "welcome"},
+				"zero user_id": {UserID: &zero},
+			},
+		},
+		{
+			jobType: jobs.JobUserDeleted,
+			valid:   jobs.JobPayload{UserID: &userID},
+			invalid: map[string]jobs.JobPayload{
+				"no user_id": {UserData: map[string]interface{}{"email": "gone@example.com"}},
+			},
+		},
+		{
+			jobType: jobs.JobDataAnalysis,
+			valid:   jobs.JobPayload{Message: "weekly signups"},
+			invalid: map[string]jobs.JobPayload{
+				"no message":    {},
+				"blank message": {Message: "  "},
+			},
+		},
+		{
+			jobType: jobs.JobEmailNotification,
+			valid:   jobs.JobPayload{Recipients: []string{"user@example.com"}},
+			invalid: map[string]jobs.JobPayload{
+				"no recipients": {Message: "newsletter"},
+			},
+		},
+		{
+			jobType: jobs.JobDataExport,
+			valid:   jobs.JobPayload{Message: "users to warehouse"},
+			invalid: map[string]jobs.JobPayload{
+				"no message": {UserID: &userID},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.jobType), func(t *testing.T) {
+			_, err := jobQueue.EnqueueJob(context.Background(), tt.jobType, tt.valid, 0)
+			require.NoError(t, err)
+
+			for name, payload := range tt.invalid {
+				_, err := jobQueue.EnqueueJob(context.Background(), tt.jobType, payload, 0)
+				require.Error(t, err, name)
+				assert.Contains(t, err.Error(), fmt.Sprintf("invalid %s payload", tt.jobType), name)
+			}
+		})
+	}
+
+	// Only the valid jobs were stored
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(tests)), stats.PendingCount)
+}
+
+func TestJobQueueService_RecordJobDuration(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	durations := map[jobs.JobType][]time.Duration{
+		jobs.JobDataAnalysis:      {100 * time.Millisecond, 300 * time.Millisecond},
+		jobs.JobEmailNotification: {50 * time.Millisecond},
+	}
+	for jobType, ds := range durations {
+		for _, d := range ds {
+			job, err := jobQueue.EnqueueJob(context.Background(), jobType, jobs.JobPayload{Message: "timed", Recipients: []string{"ops@example.com"}}, 0)
+			require.NoError(t, err)
+			require.NoError(t, jobQueue.RecordJobDuration(context.Background(), job.ID, d))
+		}
+	}
+
+	// A job without a recorded duration is left out of the averages
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataExport, jobs.JobPayload{Message: "untimed"}, 0)
+	require.NoError(t, err)
+
+	pending, err := jobQueue.ListJobs(context.Background(), "pending", 20)
+	require.NoError(t, err)
+	for _, job := range pending {
+		assert.Equal(t, job.JobType != string(jobs.JobDataExport), job.DurationMs.Valid)
+	}
+
+	stats, err := jobQueue.GetJobDurationStats(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+
+	assert.Equal(t, string(jobs.JobDataAnalysis), stats[0].JobType)
+	assert.Equal(t, int64(2), stats[0].JobCount)
+	assert.InDelta(t, 200.0, stats[0].AvgDurationMs.Float64, 0.001)
+
+	assert.Equal(t, string(jobs.JobEmailNotification), stats[1].JobType)
+	assert.Equal(t, int64(1), stats[1].JobCount)
+	assert.InDelta(t, 50.0, stats[1].AvgDurationMs.Float64, 0.001)
+}
+
+func TestJobQueueService_MigratesDurationColumn(t *testing.T) {
+	testDBPath := "test_job_queue_migration.db"
+	os.Remove(testDBPath)
+	t.Cleanup(func() { os.Remove(testDBPath) })
+
+	// A job queue table from before duration tracking
+	oldDB, err := sql.Open("sqlite", testDBPath)
+	require.NoError(t, err)
+	_, err = oldDB.Exec(`CREATE TABLE job_queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    priority INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 3,
+    retry_count INTEGER DEFAULT 0,
+    error_message TEXT,
+    scheduled_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    completed_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`)
+	require.NoError(t, err)
+	require.NoError(t, oldDB.Close())
+
+	dbService, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	defer dbService.Close()
+
+	job, err := dbService.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "migrated"}, 0)
+	require.NoError(t, err)
+	require.NoError(t, dbService.GetJobQueue().RecordJobDuration(context.Background(), job.ID, 42*time.Millisecond))
+}
+
+func TestJobQueueService_ConcurrentEnqueueAndClaim(t *testing.T) {
+	testDBPath := "test_job_queue_concurrent.db"
+	os.Remove(testDBPath)
+
+	// Separate services on one file, like the API server and a worker process
+	producer, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	consumer, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		producer.Close()
+		consumer.Close()
+		os.Remove(testDBPath)
+	})
+
+	const producers, jobsPerProducer = 4, 25
+	total := producers * jobsPerProducer
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    []error
+		claimed = map[int64]int{}
+	)
+	recordErr := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < jobsPerProducer; i++ {
+				if _, err := producer.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "concurrent"}, i%3); err != nil {
+					recordErr(err)
+				}
+			}
+		}()
+	}
+
+	// Claimers keep going until every job is claimed; scheduled_at is
+	// compared against CURRENT_TIMESTAMP with one second resolution
+	deadline := time.Now().Add(10 * time.Second)
+	for c := 0; c < 2; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				batch, err := consumer.GetJobQueue().GetNextJobs(context.Background(), 5)
+				if err != nil {
+					recordErr(err)
+					continue
+				}
+
+				mu.Lock()
+				for _, job := range batch {
+					claimed[job.ID]++
+				}
+				done := len(claimed) == total
+				mu.Unlock()
+
+				for _, job := range batch {
+					if err := consumer.GetJobQueue().CompleteJob(context.Background(), job.ID); err != nil {
+						recordErr(err)
+					}
+				}
+				if done {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		t.Fatalf("%d errors during concurrent enqueue and claim, first: %v", len(errs), errs[0])
+	}
+	assert.Len(t, claimed, total)
+	for id, count := range claimed {
+		assert.Equal(t, 1, count, "job %d claimed more than once", id)
+	}
+
+	// WAL mode is persisted in the database file
+	rawDB, err := sql.Open("sqlite", testDBPath)
+	require.NoError(t, err)
+	defer rawDB.Close()
+	var journalMode string
+	require.NoError(t, rawDB.QueryRow("PRAGMA journal_mode").Scan(&journalMode))
+	assert.Equal(t, "wal", journalMode)
+}
+
+func TestDatabaseService_PoolConfig(t *testing.T) {
+	testDBPath := "test_pool_config.db"
+	os.Remove(testDBPath)
+	t.Cleanup(func() { os.Remove(testDBPath) })
+
+	// Defaults suit SQLite's single writer
+	dbService, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	assert.Equal(t, 1, dbService.Stats().MaxOpenConnections)
+	require.NoError(t, dbService.Close())
+
+	cfg := database.DatabaseConfig{
+		BusyTimeout:     time.Second,
+		MaxOpenConns:    4,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: time.Minute,
+	}
+	dbService, err = database.NewDatabaseServiceWithConfig(testDBPath, cfg)
+	require.NoError(t, err)
+	defer dbService.Close()
+	assert.Equal(t, 4, dbService.Stats().MaxOpenConnections)
+
+	// The job queue runs on the same pool
+	_, err = dbService.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "pool"}, 0)
+	require.NoError(t, err)
+	stats, err := dbService.GetJobQueue().GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.PendingCount)
+	assert.LessOrEqual(t, dbService.Stats().OpenConnections, 4)
+}
+
+func TestDatabaseService_Driver(t *testing.T) {
+	// Unknown drivers are rejected before anything is opened
+	_, err := database.NewDatabaseServiceWithConfig("test.db", database.DatabaseConfig{Driver: "mysql"})
+	require.Error(t, err)
+	assert.Equal(t, `unsupported database driver "mysql"`, err.Error())
+
+	// Postgres is supported but its driver must be imported by the binary
+	_, err = database.NewDatabaseServiceWithConfig("postgres://localhost/users", database.DatabaseConfig{Driver: database.DriverPostgres})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown driver "pgx"`)
+
+	// An explicit SQLite driver behaves like the default
+	testDBPath := "test_driver.db"
+	os.Remove(testDBPath)
+	t.Cleanup(func() { os.Remove(testDBPath) })
+
+	cfg := database.DefaultDatabaseConfig()
+	cfg.Driver = database.DriverSQLite
+	dbService, err := database.NewDatabaseServiceWithConfig(testDBPath, cfg)
+	require.NoError(t, err)
+	defer dbService.Close()
+	require.NoError(t, dbService.GetJobQueue().Ping(context.Background()))
+}
+
+func TestJobQueueService_StatsCache(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	const ttl = 300 * time.Millisecond
+	jobQueue.SetStatsCacheTTL(ttl)
+
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "cached"}, 0)
+	require.NoError(t, err)
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.PendingCount)
+
+	// Within the TTL the cached counts are served
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "cached"}, 0)
+	require.NoError(t, err)
+	stats, err = jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.PendingCount)
+
+	// After it they are read again
+	time.Sleep(ttl + 50*time.Millisecond)
+	stats, err = jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.PendingCount)
+
+	// The background refresh picks up new jobs without a reader waiting for
+	// the TTL, and stops when done is closed
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		jobQueue.RunStatsRefresh(done)
+		close(stopped)
+	}()
+
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "cached"}, 0)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		stats, err := jobQueue.GetJobStats(context.Background())
+		return err == nil && stats.PendingCount == 3
+	}, ttl, 10*time.Millisecond)
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("RunStatsRefresh did not return after done was closed")
+	}
+
+	// Turning the cache off reads the current counts every time
+	jobQueue.SetStatsCacheTTL(0)
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "uncached"}, 0)
+	require.NoError(t, err)
+	stats, err = jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.PendingCount)
+}
+
+func TestJobQueueService_StatsRefreshTinyTTL(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// Half of a 1ns TTL would be a zero ticker interval
+	jobQueue.SetStatsCacheTTL(time.Nanosecond)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		jobQueue.RunStatsRefresh(done)
+		close(stopped)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("RunStatsRefresh did not return after done was closed")
+	}
+}
+
+func TestJobQueueService_PauseQueue(t *testing.T) {
+	dbService, testDBPath := setupTestDatabase(t)
+	jobQueue := dbService.GetJobQueue()
+
+	for i := 0; i < 2; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "maintenance"}, 0)
+		require.NoError(t, err)
+	}
+
+	paused, err := jobQueue.IsQueuePaused(context.Background())
+	require.NoError(t, err)
+	assert.False(t, paused)
+
+	require.NoError(t, jobQueue.PauseQueue(context.Background()))
+
+	// Wait until the jobs are due (see TestJobQueueService_SortByClaimOrder)
+	time.Sleep(1100 * time.Millisecond)
+
+	job, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, job)
+	batch, err := jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, batch)
+
+	// The pause is persisted, so a worker process started later respects it
+	other, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	defer other.Close()
+	paused, err = other.GetJobQueue().IsQueuePaused(context.Background())
+	require.NoError(t, err)
+	assert.True(t, paused)
+	batch, err = other.GetJobQueue().GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, batch)
+
+	// Enqueueing still works while paused
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "queued while paused"}, 0)
+	require.NoError(t, err)
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.PendingCount)
+	assert.Equal(t, int64(0), stats.ProcessingCount)
+
+	require.NoError(t, other.GetJobQueue().ResumeQueue(context.Background()))
+	time.Sleep(1100 * time.Millisecond)
+
+	batch, err = jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Len(t, batch, 3)
+
+	paused, err = jobQueue.IsQueuePaused(context.Background())
+	require.NoError(t, err)
+	assert.False(t, paused)
+}
+
+func TestJobPayload_TypedProps(t *testing.T) {
+	// As a worker sees the props: decoded from the stored JSON, where every
+	// number is a float64
+	var payload jobs.JobPayload
+	require.NoError(t, json.Unmarshal([]byte(`{"additional_props": {
+		"hobby": "chess", "score": 42, "ratio": 0.5, "big": 1e20,
+		"numeric_string": "42", "verified": true, "tags": ["a"], "nothing": null
+	}}`), &payload))
+
+	hobby, ok := payload.StringProp("hobby")
+	assert.True(t, ok)
+	assert.Equal(t, "chess", hobby)
+	_, ok = payload.StringProp("score")
+	assert.False(t, ok, "number is not a string")
+	_, ok = payload.StringProp("missing")
+	assert.False(t, ok)
+	_, ok = payload.StringProp("nothing")
+	assert.False(t, ok)
+
+	score, ok := payload.FloatProp("score")
+	assert.True(t, ok)
+	assert.Equal(t, 42.0, score)
+	ratio, ok := payload.FloatProp("ratio")
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, ratio)
+	_, ok = payload.FloatProp("numeric_string")
+	assert.False(t, ok, "numeric string is not a number")
+	_, ok = payload.FloatProp("tags")
+	assert.False(t, ok)
+
+	whole, ok := payload.IntProp("score")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), whole)
+	_, ok = payload.IntProp("ratio")
+	assert.False(t, ok, "not a whole number")
+	_, ok = payload.IntProp("big")
+	assert.False(t, ok, "does not fit in an int64")
+
+	verified, ok := payload.BoolProp("verified")
+	assert.True(t, ok)
+	assert.True(t, verified)
+	_, ok = payload.BoolProp("hobby")
+	assert.False(t, ok)
+
+	// Props set in Go before the payload is stored
+	payload = jobs.JobPayload{AdditionalProps: map[string]interface{}{"count": 3, "id": int64(9), "n": json.Number("2.5")}}
+	count, ok := payload.FloatProp("count")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, count)
+	id, ok := payload.IntProp("id")
+	assert.True(t, ok)
+	assert.Equal(t, int64(9), id)
+	n, ok := payload.FloatProp("n")
+	assert.True(t, ok)
+	assert.Equal(t, 2.5, n)
+
+	// No props at all
+	_, ok = jobs.JobPayload{}.StringProp("hobby")
+	assert.False(t, ok)
+}
+
+func TestPayloadPreview_RawFallback(t *testing.T) {
+	// A well-formed payload is summarized
+	assert.Equal(t, []string{"User ID: 42", "Message: Welcome!"},
+		jobs.PayloadPreview(`{"user_id": 42, "message": "Welcome!"}`, jobs.DefaultPreviewRawLength))
+
+	tests := []struct {
+		name     string
+		payload  string
+		expected string
+	}{
+		{"user_id too large for int64", `{"user_id": 1e20}`, `Payload (raw): {"user_id": 1e20}`},
+		{"user_id of the wrong type", `{"user_id": "42"}`, `Payload (raw): {"user_id": "42"}`},
+		{"not an object", `[1, 2, 3]`, `Payload (raw): [1, 2, 3]`},
+		{"truncated JSON", `{"message": "cut off`, `Payload (raw): {"message": "cut off`},
+		{"nothing to summarize", `{"user_data": {"id": 1}}`, `Payload (raw): {"user_data": {"id": 1}}`},
+		{"empty", ``, `Payload (raw): `},
+		{"control characters", "{\"message\": \"\x1b[2J\"", `Payload (raw): "{\"message\": \"\x1b[2J\""`},
+		{"invalid UTF-8", "\xff\xfe", `Payload (raw): "\xff\xfe"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, []string{tt.expected}, jobs.PayloadPreview(tt.payload, jobs.DefaultPreviewRawLength))
+		})
+	}
+
+	// Long raw payloads are cut without splitting a character
+	long := `{"user_id": "` + strings.Repeat("é", 20) + `"}`
+	assert.Equal(t, []string{`Payload (raw): {"user_id": "ééé...`}, jobs.PayloadPreview(long, 19))
+
+	// show renders the whole payload, indented when it is valid JSON
+	assert.Equal(t, "{\n  \"user_id\": 1e20\n}", jobs.FormatPayload(`{"user_id": 1e20}`))
+	assert.Equal(t, `{"message": "cut off`, jobs.FormatPayload(`{"message": "cut off`))
+}
+
+func TestJobQueueService_GetJob(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "inspect me"}, 0)
+	require.NoError(t, err)
+
+	fetched, err := jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job.Payload, fetched.Payload)
+	assert.Equal(t, []string{"Message: inspect me"}, jobs.PayloadPreview(fetched.Payload, 0))
+
+	_, err = jobQueue.GetJob(context.Background(), job.ID+100)
+	assert.ErrorIs(t, err, jobs.ErrJobNotFound)
+	assert.Equal(t, "job not found", err.Error())
+}
+
+func TestJobQueueService_FairScheduling(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// A flood of high-priority jobs of one type and a few of another
+	for i := 0; i < 20; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "flood"}, 9)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataExport, jobs.JobPayload{Message: "export"}, 0)
+		require.NoError(t, err)
+	}
+
+	// Wait until the jobs are due (see TestJobQueueService_SortByClaimOrder)
+	time.Sleep(1100 * time.Millisecond)
+
+	// In priority order the first batch is all data_analysis
+	batch, err := jobQueue.GetNextJobs(context.Background(), 4)
+	require.NoError(t, err)
+	require.Len(t, batch, 4)
+	for _, job := range batch {
+		assert.Equal(t, string(jobs.JobDataAnalysis), job.JobType)
+	}
+
+	require.NoError(t, jobQueue.SetFairScheduling(map[jobs.JobType]int{jobs.JobDataAnalysis: 2}))
+
+	// With weights 2:1 data_export gets every third claim
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		job, err := jobQueue.GetNextJob(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, "processing", job.Status)
+		counts[job.JobType]++
+	}
+	assert.Equal(t, 4, counts[string(jobs.JobDataAnalysis)])
+	assert.Equal(t, 2, counts[string(jobs.JobDataExport)])
+
+	// Batches take turns too, and drain the remaining type once one runs out
+	batch, err = jobQueue.GetNextJobs(context.Background(), 20)
+	require.NoError(t, err)
+	require.Len(t, batch, 13)
+	counts = map[string]int{}
+	for _, job := range batch {
+		counts[job.JobType]++
+	}
+	assert.Equal(t, 12, counts[string(jobs.JobDataAnalysis)])
+	assert.Equal(t, 1, counts[string(jobs.JobDataExport)])
+
+	// Weights must be positive
+	assert.Error(t, jobQueue.SetFairScheduling(map[jobs.JobType]int{jobs.JobDataExport: 0}))
+
+	weights, err := jobs.ParseFairWeights("user_created=3, email_notification=1")
+	require.NoError(t, err)
+	assert.Equal(t, map[jobs.JobType]int{jobs.JobUserCreated: 3, jobs.JobEmailNotification: 1}, weights)
+	_, err = jobs.ParseFairWeights("user_created")
+	assert.Error(t, err)
+	_, err = jobs.ParseFairWeights("user_created=-1")
+	assert.Error(t, err)
+}
+
+func TestJobQueueService_ConcurrencyLimits(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	require.NoError(t, jobQueue.SetConcurrencyLimits(map[jobs.JobType]int{jobs.JobDataAnalysis: 1}))
+
+	for i := 0; i < 3; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "analyze"}, 5)
+		require.NoError(t, err)
+	}
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataExport, jobs.JobPayload{Message: "export"}, 0)
+	require.NoError(t, err)
+
+	// Only one analysis job is claimed despite its higher priority; other
+	// types are unaffected
+	batch, err := jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, string(jobs.JobDataAnalysis), batch[0].JobType)
+	assert.Equal(t, string(jobs.JobDataExport), batch[1].JobType)
+
+	job, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, job)
+
+	// Finishing the running job frees its slot
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), batch[0].ID))
+	job, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, string(jobs.JobDataAnalysis), job.JobType)
+
+	// nil removes the limits
+	require.NoError(t, jobQueue.SetConcurrencyLimits(nil))
+	job, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, string(jobs.JobDataAnalysis), job.JobType)
+
+	// Limits must be positive
+	assert.Error(t, jobQueue.SetConcurrencyLimits(map[jobs.JobType]int{jobs.JobDataAnalysis: 0}))
+
+	limits, err := jobs.ParseConcurrencyLimits("data_analysis=2, data_export=1")
+	require.NoError(t, err)
+	assert.Equal(t, map[jobs.JobType]int{jobs.JobDataAnalysis: 2, jobs.JobDataExport: 1}, limits)
+	_, err = jobs.ParseConcurrencyLimits("data_analysis=0")
+	assert.Error(t, err)
+}
+
+func TestJobQueueService_ConcurrencyLimitsConcurrentClaims(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	const limit = 2
+	require.NoError(t, jobQueue.SetConcurrencyLimits(map[jobs.JobType]int{jobs.JobDataAnalysis: limit}))
+
+	const total = 8
+	for i := 0; i < total; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "analyze"}, 0)
+		require.NoError(t, err)
+	}
+
+	var (
+		wg          sync.WaitGroup
+		inFlight    atomic.Int32
+		maxInFlight atomic.Int32
+		completed   atomic.Int32
+		errs        = make(chan error, 100)
+	)
+	deadline := time.Now().Add(10 * time.Second)
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for completed.Load() < total && time.Now().Before(deadline) {
+				job, err := jobQueue.GetNextJob(context.Background())
+				if err != nil {
+					errs <- err
+					return
+				}
+				if job == nil {
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+
+				n := inFlight.Add(1)
+				for {
+					seen := maxInFlight.Load()
+					if n <= seen || maxInFlight.CompareAndSwap(seen, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				inFlight.Add(-1)
+
+				if err := jobQueue.CompleteJob(context.Background(), job.ID); err != nil {
+					errs <- err
+					return
+				}
+				completed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(total), completed.Load())
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(limit))
+}
+
+func TestJobQueueService_EnqueueJobs(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	specs := []jobs.JobSpec{
+		{Type: jobs.JobEmailNotification, Payload: jobs.JobPayload{Recipients: []string{"a@example.com"}}, Priority: 1},
+		{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: "analyze"}},
+		{Type: jobs.JobEmailNotification, Payload: jobs.JobPayload{Recipients: []string{"b@example.com"}}, Priority: 2},
+	}
+	created, err := jobQueue.EnqueueJobs(context.Background(), specs)
+	require.NoError(t, err)
+	require.Len(t, created, len(specs))
+	for i, job := range created {
+		assert.Equal(t, string(specs[i].Type), job.JobType)
+		assert.Equal(t, int64(specs[i].Priority), job.Priority.Int64)
+		assert.Equal(t, "pending", job.Status)
+		if i > 0 {
+			assert.Greater(t, job.ID, created[i-1].ID)
+		}
+	}
+
+	// One invalid spec enqueues nothing
+	_, err = jobQueue.EnqueueJobs(context.Background(), []jobs.JobSpec{
+		{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: "ok"}},
+		{Type: jobs.JobEmailNotification, Payload: jobs.JobPayload{Recipients: []string{"not an address"}}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "job 1: invalid email_notification payload")
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.PendingCount)
+
+	created, err = jobQueue.EnqueueJobs(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, created)
+}
+
+func TestJobQueueService_EnqueueJobsCancelled(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// Cancel the batch while its second job is being enqueued, after the
+	// first one was inserted
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	jobQueue.SetPayloadValidator(jobs.JobDataAnalysis, func(jobs.JobPayload) error {
+		calls++
+		if calls == 2 {
+			cancel()
+		}
+		return nil
+	})
+
+	specs := make([]jobs.JobSpec, 5)
+	for i := range specs {
+		specs[i] = jobs.JobSpec{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: fmt.Sprintf("part %d", i)}}
+	}
+	created, err := jobQueue.EnqueueJobs(ctx, specs)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, created)
+	assert.Equal(t, 2, calls, "the batch stops at the cancellation")
+
+	// The insert before the cancellation was rolled back too
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, stats.PendingCount)
+
+	// An already cancelled context enqueues nothing
+	_, err = jobQueue.EnqueueJobs(ctx, specs[:1])
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The service is still usable afterwards
+	created, err = jobQueue.EnqueueJobs(context.Background(), specs[:1])
+	require.NoError(t, err)
+	assert.Len(t, created, 1)
+}
+
+func TestJobQueueService_CancelledContext(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "cancel me"}, 0)
+	require.NoError(t, err)
+
+	// Every query gives up once ctx is done, including the ones workers and
+	// the reapers run in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = jobQueue.GetJob(ctx, job.ID)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = jobQueue.ListJobsFiltered(ctx, "pending", "", 10)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, jobQueue.CancelJob(ctx, job.ID), context.Canceled)
+	assert.ErrorIs(t, jobQueue.Heartbeat(ctx, 1), context.Canceled)
+	_, err = jobQueue.ReapStaleJobs(ctx, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = jobQueue.ReapJobsOfDeadWorkers(ctx, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Nothing was changed
+	fetched, err := jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", fetched.Status)
+}
+
+// Compare fanning out notifications one INSERT (and commit) at a time with a
+// single EnqueueJobs transaction
+func BenchmarkJobQueueService_EnqueueJob(b *testing.B) {
+	jobQueue := setupTestJobQueue(b)
+	payload := jobs.JobPayload{Recipients: []string{"user@example.com"}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, payload, 0)
+			require.NoError(b, err)
+		}
+	}
+}
+
+func BenchmarkJobQueueService_EnqueueJobs(b *testing.B) {
+	jobQueue := setupTestJobQueue(b)
+	specs := make([]jobs.JobSpec, 100)
+	for j := range specs {
+		specs[j] = jobs.JobSpec{
+			Type:    jobs.JobEmailNotification,
+			Payload: jobs.JobPayload{Recipients: []string{"user@example.com"}},
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := jobQueue.EnqueueJobs(context.Background(), specs)
+		require.NoError(b, err)
+	}
+}
+
+func TestJobQueueService_MaxPendingJobs(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	jobQueue.SetMaxPendingJobs(3)
+
+	for i := 0; i < 3; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "fill"}, 0)
+		require.NoError(t, err)
+	}
+
+	// The queue is at its cap
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "overflow"}, 0)
+	assert.ErrorIs(t, err, jobs.ErrQueueFull)
+
+	// A batch that doesn't fit is rejected as a whole
+	jobQueue.SetMaxPendingJobs(4)
+	_, err = jobQueue.EnqueueJobs(context.Background(), []jobs.JobSpec{
+		{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: "batch"}},
+		{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: "batch"}},
+	})
+	assert.ErrorIs(t, err, jobs.ErrQueueFull)
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.PendingCount)
+	jobQueue.SetMaxPendingJobs(3)
+
+	// Claimed jobs no longer count against the cap
+	time.Sleep(1100 * time.Millisecond)
+	batch, err := jobQueue.GetNextJobs(context.Background(), 2)
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+
+	for i := 0; i < 2; i++ {
+		_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "after drain"}, 0)
+		require.NoError(t, err)
+	}
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "overflow"}, 0)
+	assert.ErrorIs(t, err, jobs.ErrQueueFull)
+
+	// 0 removes the cap
+	jobQueue.SetMaxPendingJobs(0)
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "uncapped"}, 0)
+	assert.NoError(t, err)
+}
+
+func TestJobQueueService_CancelJob(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	cancelled, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "mistake"}, 5)
+	require.NoError(t, err)
+	kept, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "keep"}, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, jobQueue.CancelJob(context.Background(), cancelled.ID))
+
+	job, err := jobQueue.GetJob(context.Background(), cancelled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", job.Status)
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.PendingCount)
+	assert.Equal(t, int64(1), stats.CancelledCount)
+
+	// Workers skip the cancelled job despite its higher priority
+	time.Sleep(1100 * time.Millisecond)
+	claimed, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, kept.ID, claimed.ID)
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, claimed)
+
+	// Only pending jobs can be cancelled
+	err = jobQueue.CancelJob(context.Background(), kept.ID)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot cancel processing job")
+
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), kept.ID))
+	err = jobQueue.CancelJob(context.Background(), kept.ID)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot cancel completed job")
+
+	err = jobQueue.CancelJob(context.Background(), cancelled.ID)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot cancel cancelled job")
+
+	err = jobQueue.CancelJob(context.Background(), kept.ID+100)
+	require.Error(t, err)
+	assert.Equal(t, "job not found", err.Error())
+}
+
+func TestJobQueueService_RequeueJob(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "flaky"}, 0)
+	require.NoError(t, err)
+
+	// Pending and processing jobs can't be requeued
+	err = jobQueue.RequeueJob(context.Background(), job.ID, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot requeue pending job")
+
+	time.Sleep(1100 * time.Millisecond)
+	claimed, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	err = jobQueue.RequeueJob(context.Background(), job.ID, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot requeue processing job")
+
+	// A job that used up its retries gets one more attempt
+	require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "boom", true))
+	require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "boom", true))
+	require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "boom", true))
+	require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "gave up", false))
+
+	require.NoError(t, jobQueue.RequeueJob(context.Background(), job.ID, false))
+	requeued, err := jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", requeued.Status)
+	assert.False(t, requeued.ErrorMessage.Valid)
+	assert.False(t, requeued.StartedAt.Valid)
+	assert.False(t, requeued.CompletedAt.Valid)
+	assert.Equal(t, int64(2), requeued.RetryCount.Int64)
+
+	time.Sleep(1100 * time.Millisecond)
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, job.ID, claimed.ID)
+
+	// Resetting restores the full retry budget
+	require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "boom again", false))
+	require.NoError(t, jobQueue.RequeueJob(context.Background(), job.ID, true))
+	requeued, err = jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), requeued.RetryCount.Int64)
+
+	// Neither can completed jobs
+	time.Sleep(1100 * time.Millisecond)
+	_, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), job.ID))
+	err = jobQueue.RequeueJob(context.Background(), job.ID, true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot requeue completed job")
+
+	err = jobQueue.RequeueJob(context.Background(), job.ID+100, true)
+	require.Error(t, err)
+	assert.Equal(t, "job not found", err.Error())
+}
+
+func TestJobQueueService_ReplayDeadJobs(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	enqueueFailed := func(jobType jobs.JobType, payload jobs.JobPayload) int64 {
+		job, err := jobQueue.EnqueueJob(context.Background(), jobType, payload, 0)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "transient", true))
+		}
+		require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "gave up", false))
+		return job.ID
+	}
+	email := jobs.JobPayload{Message: "hi", Recipients: []string{"a@example.com"}}
+	deadEmails := []int64{
+		enqueueFailed(jobs.JobEmailNotification, email),
+		enqueueFailed(jobs.JobEmailNotification, email),
+	}
+	deadAnalysis := []int64{
+		enqueueFailed(jobs.JobDataAnalysis, jobs.JobPayload{Message: "a"}),
+		enqueueFailed(jobs.JobDataAnalysis, jobs.JobPayload{Message: "b"}),
+		enqueueFailed(jobs.JobDataAnalysis, jobs.JobPayload{Message: "c"}),
+	}
+	completedEmail, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, email, 0)
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), completedEmail.ID))
+
+	replayed, err := jobQueue.ReplayDeadJobs(context.Background(), jobs.JobEmailNotification)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(deadEmails)), replayed)
+
+	for _, id := range deadEmails {
+		got, err := jobQueue.GetJob(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "pending", got.Status)
+		assert.Equal(t, int64(0), got.RetryCount.Int64)
+		assert.False(t, got.ErrorMessage.Valid)
+		assert.False(t, got.CompletedAt.Valid)
+	}
+	for _, id := range deadAnalysis {
+		got, err := jobQueue.GetJob(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "failed", got.Status, "other types stay dead")
+		assert.Equal(t, int64(3), got.RetryCount.Int64)
+	}
+	got, err := jobQueue.GetJob(context.Background(), completedEmail.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "completed", got.Status)
+
+	// Without a type every remaining dead-letter job is replayed
+	replayed, err = jobQueue.ReplayDeadJobs(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(deadAnalysis)), replayed)
+	for _, id := range deadAnalysis {
+		got, err := jobQueue.GetJob(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "pending", got.Status)
+	}
+
+	replayed, err = jobQueue.ReplayDeadJobs(context.Background(), "")
+	require.NoError(t, err)
+	assert.Zero(t, replayed)
+}
+
+func TestJobQueueService_MigrateJobType(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// Jobs queued under a type that has since been renamed
+	const legacyType jobs.JobType = "legacy_analysis"
+	var queued []int64
+	for i := 0; i < 2; i++ {
+		job, err := jobQueue.EnqueueJob(context.Background(), legacyType, jobs.JobPayload{Message: "old"}, 0)
+		require.NoError(t, err)
+		queued = append(queued, job.ID)
+	}
+	finished, err := jobQueue.EnqueueJob(context.Background(), legacyType, jobs.JobPayload{Message: "done"}, 0)
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), finished.ID))
+	userID := int64(1)
+	other, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, jobs.JobPayload{Message: "other", UserID: &userID}, 0)
+	require.NoError(t, err)
+
+	migrated, err := jobQueue.MigrateJobType(context.Background(), legacyType, jobs.JobDataAnalysis)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), migrated)
+
+	for _, id := range queued {
+		job, err := jobQueue.GetJob(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, string(jobs.JobDataAnalysis), job.JobType)
+		assert.Equal(t, "pending", job.Status)
+	}
+	job, err := jobQueue.GetJob(context.Background(), finished.ID)
+	require.NoError(t, err)
+	assert.Equal(t, string(legacyType), job.JobType, "finished jobs keep their type")
+	job, err = jobQueue.GetJob(context.Background(), other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, string(jobs.JobUserCreated), job.JobType)
+	require.NoError(t, jobQueue.CancelJob(context.Background(), other.ID))
+
+	_, err = jobQueue.MigrateJobType(context.Background(), jobs.JobDataAnalysis, jobs.JobDataAnalysis)
+	assert.Error(t, err)
+	_, err = jobQueue.MigrateJobType(context.Background(), legacyType, "")
+	assert.Error(t, err)
+
+	// The new type's processor now runs the migrated jobs
+	processor := &slowProcessor{started: make(chan int64, len(queued))}
+	manager := worker.NewManager(jobQueue, map[jobs.JobType]worker.JobProcessor{
+		jobs.JobDataAnalysis: processor,
+	}, 1, 2)
+	manager.Start()
+	defer manager.Shutdown()
+
+	var processed []int64
+	for range queued {
+		select {
+		case id := <-processor.started:
+			processed = append(processed, id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("migrated job was not picked up")
+		}
+	}
+	assert.ElementsMatch(t, queued, processed)
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestJobQueueService_Clock(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// Far from the database's own clock, so CURRENT_TIMESTAMP would never
+	// consider these jobs due
+	start := time.Date(2031, 3, 1, 12, 0, 0, 0, time.FixedZone("UTC+9", 9*60*60))
+	clock := &fakeClock{now: start}
+	jobQueue.SetClock(clock)
+
+	job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "clock"}, 0)
+	require.NoError(t, err)
+	assert.True(t, job.ScheduledAt.Time.Equal(start))
+
+	// A new job is due at once, without waiting for the next database second
+	claimed, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	processing, err := jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.True(t, processing.StartedAt.Time.Equal(start), "started at %v", processing.StartedAt.Time)
+
+	// The first retry is scheduled 5 minutes after the application's now
+	require.NoError(t, jobQueue.FailJob(context.Background(), job.ID, "try again", true))
+	retry, err := jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.True(t, retry.ScheduledAt.Time.Equal(start.Add(5*time.Minute)), "scheduled at %v", retry.ScheduledAt.Time)
+
+	clock.now = start.Add(5*time.Minute - time.Millisecond)
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, claimed, "not due before its scheduled time")
+	batch, err := jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, batch)
+
+	clock.now = start.Add(5 * time.Minute)
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed, "due exactly at its scheduled time")
+	assert.Equal(t, job.ID, claimed.ID)
+}
+
+func TestJobQueueService_ReapStaleJobs(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	start := time.Date(2031, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	jobQueue.SetClock(clock)
+
+	// A job on its last retry
+	lastRetry, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "last retry"}, 0)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		claimed, err := jobQueue.GetNextJob(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, claimed)
+		require.NoError(t, jobQueue.FailJob(context.Background(), lastRetry.ID, "try again", true))
+		clock.now = clock.now.Add(time.Hour)
+	}
+
+	stale, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "stale"}, 0)
+	require.NoError(t, err)
+	claimed, err := jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+
+	clock.now = clock.now.Add(30 * time.Minute)
+	recent, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "recent"}, 0)
+	require.NoError(t, err)
+	claimed, err = jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	// Started 45 and 15 minutes ago
+	clock.now = clock.now.Add(15 * time.Minute)
+	reaped, err := jobQueue.ReapStaleJobs(context.Background(), 40*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reaped)
+
+	job, err := jobQueue.GetJob(context.Background(), stale.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", job.Status)
+	assert.Equal(t, int64(1), job.RetryCount.Int64)
+	assert.False(t, job.StartedAt.Valid)
+	assert.Contains(t, job.ErrorMessage.String, "still processing after 40m0s")
+
+	job, err = jobQueue.GetJob(context.Background(), lastRetry.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", job.Status, "no retries left")
+	assert.Equal(t, int64(3), job.RetryCount.Int64)
+	assert.True(t, job.CompletedAt.Valid)
+
+	job, err = jobQueue.GetJob(context.Background(), recent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "processing", job.Status)
+
+	// The reaped job is due again straight away
+	next, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, stale.ID, next.ID)
+
+	reaped, err = jobQueue.ReapStaleJobs(context.Background(), 40*time.Minute)
+	require.NoError(t, err)
+	assert.Zero(t, reaped)
+}
+
+func TestJobQueueService_WorkerHeartbeats(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	start := time.Date(2031, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	jobQueue.SetClock(clock)
+
+	require.NoError(t, jobQueue.Heartbeat(context.Background(), 1))
+	require.NoError(t, jobQueue.Heartbeat(context.Background(), 2))
+
+	dead, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "dead worker"}, 1)
+	require.NoError(t, err)
+	alive, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "live worker"}, 0)
+	require.NoError(t, err)
+	anonymous, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "no worker"}, 0)
+	require.NoError(t, err)
+
+	claimed, err := jobQueue.GetNextJobsForWorker(context.Background(), 1, 1)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, dead.ID, claimed[0].ID)
+	assert.Equal(t, int64(1), claimed[0].WorkerID.Int64)
+	claimed, err = jobQueue.GetNextJobsForWorker(context.Background(), 2, 1)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, alive.ID, claimed[0].ID)
+	claimed, err = jobQueue.GetNextJobs(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, anonymous.ID, claimed[0].ID)
+	assert.False(t, claimed[0].WorkerID.Valid)
+
+	// Worker 1 stops sending heartbeats
+	clock.now = start.Add(90 * time.Second)
+	require.NoError(t, jobQueue.Heartbeat(context.Background(), 2))
+
+	workers, err := jobQueue.ListLiveWorkers(context.Background(), jobs.WorkerLivenessTimeout)
+	require.NoError(t, err)
+	require.Len(t, workers, 1)
+	assert.Equal(t, int64(2), workers[0].WorkerID)
+
+	reaped, err := jobQueue.ReapJobsOfDeadWorkers(context.Background(), jobs.WorkerLivenessTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reaped)
+
+	job, err := jobQueue.GetJob(context.Background(), dead.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", job.Status)
+	assert.Equal(t, int64(1), job.RetryCount.Int64)
+	assert.Contains(t, job.ErrorMessage.String, "sent no heartbeat for 1m0s")
+
+	for _, id := range []int64{alive.ID, anonymous.ID} {
+		job, err := jobQueue.GetJob(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, "processing", job.Status)
+	}
+
+	// Reclaimed without a worker, the job no longer belongs to worker 1
+	next, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, dead.ID, next.ID)
+	reaped, err = jobQueue.ReapJobsOfDeadWorkers(context.Background(), jobs.WorkerLivenessTimeout)
+	require.NoError(t, err)
+	assert.Zero(t, reaped)
+}
+
+func TestJobQueueService_Subscribe(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	first := jobQueue.Subscribe()
+	second := jobQueue.Subscribe()
+
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "wake up"}, 0)
+	require.NoError(t, err)
+	_, err = jobQueue.EnqueueJobs(context.Background(), []jobs.JobSpec{{Type: jobs.JobDataAnalysis, Payload: jobs.JobPayload{Message: "batch"}}})
+	require.NoError(t, err)
+
+	// Both enqueues are coalesced into one notification per subscriber
+	for _, ch := range []<-chan struct{}{first, second} {
+		select {
+		case <-ch:
+		default:
+			t.Fatal("subscriber was not notified")
+		}
+		select {
+		case <-ch:
+			t.Fatal("notifications were not coalesced")
+		default:
+		}
+	}
+
+	// A rejected job notifies no one
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{}, 0)
+	require.Error(t, err)
+	select {
+	case <-first:
+		t.Fatal("notified of a job that was not enqueued")
+	default:
+	}
+}
+
+func TestJobQueueService_DedupKey(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	userID := int64(42)
+	payload := jobs.JobPayload{UserID: &userID, DedupKey: "user_created:42"}
+	first, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "user_created:42", first.DedupKey.String)
+
+	// A retry with the same key gets the job already queued
+	second, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	// Other keys and jobs without a key are not deduplicated
+	other, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, jobs.JobPayload{UserID: &userID, DedupKey: "user_created:43"}, 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID)
+	for i := 0; i < 2; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, jobs.JobPayload{UserID: &userID}, 0)
+		require.NoError(t, err)
+	}
+
+	// One transaction enqueueing the key twice stores it once, and a
+	// duplicate is returned even when the queue is full
+	created, err := jobQueue.EnqueueJobs(context.Background(), []jobs.JobSpec{
+		{Type: jobs.JobUserCreated, Payload: payload},
+		{Type: jobs.JobUserCreated, Payload: payload},
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 2)
+	assert.Equal(t, first.ID, created[0].ID)
+	assert.Equal(t, first.ID, created[1].ID)
+
+	jobQueue.SetMaxPendingJobs(1)
+	again, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	jobQueue.SetMaxPendingJobs(0)
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.PendingCount)
+
+	// Still a duplicate while processing
+	claimed, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	require.Equal(t, first.ID, claimed.ID)
+	again, err = jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	// Once handled, the key can be enqueued again
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), first.ID))
+	next, err := jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, payload, 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, next.ID)
+}
+
+func TestDatabaseService_AddsDedupKeyColumn(t *testing.T) {
+	testDBPath := "test_dedup_migration.db"
+	os.Remove(testDBPath)
+	t.Cleanup(func() { os.Remove(testDBPath) })
+
+	// A job_queue table from before dedup keys
+	legacy, err := sql.Open("sqlite", testDBPath)
+	require.NoError(t, err)
+	_, err = legacy.Exec(`CREATE TABLE job_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		priority INTEGER DEFAULT 0,
+		max_retries INTEGER DEFAULT 3,
+		retry_count INTEGER DEFAULT 0,
+		error_message TEXT,
+		scheduled_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		started_at DATETIME,
+		completed_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		duration_ms INTEGER,
+		request_id TEXT
+	)`)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	dbService, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	defer dbService.Close()
+
+	payload := jobs.JobPayload{Message: "migrated", DedupKey: "analysis"}
+	first, err := dbService.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, payload, 0)
+	require.NoError(t, err)
+	second, err := dbService.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+}
+
+func TestMemoryJobQueue(t *testing.T) {
+	jobQueue := jobs.NewMemoryJobQueue()
+	clock := &fakeClock{now: time.Date(2031, 3, 1, 12, 0, 0, 0, time.UTC)}
+	jobQueue.SetClock(clock)
+
+	low, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "low"}, 0)
+	require.NoError(t, err)
+	high, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "high"}, 5)
+	require.NoError(t, err)
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{}, 0)
+	require.Error(t, err, "payloads are validated")
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "urgent"}, jobs.MaxPriority+1)
+	require.ErrorIs(t, err, jobs.ErrPriorityOutOfRange)
+
+	// Claimed highest priority first
+	claimed, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, high.ID, claimed.ID)
+	assert.Equal(t, "processing", claimed.Status)
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), high.ID))
+
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, low.ID, claimed.ID)
+	require.NoError(t, jobQueue.FailJob(context.Background(), low.ID, "try again", true))
+
+	// The retry is not due for 5 minutes
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, claimed)
+	clock.now = clock.now.Add(5 * time.Minute)
+	claimed, err = jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, low.ID, claimed.ID)
+	assert.Equal(t, int64(1), claimed.RetryCount.Int64)
+	require.NoError(t, jobQueue.FailJob(context.Background(), low.ID, "gave up", false))
+
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, db.GetJobStatsRow{CompletedCount: 1, FailedCount: 1}, *stats)
+
+	failed, err := jobQueue.ListJobs(context.Background(), "failed", 10)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "gave up", failed[0].ErrorMessage.String)
+	all, err := jobQueue.ListJobs(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, high.ID, all[0].ID, "newest first")
+
+	assert.ErrorIs(t, jobQueue.CompleteJob(context.Background(), 999), jobs.ErrJobNotFound)
+}
+
+func TestDatabaseService_SetJobQueue(t *testing.T) {
+	dbService, _ := setupTestDatabase(t)
+	jobQueue := dbService.GetJobQueue()
+	assert.Equal(t, jobs.JobQueue(jobQueue), dbService.JobQueue())
+
+	memoryQueue := jobs.NewMemoryJobQueue()
+	dbService.SetJobQueue(memoryQueue)
+	assert.Equal(t, jobs.JobQueue(memoryQueue), dbService.JobQueue())
+	assert.Nil(t, dbService.GetJobQueue(), "the SQLite queue was replaced")
+
+	user, err := dbService.CreateUser(generated.UserRequest{Email: "memory@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	require.NoError(t, dbService.DeleteUser(user.Id))
+
+	queued, err := memoryQueue.ListJobs(context.Background(), "pending", 10)
+	require.NoError(t, err)
+	require.Len(t, queued, 2)
+	assert.Equal(t, string(jobs.JobUserDeleted), queued[0].JobType)
+	assert.Equal(t, string(jobs.JobUserCreated), queued[1].JobType)
+
+	// Nothing reached the SQLite queue
+	stats, err := jobQueue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, stats.PendingCount)
+}
@@ -2,16 +2,31 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"openapi-validation-example/generated"
 	"openapi-validation-example/internal/handlers"
+	"openapi-validation-example/internal/server"
+	"openapi-validation-example/pkg/auth"
+	"openapi-validation-example/pkg/response"
 	"openapi-validation-example/pkg/validation"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,12 +34,15 @@ import (
 // setupTestApp creates a test Echo app with in-memory InMemoryUserHandler
 func setupTestApp(t *testing.T) (*echo.Echo, *handlers.InMemoryUserHandler) {
 	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
 
 	// Setup validation middleware
-	validationMiddleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	validationMiddleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(t, err)
 	e.Use(validationMiddleware.Validate())
 
+	handlers.RegisterHealthRoutes(e)
+
 	// Create handler
 	userHandler := handlers.NewInMemoryUserHandler()
 
@@ -103,6 +121,22 @@ func TestInMemoryUserHandler_CreateUser(t *testing.T) {
 				assert.Contains(t, body, "error")
 			},
 		},
+		{
+			name:           "Invalid - age above maximum",
+			requestBody:    `{"email": "old@example.com", "age": 151}`,
+			expectedStatus: http.StatusBadRequest,
+			checkResponse: func(t *testing.T, body string) {
+				assert.Contains(t, body, "age must be between 0 and 150")
+			},
+		},
+		{
+			name:           "Invalid - age overflowing int",
+			requestBody:    `{"email": "overflow@example.com", "age": 99999999999999999999}`,
+			expectedStatus: http.StatusBadRequest,
+			checkResponse: func(t *testing.T, body string) {
+				assert.Contains(t, body, "error")
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -165,7 +199,7 @@ func TestInMemoryUserHandler_GetUserById(t *testing.T) {
 			userID:         "invalid",
 			expectedStatus: http.StatusBadRequest,
 			checkResponse: func(t *testing.T, body string) {
-				assert.Contains(t, body, "Invalid format for parameter id")
+				assert.Contains(t, body, "Parameter validation failed for 'id'")
 			},
 		},
 	}
@@ -272,4 +306,650 @@ func TestInMemoryUserHandler_Integration(t *testing.T) {
 			assert.Equal(t, user.email, string(retrievedUser.Email))
 		}
 	})
-}
\ No newline at end of file
+}
+func TestInMemoryUserHandler_DeleteUser(t *testing.T) {
+	e, userHandler := setupTestApp(t)
+
+	userHandler.Users[1] = generated.User{
+		Id:    1,
+		Email: "delete-test@example.com",
+		Age:   28,
+	}
+	userHandler.NextID = 2
+
+	tests := []struct {
+		name           string
+		userID         string
+		expectedStatus int
+	}{
+		{
+			name:           "Delete existing user",
+			userID:         "1",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Delete already deleted user",
+			userID:         "1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid user ID format",
+			userID:         "invalid",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/users/"+tt.userID, nil)
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+
+	assert.Empty(t, userHandler.Users)
+}
+
+func TestInMemoryServer_HealthEndpoints(t *testing.T) {
+	e, _ := setupTestApp(t)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+}
+
+func TestAdditionalProps_KnownFieldsFromUserRequest(t *testing.T) {
+	assert.Equal(t, map[string]bool{
+		"email":     true,
+		"age":       true,
+		"name":      true,
+		"bio":       true,
+		"is_active": true,
+	}, handlers.UserRequestFields())
+
+	body := map[string]interface{}{
+		"email":    "user@example.com",
+		"age":      float64(30),
+		"nickname": "neo",
+		"hobby":    "programming",
+	}
+	assert.Equal(t, map[string]interface{}{"nickname": "neo", "hobby": "programming"},
+		handlers.AdditionalProps(body, handlers.UserRequestFields()))
+
+	// Once the spec adds a field, it is no longer treated as additional
+	type extendedUserRequest struct {
+		generated.UserRequest
+		Nickname *string `json:"nickname,omitempty"`
+		internal string
+		Ignored  string `json:"-"`
+	}
+	known := handlers.JSONFieldNames(reflect.TypeOf(extendedUserRequest{}))
+	assert.True(t, known["nickname"])
+	assert.True(t, known["email"])
+	assert.False(t, known["internal"])
+	assert.False(t, known["Ignored"])
+	assert.Equal(t, map[string]interface{}{"hobby": "programming"}, handlers.AdditionalProps(body, known))
+}
+
+func TestExtractAdditionalProps(t *testing.T) {
+	raw := map[string]interface{}{
+		"email":     "user@example.com",
+		"age":       float64(30),
+		"name":      "Neo",
+		"is_active": false,
+		"hobby":     "programming",
+		"score":     float64(95),
+	}
+	req, additionalProps, err := handlers.ExtractAdditionalProps(raw)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", string(req.Email))
+	assert.Equal(t, 30, req.Age)
+	require.NotNil(t, req.Name)
+	assert.Equal(t, "Neo", *req.Name)
+	require.NotNil(t, req.IsActive)
+	assert.False(t, *req.IsActive)
+	assert.Nil(t, req.Bio)
+	assert.Equal(t, map[string]interface{}{"hobby": "programming", "score": float64(95)}, additionalProps)
+
+	// A known field of the wrong type can't be decoded
+	_, _, err = handlers.ExtractAdditionalProps(map[string]interface{}{"email": "user@example.com", "age": "thirty"})
+	assert.Error(t, err)
+}
+
+func TestInMemoryServer_JSONContentType(t *testing.T) {
+	e, _ := setupTestApp(t)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"created user", http.MethodPost, "/users", `{"email": "charset@example.com", "age": 30}`, http.StatusCreated},
+		{"user list", http.MethodGet, "/users", "", http.StatusOK},
+		{"handler error", http.MethodGet, "/users/999", "", http.StatusNotFound},
+		{"validation error", http.MethodPost, "/users", `{"email": "charset@example.com"}`, http.StatusBadRequest},
+		{"unknown route", http.MethodGet, "/nope", "", http.StatusNotFound},
+		{"health check", http.MethodGet, "/healthz", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.body != "" {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, response.ContentTypeJSON, rec.Header().Get(echo.HeaderContentType))
+			assert.Empty(t, rec.Header().Get(echo.HeaderServer))
+		})
+	}
+
+	response.ServerName = "users-api"
+	t.Cleanup(func() { response.ServerName = "" })
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, "users-api", rec.Header().Get(echo.HeaderServer))
+}
+
+func TestInMemoryServer_FormEncodedUser(t *testing.T) {
+	e, _ := setupTestApp(t)
+
+	// is_active is left out, so validation fills in its default and writes
+	// the form back
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("email=form%40example.com&age=27&bio=Hello+there"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var user generated.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+	assert.Equal(t, "form@example.com", string(user.Email))
+	assert.Equal(t, 27, user.Age)
+	require.NotNil(t, user.Bio)
+	assert.Equal(t, "Hello there", *user.Bio)
+	require.NotNil(t, user.IsActive)
+	assert.True(t, *user.IsActive)
+}
+
+func TestInMemoryServer_CreateUsersBatch(t *testing.T) {
+	e, userHandler := setupTestApp(t)
+
+	postBatch := func(query, body string) (int, generated.BatchUserResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/users/batch"+query, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var resp generated.BatchUserResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), rec.Body.String())
+		return rec.Code, resp
+	}
+
+	code, resp := postBatch("", fmt.Sprintf(`[{"email": "a@example.com", "age": 20}, {"email": "b@example.com", "age": %d}]`, handlers.MaxAge+1))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, resp.Created)
+	assert.Equal(t, 1, resp.Failed)
+	require.NotNil(t, resp.Results[0].User)
+	require.NotNil(t, resp.Results[1].Error)
+	assert.Len(t, userHandler.Users, 1)
+
+	code, resp = postBatch("?all_or_nothing=true", fmt.Sprintf(`[{"email": "c@example.com", "age": 20}, {"email": "d@example.com", "age": %d}]`, handlers.MaxAge+1))
+	require.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, 0, resp.Created)
+	assert.Equal(t, 2, resp.Failed)
+	assert.Len(t, userHandler.Users, 1)
+}
+
+func TestServerRun_GracefulShutdown(t *testing.T) {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	started := make(chan struct{})
+	e.GET("/slow", func(ctx echo.Context) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return response.JSON(ctx, http.StatusOK, map[string]string{"status": "done"})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Run(ctx, e, "127.0.0.1:0", 5*time.Second)
+	}()
+	require.Eventually(t, func() bool { return e.ListenerAddr() != nil }, time.Second, 10*time.Millisecond)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + e.ListenerAddr().String() + "/slow")
+		if err != nil {
+			resultCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resultCh <- result{status: resp.StatusCode, body: string(body), err: err}
+	}()
+
+	// Shut down while the request is in flight; it still completes
+	<-started
+	cancel()
+
+	res := <-resultCh
+	require.NoError(t, res.err)
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.Contains(t, res.body, "done")
+	require.NoError(t, <-runErr)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "")
+	timeout, err := server.ShutdownTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, server.DefaultShutdownTimeout, timeout)
+
+	t.Setenv("SHUTDOWN_TIMEOUT", "30s")
+	timeout, err = server.ShutdownTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	for _, value := range []string{"soon", "0s", "-1s"} {
+		t.Setenv("SHUTDOWN_TIMEOUT", value)
+		_, err = server.ShutdownTimeout()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestInMemoryUserHandler_Snapshot(t *testing.T) {
+	e, userHandler := setupTestApp(t)
+
+	for _, body := range []string{
+		`{"email": "first@example.com", "age": 30, "name": "First"}`,
+		`{"email": "second@example.com", "age": 40}`,
+		`{"email": "third@example.com", "age": 50}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/users/2", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	path := filepath.Join(t.TempDir(), "users.json")
+	require.NoError(t, handlers.WriteUserSnapshot(path, userHandler.Snapshot()))
+
+	snap, ok, err := handlers.ReadUserSnapshot(path)
+	require.NoError(t, err)
+	require.True(t, ok)
+	restored := handlers.NewInMemoryUserHandler()
+	restored.Restore(snap)
+
+	assert.Equal(t, userHandler.Users, restored.Users)
+	assert.Equal(t, int64(4), restored.NextID, "deleted IDs are not reused")
+	require.Contains(t, restored.Users, int64(1))
+	assert.Equal(t, "First", *restored.Users[1].Name)
+
+	// A missing snapshot means a fresh start
+	_, ok, err = handlers.ReadUserSnapshot(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	apiKeys := auth.NewKeySet([]string{"key-one", " key-two "})
+
+	// Same middleware order as the servers: authentication, then validation
+	// with the spec's ApiKeyAuth scheme
+	newApp := func(useMiddleware bool) *echo.Echo {
+		e := echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		if useMiddleware {
+			e.Use(apiKeys.Middleware(handlers.HealthzPath, handlers.ReadyzPath))
+		}
+		validationMiddleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+			AuthenticationFunc: apiKeys.AuthenticationFunc(),
+		})
+		require.NoError(t, err)
+		e.Use(validationMiddleware.Validate())
+		handlers.RegisterHealthRoutes(e)
+		e.GET("/admin/ping", func(ctx echo.Context) error {
+			return response.JSON(ctx, http.StatusOK, map[string]string{"status": "ok"})
+		})
+		generated.RegisterHandlers(e, handlers.NewInMemoryUserHandler())
+		return e
+	}
+
+	request := func(e *echo.Echo, method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		if key != "" {
+			req.Header.Set(auth.HeaderAPIKey, key)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, useMiddleware := range []bool{true, false} {
+		e := newApp(useMiddleware)
+		name := map[bool]string{true: "middleware", false: "spec security only"}[useMiddleware]
+
+		rec := request(e, http.MethodGet, "/users", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), "missing API key", name)
+
+		rec = request(e, http.MethodGet, "/users", "wrong", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), "invalid API key", name)
+
+		rec = request(e, http.MethodPost, "/users", "key-two", `{"email": "auth@example.com", "age": 30}`)
+		assert.Equal(t, http.StatusCreated, rec.Code, name)
+
+		rec = request(e, http.MethodGet, "/healthz", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+	}
+
+	// Routes outside the spec are only covered by the middleware
+	rec := request(newApp(true), http.MethodGet, "/admin/ping", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = request(newApp(true), http.MethodGet, "/admin/ping", "key-one", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	t.Setenv("API_KEYS", "")
+	t.Setenv("API_KEYS_FILE", "")
+	apiKeys, err := auth.LoadKeySet()
+	require.NoError(t, err)
+	assert.False(t, apiKeys.Enabled())
+
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("# deploy keys\nfile-key\n\nother-key # ci\n"), 0o600))
+	t.Setenv("API_KEYS", "env-key")
+	t.Setenv("API_KEYS_FILE", path)
+	apiKeys, err = auth.LoadKeySet()
+	require.NoError(t, err)
+	assert.True(t, apiKeys.Enabled())
+	for _, key := range []string{"env-key", "file-key", "other-key"} {
+		assert.NoError(t, apiKeys.Check(key), key)
+	}
+	assert.ErrorIs(t, apiKeys.Check("deploy keys"), auth.ErrInvalidAPIKey)
+	assert.ErrorIs(t, apiKeys.Check(""), auth.ErrMissingAPIKey)
+
+	t.Setenv("API_KEYS_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = auth.LoadKeySet()
+	assert.Error(t, err)
+}
+
+func TestCORS(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "")
+	t.Setenv("CORS_ALLOWED_HEADERS", "")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	corsConfig, ok, err := server.CORSConfig()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Same order as the servers: CORS before authentication and validation
+	apiKeys := auth.NewKeySet([]string{"secret"})
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(middleware.CORSWithConfig(corsConfig))
+	e.Use(apiKeys.Middleware())
+	validationMiddleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		AuthenticationFunc: apiKeys.AuthenticationFunc(),
+	})
+	require.NoError(t, err)
+	e.Use(validationMiddleware.Validate())
+	generated.RegisterHandlers(e, handlers.NewInMemoryUserHandler())
+
+	// A preflight needs no API key and never reaches validation
+	req := httptest.NewRequest(http.MethodOptions, "/users", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://app.example.com")
+	req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodPost)
+	req.Header.Set(echo.HeaderAccessControlRequestHeaders, "content-type,x-api-key")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	assert.Equal(t, "GET,POST,DELETE", rec.Header().Get(echo.HeaderAccessControlAllowMethods))
+	assert.Contains(t, rec.Header().Get(echo.HeaderAccessControlAllowHeaders), auth.HeaderAPIKey)
+	assert.Equal(t, "true", rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+
+	// The actual request from an allowed origin
+	req = httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://app.example.com")
+	req.Header.Set(auth.HeaderAPIKey, "secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+
+	// Other origins get no CORS headers, so the browser blocks the response
+	req = httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://evil.example.com")
+	req.Header.Set(auth.HeaderAPIKey, "secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+}
+
+func TestCORSConfig(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	_, ok, err := server.CORSConfig()
+	require.NoError(t, err)
+	assert.False(t, ok, "CORS is off by default")
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET")
+	t.Setenv("CORS_ALLOWED_HEADERS", "Content-Type")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "")
+	corsConfig, ok, err := server.CORSConfig()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, corsConfig.AllowOrigins)
+	assert.Equal(t, []string{"GET"}, corsConfig.AllowMethods)
+	assert.Equal(t, []string{"Content-Type"}, corsConfig.AllowHeaders)
+	assert.False(t, corsConfig.AllowCredentials)
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	_, _, err = server.CORSConfig()
+	assert.Error(t, err)
+}
+
+func TestGzipCompression(t *testing.T) {
+	t.Setenv("GZIP", "1")
+	t.Setenv("GZIP_MIN_LENGTH", "")
+	gzipConfig, ok, err := server.GzipConfig()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Same order as the servers: gzip outside validation, here with
+	// response validation on
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(middleware.GzipWithConfig(gzipConfig))
+	validationMiddleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		ValidateResponses:  true,
+	})
+	require.NoError(t, err)
+	e.Use(validationMiddleware.Validate())
+	generated.RegisterHandlers(e, handlers.NewInMemoryUserHandler())
+
+	for i := 0; i < 30; i++ {
+		body := fmt.Sprintf(`{"email": "gzip%d@example.com", "age": 30, "name": "Compressed User %d"}`, i, i)
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code, "response validation passed on the uncompressed body")
+		assert.Empty(t, rec.Header().Get(echo.HeaderContentEncoding), "client did not accept gzip")
+	}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users"+query, nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A large list is compressed, and was validated before compression
+	rec := list("?limit=30")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get(echo.HeaderContentEncoding))
+	reader, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	var userList generated.UserList
+	require.NoError(t, json.Unmarshal(decompressed, &userList))
+	assert.Len(t, userList.Users, 30)
+	assert.Equal(t, int64(30), userList.Total)
+
+	// A response under the minimum length is sent as is
+	rec = list("?limit=1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get(echo.HeaderContentEncoding))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &userList))
+	assert.Len(t, userList.Users, 1)
+
+	t.Setenv("GZIP_MIN_LENGTH", "-1")
+	_, _, err = server.GzipConfig()
+	assert.Error(t, err)
+	t.Setenv("GZIP", "")
+	_, ok, err = server.GzipConfig()
+	require.NoError(t, err)
+	assert.False(t, ok, "compression is off by default")
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(server.NewConcurrencyLimiter(2).Middleware(handlers.HealthzPath))
+	handlers.RegisterHealthRoutes(e)
+
+	// Slow requests stay in flight until release is closed
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	e.POST("/slow", func(c echo.Context) error {
+		started <- struct{}{}
+		<-release
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	send := func(ip, path string) *httptest.ResponseRecorder {
+		method := http.MethodPost
+		if path == handlers.HealthzPath {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":40000"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan int, 3)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- send("192.0.2.1", "/slow").Code
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("slow request did not start")
+		}
+	}
+
+	// The first client is at its limit
+	rec := send("192.0.2.1", "/slow")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get(echo.HeaderRetryAfter))
+	assert.Contains(t, rec.Body.String(), "Too many concurrent requests")
+
+	// Exempt paths and other clients are unaffected
+	assert.Equal(t, http.StatusOK, send("192.0.2.1", handlers.HealthzPath).Code)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results <- send("198.51.100.7", "/slow").Code
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second client's request did not start")
+	}
+
+	close(release)
+	wg.Wait()
+	close(results)
+	for code := range results {
+		assert.Equal(t, http.StatusNoContent, code)
+	}
+
+	// Finished requests free their slots
+	assert.Equal(t, http.StatusNoContent, send("192.0.2.1", "/slow").Code)
+
+	t.Setenv("MAX_CONCURRENT_PER_IP", "")
+	_, ok, err := server.ConcurrencyLimitFromEnv()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	t.Setenv("MAX_CONCURRENT_PER_IP", "4")
+	max, ok, err := server.ConcurrencyLimitFromEnv()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 4, max)
+	t.Setenv("MAX_CONCURRENT_PER_IP", "many")
+	_, _, err = server.ConcurrencyLimitFromEnv()
+	assert.Error(t, err)
+}
+
+func TestSpecPath(t *testing.T) {
+	t.Setenv("OPENAPI_SPEC", "")
+	assert.Equal(t, "openapi.yaml", server.SpecPath("openapi.yaml"))
+
+	t.Setenv("OPENAPI_SPEC", "openapi-strict.yaml")
+	specFile := server.SpecPath("openapi.yaml")
+	assert.Equal(t, "openapi-strict.yaml", specFile)
+	_, err := newUnauthenticatedMiddleware(specFile)
+	require.NoError(t, err)
+
+	// A missing spec fails at startup, naming the file
+	t.Setenv("OPENAPI_SPEC", filepath.Join(t.TempDir(), "missing.yaml"))
+	_, err = newUnauthenticatedMiddleware(server.SpecPath("openapi.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing.yaml")
+}
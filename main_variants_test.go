@@ -2,18 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"openapi-validation-example/generated"
 	"openapi-validation-example/internal/handlers"
+	"openapi-validation-example/internal/server"
 	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
 	"openapi-validation-example/pkg/validation"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	openapi_types "github.com/oapi-codegen/runtime/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +37,8 @@ import (
 // setupTestAppVariants creates a test Echo app with database UserHandler
 func setupTestAppVariants(t *testing.T, validationMode string) (*echo.Echo, *handlers.UserHandler, *database.DatabaseService) {
 	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(middleware.RequestID())
 
 	// Setup validation middleware
 	var specFile string
@@ -33,7 +51,10 @@ func setupTestAppVariants(t *testing.T, validationMode string) (*echo.Echo, *han
 		specFile = "openapi.yaml"
 	}
 
-	validationMiddleware, err := validation.NewValidationMiddleware(specFile)
+	validationMiddleware, err := validation.NewValidationMiddlewareWithOptions(specFile, validation.Options{
+		AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		ReportUnknownFields: validationMode == "strict",
+	})
 	require.NoError(t, err)
 	e.Use(validationMiddleware.Validate())
 
@@ -44,6 +65,12 @@ func setupTestAppVariants(t *testing.T, validationMode string) (*echo.Echo, *han
 	db, err := database.NewDatabaseService(testDBPath)
 	require.NoError(t, err)
 
+	handlers.RegisterHealthRoutes(e, handlers.DatabaseReadinessChecks(db)...)
+
+	handlers.RegisterAdminRoutes(e, db)
+
+	handlers.RegisterJobRoutes(e, db.GetJobQueue())
+
 	userHandler := handlers.NewUserHandler(db)
 
 	// Register routes
@@ -87,6 +114,13 @@ func TestDatabaseUserHandler_CreateUser(t *testing.T) {
 			expectedStatus: http.StatusInternalServerError,
 			expectError:    true,
 		},
+		{
+			validationMode: "default",
+			name:           "Invalid - age above maximum",
+			requestBody:    `{"email": "too-old@example.com", "age": 1000}`,
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -164,6 +198,19 @@ func TestDatabaseUserHandler_GetUser(t *testing.T) {
 				require.NoError(t, err)
 				assert.Equal(t, user.Id, retrievedUser.Id)
 				assert.Equal(t, user.Email, retrievedUser.Email)
+
+				// Timestamps are sent as RFC3339 strings
+				var body map[string]interface{}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				for _, field := range []string{"created_at", "updated_at"} {
+					value, ok := body[field].(string)
+					require.True(t, ok, "%s should be a string, got %v", field, body[field])
+					_, err := time.Parse(time.RFC3339, value)
+					assert.NoError(t, err, "%s should be RFC3339", field)
+				}
+				require.NotNil(t, retrievedUser.CreatedAt)
+				require.NotNil(t, retrievedUser.UpdatedAt)
+				assert.False(t, retrievedUser.UpdatedAt.Before(*retrievedUser.CreatedAt), "updated_at should not precede created_at")
 			}
 		})
 	}
@@ -250,7 +297,7 @@ func TestDatabaseUserHandler_JobEnqueuing(t *testing.T) {
 }
 
 func TestDatabaseUserHandler_UniqueEmailConstraint(t *testing.T) {
-	e, _, _ := setupTestAppVariants(t, "default")
+	e, _, dbService := setupTestAppVariants(t, "default")
 
 	// Create first user
 	reqBody := `{"email": "unique@example.com", "age": 25}`
@@ -267,6 +314,1294 @@ func TestDatabaseUserHandler_UniqueEmailConstraint(t *testing.T) {
 	rec2 := httptest.NewRecorder()
 
 	e.ServeHTTP(rec2, req2)
-	assert.Equal(t, http.StatusInternalServerError, rec2.Code)
-	assert.Contains(t, rec2.Body.String(), "UNIQUE constraint failed")
-}
\ No newline at end of file
+	assert.Equal(t, http.StatusConflict, rec2.Code)
+	var errResp map[string]string
+	require.NoError(t, json.Unmarshal(rec2.Body.Bytes(), &errResp))
+	assert.Equal(t, "A user with this email address already exists", errResp["error"])
+
+	// The failed create enqueued no job; only the first user's is queued
+	stats, err := dbService.GetJobQueue().GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.PendingCount)
+
+	_, err = dbService.CreateUser(generated.UserRequest{Email: "unique@example.com", Age: 30}, nil)
+	assert.ErrorIs(t, err, database.ErrEmailTaken)
+	_, _, err = dbService.CreateUserIdempotent("retry-key", generated.UserRequest{Email: "unique@example.com", Age: 30}, nil)
+	assert.ErrorIs(t, err, database.ErrEmailTaken)
+}
+func TestDatabaseUserHandler_DeleteUser(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	user, err := dbService.CreateUser(generated.UserRequest{
+		Email: "delete-me@example.com",
+		Age:   40,
+	}, nil)
+	require.NoError(t, err)
+
+	userPath := "/users/" + strconv.FormatInt(user.Id, 10)
+
+	// First delete removes the user
+	req := httptest.NewRequest(http.MethodDelete, userPath, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	// The user is gone
+	req = httptest.NewRequest(http.MethodGet, userPath, nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Deleting again reports not found rather than failing
+	req = httptest.NewRequest(http.MethodDelete, userPath, nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "User not found")
+
+	// Exactly one user_deleted job was enqueued for the user
+	pendingJobs, err := dbService.GetJobQueue().ListJobs(context.Background(), "pending", 10)
+	require.NoError(t, err)
+
+	var deletedJobs int
+	for _, job := range pendingJobs {
+		if job.JobType == string(jobs.JobUserDeleted) {
+			deletedJobs++
+			assert.Contains(t, job.Payload, `"user_id":`+strconv.FormatInt(user.Id, 10))
+		}
+	}
+	assert.Equal(t, 1, deletedJobs)
+}
+
+func TestDatabaseUserHandler_ListUsers(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	for i := 1; i <= 3; i++ {
+		_, err := dbService.CreateUser(generated.UserRequest{
+			Email: openapi_types.Email("list" + strconv.Itoa(i) + "@example.com"),
+			Age:   20 + i,
+		}, nil)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedEmails []string
+	}{
+		{
+			name:           "Default pagination",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedEmails: []string{"list1@example.com", "list2@example.com", "list3@example.com"},
+		},
+		{
+			name:           "Limit and offset",
+			query:          "?limit=1&offset=1",
+			expectedStatus: http.StatusOK,
+			expectedEmails: []string{"list2@example.com"},
+		},
+		{
+			name:           "Offset past the end",
+			query:          "?offset=10",
+			expectedStatus: http.StatusOK,
+			expectedEmails: []string{},
+		},
+		{
+			name:           "Limit above maximum",
+			query:          "?limit=1000",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Zero limit",
+			query:          "?limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative offset",
+			query:          "?offset=-1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Non-numeric limit",
+			query:          "?limit=abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			if tt.expectedStatus != http.StatusOK {
+				assert.Contains(t, rec.Body.String(), "Parameter validation failed")
+				return
+			}
+
+			var list generated.UserList
+			err := json.Unmarshal(rec.Body.Bytes(), &list)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), list.Total)
+
+			emails := []string{}
+			for _, user := range list.Users {
+				emails = append(emails, string(user.Email))
+			}
+			assert.Equal(t, tt.expectedEmails, emails)
+		})
+	}
+}
+
+func TestDatabaseUserHandler_GetUserAdditionalProps(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "flexible")
+
+	// Create a user with additional properties
+	created, err := dbService.CreateUser(generated.UserRequest{Email: "roundtrip@example.com", Age: 29}, map[string]interface{}{
+		"hobby": "climbing",
+		"score": 95,
+	})
+	require.NoError(t, err)
+	userPath := "/users/" + strconv.FormatInt(created.Id, 10)
+
+	// Additional properties come back merged into the user
+	req := httptest.NewRequest(http.MethodGet, userPath, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "roundtrip@example.com", body["email"])
+	assert.Equal(t, "climbing", body["hobby"])
+	assert.Equal(t, float64(95), body["score"])
+
+	// A user without additional properties (NULL additional_data) is unchanged
+	plain, err := dbService.CreateUser(generated.UserRequest{Email: "plain@example.com", Age: 31}, nil)
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodGet, "/users/"+strconv.FormatInt(plain.Id, 10), nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body = nil
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.ElementsMatch(t, []string{"id", "email", "age", "is_active", "created_at", "updated_at"}, mapKeys(body))
+
+	// Invalid stored JSON is ignored rather than failing the request
+	rawDB, err := sql.Open("sqlite", "test_users_flexible.db")
+	require.NoError(t, err)
+	defer rawDB.Close()
+	_, err = rawDB.Exec("UPDATE users SET additional_data = ? WHERE id = ?", "{not json", created.Id)
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodGet, userPath, nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body = nil
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "roundtrip@example.com", body["email"])
+	assert.NotContains(t, body, "hobby")
+}
+
+func mapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestDatabaseService_PostCreateHook(t *testing.T) {
+	tests := []struct {
+		name string
+		mode database.HookMode
+	}{
+		{name: "After commit", mode: database.HookAfterCommit},
+		{name: "In transaction", mode: database.HookInTransaction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, dbService := setupTestAppVariants(t, "default")
+			jobQueue := dbService.GetJobQueue()
+
+			dbService.SetPostCreateHook(func(ctx context.Context, user *generated.User, additionalProps map[string]interface{}) error {
+				payload := jobs.JobPayload{
+					UserID:     &user.Id,
+					Message:    "Welcome!",
+					Recipients: []string{string(user.Email)},
+				}
+				if tx, ok := database.TxFromContext(ctx); ok {
+					_, err := jobQueue.EnqueueJobTx(context.Background(), tx, jobs.JobEmailNotification, payload, 0)
+					return err
+				}
+				_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, payload, 0)
+				return err
+			}, tt.mode)
+
+			_, err := dbService.CreateUser(generated.UserRequest{Email: "hook@example.com", Age: 27}, nil)
+			require.NoError(t, err)
+
+			pendingJobs, err := jobQueue.ListJobs(context.Background(), "pending", 10)
+			require.NoError(t, err)
+
+			var jobTypes []string
+			for _, job := range pendingJobs {
+				jobTypes = append(jobTypes, job.JobType)
+			}
+			assert.ElementsMatch(t, []string{string(jobs.JobUserCreated), string(jobs.JobEmailNotification)}, jobTypes)
+		})
+	}
+
+	t.Run("In transaction hook error rolls back the user", func(t *testing.T) {
+		_, _, dbService := setupTestAppVariants(t, "default")
+
+		dbService.SetPostCreateHook(func(ctx context.Context, user *generated.User, additionalProps map[string]interface{}) error {
+			return errors.New("hook failed")
+		}, database.HookInTransaction)
+
+		_, err := dbService.CreateUser(generated.UserRequest{Email: "rollback@example.com", Age: 27}, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "hook failed")
+
+		users, total, err := dbService.ListUsers(10, 0)
+		require.NoError(t, err)
+		assert.Empty(t, users)
+		assert.Zero(t, total)
+
+		pendingJobs, err := dbService.GetJobQueue().ListJobs(context.Background(), "pending", 10)
+		require.NoError(t, err)
+		assert.Empty(t, pendingJobs)
+	})
+}
+
+func TestDatabaseService_UpdateUserTimestamps(t *testing.T) {
+	_, _, dbService := setupTestAppVariants(t, "default")
+
+	created, err := dbService.CreateUser(generated.UserRequest{Email: "before@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	require.NotNil(t, created.CreatedAt)
+	require.NotNil(t, created.UpdatedAt)
+
+	// CURRENT_TIMESTAMP has one second resolution
+	time.Sleep(1100 * time.Millisecond)
+
+	updated, err := dbService.UpdateUser(created.Id, generated.UserRequest{Email: "after@example.com", Age: 31}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "after@example.com", string(updated.Email))
+
+	fetched, err := dbService.GetUserByID(created.Id)
+	require.NoError(t, err)
+	require.NotNil(t, fetched.CreatedAt)
+	require.NotNil(t, fetched.UpdatedAt)
+	assert.True(t, created.CreatedAt.Equal(*fetched.CreatedAt), "created_at should not change")
+	assert.True(t, fetched.UpdatedAt.After(*created.UpdatedAt), "updated_at should move forward")
+
+	// Updating a missing user reports not found
+	_, err = dbService.UpdateUser(999, generated.UserRequest{Email: "missing@example.com", Age: 20}, nil)
+	assert.ErrorIs(t, err, database.ErrUserNotFound)
+}
+
+func TestDatabaseService_PingAndReconnect(t *testing.T) {
+	dbPath := "test_users_ping.db"
+	os.Remove(dbPath)
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	dbService, err := database.NewDatabaseService(dbPath)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, dbService.Ping(ctx))
+
+	_, err = dbService.CreateUser(generated.UserRequest{Email: "ping@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+
+	// Removing the file leaves open connections working, but Ping reports it
+	// by the path SQLite opened
+	absPath, err := filepath.Abs(dbPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(dbPath))
+	err = dbService.Ping(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "database file "+absPath+" is not accessible")
+
+	// Reconnecting recreates the file with a fresh schema
+	require.NoError(t, dbService.Reconnect(ctx))
+	require.NoError(t, dbService.Ping(ctx))
+	_, err = os.Stat(dbPath)
+	require.NoError(t, err)
+
+	user, err := dbService.CreateUser(generated.UserRequest{Email: "ping@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), user.Id)
+
+	_, err = dbService.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "after reconnect"}, 0)
+	require.NoError(t, err)
+
+	// A closed service surfaces a clear error from both Ping and Reconnect
+	require.NoError(t, dbService.Close())
+	err = dbService.Ping(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to ping database")
+	err = dbService.Reconnect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to reconnect to database")
+}
+
+func TestDatabaseService_PingNonFileDSN(t *testing.T) {
+	ctx := context.Background()
+
+	// An in-memory database has no file for Ping to check
+	memService, err := database.NewDatabaseService(":memory:")
+	require.NoError(t, err)
+	defer memService.Close()
+	assert.NoError(t, memService.Ping(ctx))
+
+	// A file: URI is resolved to the file it names
+	dbPath := filepath.Join(t.TempDir(), "ping.db")
+	uriService, err := database.NewDatabaseService("file:" + dbPath + "?_pragma=foreign_keys(1)")
+	require.NoError(t, err)
+	defer uriService.Close()
+	require.NoError(t, uriService.Ping(ctx))
+
+	require.NoError(t, os.Remove(dbPath))
+	err = uriService.Ping(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not accessible")
+}
+
+func TestDatabaseServer_HealthEndpoints(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	get := func(path string) (int, map[string]string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	// Probes are not in the spec and must not be rejected by validation
+	code, body := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", body["status"])
+
+	// An unreachable job queue table makes the server not ready
+	rawDB, err := sql.Open("sqlite", "test_users_default.db")
+	require.NoError(t, err)
+	_, err = rawDB.Exec("DROP TABLE job_queue")
+	require.NoError(t, err)
+	rawDB.Close()
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body["error"], "job queue not ready")
+
+	// So does a failed database ping, while liveness is unaffected
+	require.NoError(t, dbService.Close())
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body["error"], "database not ready")
+
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestReadyz_QueueBacklog(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	jobQueue.SetClock(clock)
+
+	e := echo.New()
+	handlers.RegisterHealthRoutes(e, handlers.QueueBacklogCheck(jobQueue, handlers.QueueThresholds{
+		MaxPending:    3,
+		MaxPendingAge: 5 * time.Minute,
+	}))
+	readyz := func() (int, map[string]string) {
+		req := httptest.NewRequest(http.MethodGet, handlers.ReadyzPath, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	// A healthy queue: few jobs, none waiting long
+	for i := 0; i < 3; i++ {
+		_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "work"}, 0)
+		require.NoError(t, err)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	code, body := readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", body["status"])
+
+	// Too many pending jobs
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "one too many"}, 0)
+	require.NoError(t, err)
+	code, body = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "job queue backlog not ready: 4 pending jobs exceed the limit of 3", body["error"])
+
+	// Few jobs, but the oldest has waited too long
+	claimed, err := jobQueue.GetNextJobs(context.Background(), 2)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	clock.now = clock.now.Add(10 * time.Minute)
+	code, body = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "job queue backlog not ready: oldest pending job has waited 11m0s, over the limit of 5m0s", body["error"])
+
+	// A paused queue is expected to back up
+	require.NoError(t, jobQueue.PauseQueue(context.Background()))
+	code, _ = readyz()
+	assert.Equal(t, http.StatusOK, code)
+	require.NoError(t, jobQueue.ResumeQueue(context.Background()))
+
+	// Once the backlog is worked off the instance is ready again
+	claimed, err = jobQueue.GetNextJobs(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 2)
+	code, _ = readyz()
+	assert.Equal(t, http.StatusOK, code)
+
+	t.Setenv("READY_MAX_PENDING_JOBS", "100")
+	t.Setenv("READY_MAX_PENDING_AGE", "2m")
+	thresholds, err := handlers.QueueThresholdsFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, handlers.QueueThresholds{MaxPending: 100, MaxPendingAge: 2 * time.Minute}, thresholds)
+	t.Setenv("READY_MAX_PENDING_AGE", "soon")
+	_, err = handlers.QueueThresholdsFromEnv()
+	assert.Error(t, err)
+	t.Setenv("READY_MAX_PENDING_JOBS", "")
+	t.Setenv("READY_MAX_PENDING_AGE", "")
+	thresholds, err = handlers.QueueThresholdsFromEnv()
+	require.NoError(t, err)
+	assert.False(t, thresholds.Enabled())
+}
+
+func TestDatabaseServer_JobStatsEndpoint(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	// One user_created job from the API plus two enqueued directly
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "stats@example.com", "age": 30}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	jobQueue := dbService.GetJobQueue()
+	completed, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "done"}, 0)
+	require.NoError(t, err)
+	failed, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "broken"}, 0)
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), completed.ID))
+	require.NoError(t, jobQueue.FailJob(context.Background(), failed.ID, "boom", false))
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/jobs/stats", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var stats handlers.JobStatsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, handlers.JobStatsResponse{
+		Pending:   1,
+		Completed: 1,
+		Failed:    1,
+		Total:     3,
+	}, stats)
+}
+
+func TestDatabaseServer_UserStatsEndpoint(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	get := func() handlers.UserStatsResponse {
+		req := httptest.NewRequest(http.MethodGet, "/admin/user-stats", nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var stats handlers.UserStatsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+		return stats
+	}
+	assert.Equal(t, handlers.UserStatsResponse{}, get())
+
+	// is_active defaults to true
+	for _, body := range []string{
+		`{"email": "active1@example.com", "age": 30}`,
+		`{"email": "active2@example.com", "age": 31, "is_active": true}`,
+		`{"email": "inactive1@example.com", "age": 32, "is_active": false}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+	inactive := false
+	_, err := dbService.CreateUser(generated.UserRequest{Email: "inactive2@example.com", Age: 33, IsActive: &inactive}, nil)
+	require.NoError(t, err)
+
+	active, inactiveCount, err := dbService.CountUsersByActive()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), active)
+	assert.Equal(t, int64(2), inactiveCount)
+	assert.Equal(t, handlers.UserStatsResponse{Active: 2, Inactive: 2, Total: 4}, get())
+}
+
+func TestDatabaseService_GetJobsForUser(t *testing.T) {
+	_, _, dbService := setupTestAppVariants(t, "default")
+	jobQueue := dbService.GetJobQueue()
+
+	first, err := dbService.CreateUser(generated.UserRequest{Email: "first@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	second, err := dbService.CreateUser(generated.UserRequest{Email: "second@example.com", Age: 31}, nil)
+	require.NoError(t, err)
+
+	userJobs, err := jobQueue.GetJobsForUser(context.Background(), first.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 1)
+	assert.Equal(t, string(jobs.JobUserCreated), userJobs[0].JobType)
+
+	// Deleting the user adds its cleanup job, listed after the signup job
+	require.NoError(t, dbService.DeleteUser(first.Id))
+	userJobs, err = jobQueue.GetJobsForUser(context.Background(), first.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 2)
+	assert.Equal(t, string(jobs.JobUserCreated), userJobs[0].JobType)
+	assert.Equal(t, string(jobs.JobUserDeleted), userJobs[1].JobType)
+
+	// Other users' jobs are not included
+	userJobs, err = jobQueue.GetJobsForUser(context.Background(), second.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 1)
+
+	userJobs, err = jobQueue.GetJobsForUser(context.Background(), 999)
+	require.NoError(t, err)
+	assert.Empty(t, userJobs)
+}
+
+func TestDatabaseService_CreateUserEnqueuesAtomically(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	user, err := dbService.CreateUser(generated.UserRequest{Email: "atomic@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	userJobs, err := dbService.GetJobQueue().GetJobsForUser(context.Background(), user.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 1)
+
+	// Make the enqueue after the user insert fail
+	rawDB, err := sql.Open("sqlite", "test_users_default.db")
+	require.NoError(t, err)
+	_, err = rawDB.Exec("DROP TABLE job_queue")
+	require.NoError(t, err)
+	rawDB.Close()
+
+	_, err = dbService.CreateUser(generated.UserRequest{Email: "orphan@example.com", Age: 30}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to enqueue user created job")
+
+	// The user insert was rolled back with the job
+	_, total, err := dbService.ListUsers(10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "orphan@example.com", "age": 30}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	_, total, err = dbService.ListUsers(10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestServerVariants_RouteRegistration(t *testing.T) {
+	var e *echo.Echo
+	require.NotPanics(t, func() {
+		e, _, _ = setupTestAppVariants(t, "default")
+	})
+
+	routes := map[string]string{}
+	for _, route := range e.Routes() {
+		key := route.Method + " " + route.Path
+		_, duplicate := routes[key]
+		assert.False(t, duplicate, "route %s registered twice", key)
+		routes[key] = route.Name
+	}
+
+	// Spec routes come only from generated.RegisterHandlers, never from a
+	// manual registration overriding them
+	for _, key := range []string{"GET /users", "POST /users", "GET /users/:id", "DELETE /users/:id"} {
+		assert.Contains(t, routes[key], "generated.(*ServerInterfaceWrapper)", key)
+	}
+
+	// Both verbs on each path reach their handler
+	requests := []struct {
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{http.MethodPost, "/users", `{"email": "routes@example.com", "age": 30}`, http.StatusCreated},
+		{http.MethodGet, "/users", "", http.StatusOK},
+		{http.MethodGet, "/users/1", "", http.StatusOK},
+		{http.MethodDelete, "/users/1", "", http.StatusNoContent},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBufferString(r.body))
+		if r.body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, r.expectedStatus, rec.Code, "%s %s", r.method, r.path)
+	}
+}
+
+func TestDatabaseUserHandler_IdempotencyKey(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	createUser := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set(handlers.IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := createUser("key-1", `{"email": "idem@example.com", "age": 30}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created generated.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	// A retry with the same key returns the original user
+	rec = createUser("key-1", `{"email": "idem@example.com", "age": 30}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var retried generated.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &retried))
+	assert.Equal(t, created.Id, retried.Id)
+	assert.Equal(t, created.Email, retried.Email)
+
+	// A different key creates a new user
+	rec = createUser("key-2", `{"email": "other@example.com", "age": 30}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = createUser(strings.Repeat("k", handlers.MaxIdempotencyKeyLength+1), `{"email": "long@example.com", "age": 30}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	_, total, err := dbService.ListUsers(10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+
+	// Only the user_created jobs of the two new users were enqueued
+	userJobs, err := dbService.GetJobQueue().GetJobsForUser(context.Background(), created.Id)
+	require.NoError(t, err)
+	assert.Len(t, userJobs, 1)
+
+	// An expired key is forgotten
+	dbService.SetIdempotencyKeyTTL(-time.Second)
+	_, _, err = dbService.CreateUserIdempotent("key-3", generated.UserRequest{Email: "expired@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	_, created3, err := dbService.CreateUserIdempotent("key-3", generated.UserRequest{Email: "expired-retry@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	assert.True(t, created3)
+}
+
+func TestDatabaseService_CreateUserIdempotentConcurrent(t *testing.T) {
+	_, _, dbService := setupTestAppVariants(t, "default")
+
+	// A second service on the same file stands in for another server process
+	other, err := database.NewDatabaseService("test_users_default.db")
+	require.NoError(t, err)
+	defer other.Close()
+	services := []*database.DatabaseService{dbService, other}
+
+	const requests = 10
+	var wg sync.WaitGroup
+	ids := make(chan int64, requests)
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Distinct emails, so a duplicate create would not be caught by
+			// the unique email constraint
+			user, _, err := services[i%2].CreateUserIdempotent("same-key", generated.UserRequest{
+				Email: openapi_types.Email(fmt.Sprintf("concurrent%d@example.com", i)),
+				Age:   30,
+			}, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids <- user.Id
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("CreateUserIdempotent failed: %v", err)
+	}
+
+	var firstID int64
+	for id := range ids {
+		if firstID == 0 {
+			firstID = id
+		}
+		assert.Equal(t, firstID, id)
+	}
+
+	_, total, err := dbService.ListUsers(10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestDatabaseUserHandler_ContentNegotiation(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	request := func(method, path, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if accept != "" {
+			req.Header.Set(echo.HeaderAccept, accept)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodPost, "/users", echo.MIMEApplicationXML, `{"email": "xml@example.com", "age": 30, "name": "XML User"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), echo.MIMEApplicationXML)
+	var created handlers.UserXML
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "user", created.XMLName.Local)
+	assert.Equal(t, "xml@example.com", created.Email)
+	require.NotNil(t, created.Name)
+	assert.Equal(t, "XML User", *created.Name)
+
+	getPath := "/users/" + strconv.FormatInt(created.Id, 10)
+	rec = request(http.MethodGet, getPath, echo.MIMEApplicationXML, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var fetched handlers.UserXML
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &fetched))
+	assert.Equal(t, created.Id, fetched.Id)
+
+	// JSON remains the default and wins ties
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", echo.MIMEApplicationJSON},
+		{"*/*", echo.MIMEApplicationJSON},
+		{"application/*", echo.MIMEApplicationJSON},
+		{"application/json, application/xml", echo.MIMEApplicationJSON},
+		{"application/json;q=0.5, application/xml", echo.MIMEApplicationXML},
+		{"text/html, application/xml;q=0.9", echo.MIMEApplicationXML},
+		{"*/*;q=0.1, application/json;q=0", echo.MIMEApplicationXML},
+	}
+	for _, tt := range tests {
+		rec = request(http.MethodGet, getPath, tt.accept, "")
+		require.Equal(t, http.StatusOK, rec.Code, tt.accept)
+		assert.Contains(t, rec.Header().Get(echo.HeaderContentType), tt.contentType, tt.accept)
+	}
+
+	// Types the spec does not declare are refused before anything is created
+	rec = request(http.MethodPost, "/users", "text/csv", `{"email": "csv@example.com", "age": 30}`)
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+	var errResp generated.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errResp))
+	assert.Contains(t, errResp.Error, "application/json, application/xml")
+
+	rec = request(http.MethodGet, getPath, "application/json;q=0, application/xml;q=0", "")
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+
+	_, total, err := dbService.ListUsers(10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestDatabaseUserHandler_RequestIDPropagation(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+	jobQueue := dbService.GetJobQueue()
+
+	send := func(method, path, requestID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if requestID != "" {
+			req.Header.Set(echo.HeaderXRequestID, requestID)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A client-supplied ID is echoed and recorded on the job
+	rec := send(http.MethodPost, "/users", "req-create-1", `{"email": "traced@example.com", "age": 30}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "req-create-1", rec.Header().Get(echo.HeaderXRequestID))
+	var user generated.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+
+	rec = send(http.MethodDelete, "/users/"+strconv.FormatInt(user.Id, 10), "req-delete-1", "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	userJobs, err := jobQueue.GetJobsForUser(context.Background(), user.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 2)
+	for i, requestID := range []string{"req-create-1", "req-delete-1"} {
+		assert.Equal(t, requestID, userJobs[i].RequestID.String)
+
+		var payload jobs.JobPayload
+		require.NoError(t, json.Unmarshal([]byte(userJobs[i].Payload), &payload))
+		assert.Equal(t, requestID, payload.RequestID)
+	}
+
+	// Without one the middleware generates an ID, which the job records too
+	rec = send(http.MethodPost, "/users", "", `{"email": "generated@example.com", "age": 30}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	generatedID := rec.Header().Get(echo.HeaderXRequestID)
+	require.NotEmpty(t, generatedID)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+
+	userJobs, err = jobQueue.GetJobsForUser(context.Background(), user.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 1)
+	assert.Equal(t, generatedID, userJobs[0].RequestID.String)
+
+	// Jobs enqueued outside a request have no request ID
+	direct, err := dbService.CreateUser(generated.UserRequest{Email: "direct@example.com", Age: 30}, nil)
+	require.NoError(t, err)
+	userJobs, err = jobQueue.GetJobsForUser(context.Background(), direct.Id)
+	require.NoError(t, err)
+	require.Len(t, userJobs, 1)
+	assert.False(t, userJobs[0].RequestID.Valid)
+}
+
+func TestDatabaseUserHandler_QueueFull(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+	dbService.GetJobQueue().SetMaxPendingJobs(1)
+
+	createUser := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "`+email+`", "age": 30}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, createUser("first@example.com").Code)
+
+	// The user_created job doesn't fit, so the user isn't created either
+	rec := createUser("second@example.com")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "Job queue is full")
+
+	_, total, err := dbService.ListUsers(10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestDatabaseUserHandler_NotFoundErrors(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	// The database layer wraps ErrUserNotFound with the user ID
+	_, _, err := dbService.GetUserByIDWithAdditionalProps(42)
+	require.ErrorIs(t, err, database.ErrUserNotFound)
+	assert.NotEqual(t, database.ErrUserNotFound.Error(), err.Error())
+
+	request := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/users/42", nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := request(method)
+		assert.Equal(t, http.StatusNotFound, rec.Code, method)
+		assert.Contains(t, rec.Body.String(), "User not found", method)
+	}
+
+	// Other database errors are not reported as a missing user
+	require.NoError(t, dbService.Close())
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := request(method)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code, method)
+	}
+}
+
+func TestDatabaseServer_JobStatusesEndpoint(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+	jobQueue := dbService.GetJobQueue()
+
+	pending, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "waiting"}, 0)
+	require.NoError(t, err)
+	completed, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "done"}, 0)
+	require.NoError(t, err)
+	failed, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "broken"}, 0)
+	require.NoError(t, err)
+	cancelled, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "mistake"}, 0)
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.CompleteJob(context.Background(), completed.ID))
+	require.NoError(t, jobQueue.FailJob(context.Background(), failed.ID, "boom", false))
+	require.NoError(t, jobQueue.CancelJob(context.Background(), cancelled.ID))
+
+	postStatus := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/jobs/status", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := fmt.Sprintf(`{"ids": [%d, %d, %d, %d, 999]}`, failed.ID, pending.ID, cancelled.ID, completed.ID)
+	rec := postStatus(body)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var statuses []handlers.JobStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+	boom := "boom"
+	assert.Equal(t, []handlers.JobStatus{
+		{ID: pending.ID, Status: "pending"},
+		{ID: completed.ID, Status: "completed"},
+		{ID: failed.ID, Status: "failed", Error: &boom},
+		{ID: cancelled.ID, Status: "cancelled"},
+	}, statuses)
+
+	// Invalid requests
+	assert.Equal(t, http.StatusBadRequest, postStatus(`{"ids": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postStatus(`{"ids": "1,2"}`).Code)
+	ids := make([]string, handlers.MaxJobStatusIDs+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	assert.Equal(t, http.StatusBadRequest, postStatus(`{"ids": [`+strings.Join(ids, ",")+`]}`).Code)
+}
+
+func TestDatabaseUserHandler_BodyLimit(t *testing.T) {
+	t.Setenv("MAX_BODY_SIZE", "1K")
+	bodyLimit, err := server.BodyLimit()
+	require.NoError(t, err)
+
+	// Pre middleware runs ahead of validation, as in the servers
+	e, _, _ := setupTestAppVariants(t, "flexible")
+	e.Pre(middleware.BodyLimit(bodyLimit))
+
+	post := func(body string, knownLength bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if !knownLength {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(`{"email": "small@example.com", "age": 30}`, true)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	// An oversized body is rejected on its Content-Length, or while it is
+	// read when the length isn't known up front
+	oversized := fmt.Sprintf(`{"email": "big@example.com", "age": 30, "padding": %q}`, strings.Repeat("x", 2048))
+	for _, knownLength := range []bool{true, false} {
+		rec = post(oversized, knownLength)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "known length: %v", knownLength)
+		var errResp generated.ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errResp))
+		assert.Equal(t, "Request Entity Too Large", errResp.Error)
+	}
+
+	t.Setenv("MAX_BODY_SIZE", "lots")
+	_, err = server.BodyLimit()
+	assert.Error(t, err)
+	t.Setenv("MAX_BODY_SIZE", "")
+	bodyLimit, err = server.BodyLimit()
+	require.NoError(t, err)
+	assert.Equal(t, server.DefaultBodyLimit, bodyLimit)
+}
+
+func TestDatabaseUserHandler_StrictUnknownFields(t *testing.T) {
+	e, _, _ := setupTestAppVariants(t, "strict")
+
+	body := `{"email": "strict@example.com", "age": 25, "location": "Tokyo", "hobby": "reading"}`
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	var errResp map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errResp))
+	assert.Equal(t, "unexpected fields: [hobby, location]", errResp["error"])
+
+	// Other validation errors keep their 400
+	req = httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "strict@example.com", "age": -1}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Default mode still reports only the first unknown field, with 400
+	e, _, _ = setupTestAppVariants(t, "default")
+	req = httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDatabaseUserHandler_CreateUserPersistsAdditionalProps(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "flexible")
+
+	// CreateUser reads the body once, into a map, and takes both the defined
+	// fields and the additional properties from it
+	reqBody := `{"email": "persist@example.com", "age": 41, "name": "Persisted", "hobby": "chess", "location": "Osaka", "tags": ["a", "b"]}`
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var created generated.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	user, additionalProps, err := dbService.GetUserByIDWithAdditionalProps(created.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "persist@example.com", string(user.Email))
+	assert.Equal(t, 41, user.Age)
+	require.NotNil(t, user.Name)
+	assert.Equal(t, "Persisted", *user.Name)
+	assert.Equal(t, map[string]interface{}{
+		"hobby":    "chess",
+		"location": "Osaka",
+		"tags":     []interface{}{"a", "b"},
+	}, additionalProps)
+}
+
+func TestDatabaseUserHandler_CreateUserFormEncoded(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "flexible")
+
+	form := url.Values{}
+	form.Set("email", "form@example.com")
+	form.Set("age", "33")
+	form.Set("name", "Form User")
+	form.Set("is_active", "false")
+	form.Set("location", "Kyoto")
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created generated.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	user, additionalProps, err := dbService.GetUserByIDWithAdditionalProps(created.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "form@example.com", string(user.Email))
+	assert.Equal(t, 33, user.Age)
+	require.NotNil(t, user.Name)
+	assert.Equal(t, "Form User", *user.Name)
+	require.NotNil(t, user.IsActive)
+	assert.False(t, *user.IsActive)
+	assert.Equal(t, map[string]interface{}{"location": "Kyoto"}, additionalProps)
+
+	// Without is_active, validation fills in its default and writes the
+	// form back, keeping the additional properties
+	form.Del("is_active")
+	form.Set("email", "form-default@example.com")
+	req = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	user, additionalProps, err = dbService.GetUserByIDWithAdditionalProps(created.Id)
+	require.NoError(t, err)
+	require.NotNil(t, user.IsActive)
+	assert.True(t, *user.IsActive)
+	assert.Equal(t, map[string]interface{}{"location": "Kyoto"}, additionalProps)
+
+	// Form fields are validated like JSON ones
+	form.Set("age", "-5")
+	req = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Media types the spec doesn't declare get 415
+	req = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("email=form@example.com"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMETextPlain)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	assert.Contains(t, rec.Body.String(), "application/json or application/x-www-form-urlencoded")
+
+	// Strict mode names every undefined form field, as it does for JSON
+	strict, _, _ := setupTestAppVariants(t, "strict")
+	req = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("email=strict%40example.com&age=30&location=Kyoto&hobby=go"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec = httptest.NewRecorder()
+	strict.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"error": "unexpected fields: [hobby, location]"}`, rec.Body.String())
+}
+
+func TestDatabaseUserHandler_CreateUsersBatch(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "flexible")
+
+	_, err := dbService.CreateUser(generated.UserRequest{Email: "taken@example.com", Age: 40}, nil)
+	require.NoError(t, err)
+
+	postBatch := func(query, body string) (*httptest.ResponseRecorder, generated.BatchUserResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/users/batch"+query, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var resp generated.BatchUserResponse
+		if rec.Code == http.StatusOK || rec.Code == http.StatusConflict {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		}
+		return rec, resp
+	}
+	pendingJobs := func() int64 {
+		stats, err := dbService.GetJobQueue().GetJobStats(context.Background())
+		require.NoError(t, err)
+		return stats.PendingCount
+	}
+	userCount := func() int64 {
+		_, total, err := dbService.ListUsers(1, 0)
+		require.NoError(t, err)
+		return total
+	}
+
+	// Failures are reported per user and don't stop the others
+	rec, resp := postBatch("", fmt.Sprintf(`[
+		{"email": "first@example.com", "age": 21, "team": "red"},
+		{"email": "TAKEN@example.com", "age": 22},
+		{"email": "second@example.com", "age": 23},
+		{"email": "first@example.com", "age": 24},
+		{"email": "old@example.com", "age": %d}
+	]`, handlers.MaxAge+1))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, 2, resp.Created)
+	assert.Equal(t, 3, resp.Failed)
+	require.Len(t, resp.Results, 5)
+	for i, result := range resp.Results {
+		assert.Equal(t, i, result.Index)
+	}
+	require.NotNil(t, resp.Results[0].User)
+	assert.Equal(t, "first@example.com", string(resp.Results[0].User.Email))
+	require.NotNil(t, resp.Results[2].User)
+	assert.Equal(t, "second@example.com", string(resp.Results[2].User.Email))
+	for _, i := range []int{1, 3} {
+		assert.Nil(t, resp.Results[i].User)
+		require.NotNil(t, resp.Results[i].Error)
+		assert.Equal(t, "A user with this email address already exists", *resp.Results[i].Error)
+	}
+	require.NotNil(t, resp.Results[4].Error)
+	assert.Contains(t, *resp.Results[4].Error, "age must be between")
+
+	_, additionalProps, err := dbService.GetUserByIDWithAdditionalProps(resp.Results[0].User.Id)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"team": "red"}, additionalProps)
+
+	// One user_created job per created user, on top of the first user's
+	assert.Equal(t, int64(3), pendingJobs())
+	assert.Equal(t, int64(3), userCount())
+
+	// all_or_nothing creates none of them if any fails
+	rec, resp = postBatch("?all_or_nothing=true", `[
+		{"email": "third@example.com", "age": 30},
+		{"email": "taken@example.com", "age": 31}
+	]`)
+	require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	assert.Equal(t, 0, resp.Created)
+	assert.Equal(t, 2, resp.Failed)
+	require.NotNil(t, resp.Results[0].Error)
+	assert.Equal(t, database.ErrBatchRolledBack.Error(), *resp.Results[0].Error)
+	require.NotNil(t, resp.Results[1].Error)
+	assert.Equal(t, "A user with this email address already exists", *resp.Results[1].Error)
+	assert.Equal(t, int64(3), pendingJobs())
+	assert.Equal(t, int64(3), userCount())
+
+	rec, resp = postBatch("?all_or_nothing=true", `[
+		{"email": "third@example.com", "age": 30},
+		{"email": "fourth@example.com", "age": 31}
+	]`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, 2, resp.Created)
+	assert.Equal(t, int64(5), pendingJobs())
+
+	// Users that break the schema reject the whole request
+	rec, _ = postBatch("", `[{"email": "fifth@example.com", "age": -1}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = postBatch("", `[]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, int64(5), userCount())
+}
+
+func TestDatabaseService_EmailNormalization(t *testing.T) {
+	e, _, dbService := setupTestAppVariants(t, "default")
+
+	// Emails are stored trimmed and lowercased
+	user, err := dbService.CreateUser(generated.UserRequest{Email: "  Foo@Example.COM\t", Age: 30}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "foo@example.com", string(user.Email))
+	stored, err := dbService.GetUserByID(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "foo@example.com", string(stored.Email))
+
+	// so the unique check ignores case and padding
+	for _, email := range []string{"foo@example.com", "FOO@EXAMPLE.COM", " foo@example.com "} {
+		_, err := dbService.CreateUser(generated.UserRequest{Email: openapi_types.Email(email), Age: 30}, nil)
+		assert.ErrorIs(t, err, database.ErrEmailTaken, email)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "Foo@example.com", "age": 30}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Updates are normalized the same way
+	other, err := dbService.CreateUser(generated.UserRequest{Email: "bar@example.com", Age: 31}, nil)
+	require.NoError(t, err)
+	_, err = dbService.UpdateUser(other.Id, generated.UserRequest{Email: "FOO@example.com", Age: 31}, nil)
+	assert.ErrorIs(t, err, database.ErrEmailTaken)
+	updated, err := dbService.UpdateUser(other.Id, generated.UserRequest{Email: " Bar.New@Example.com", Age: 31}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "bar.new@example.com", string(updated.Email))
+}
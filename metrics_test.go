@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"openapi-validation-example/generated"
+	"openapi-validation-example/internal/handlers"
+	"openapi-validation-example/pkg/database"
+	"openapi-validation-example/pkg/jobs"
+	"openapi-validation-example/pkg/metrics"
+	"openapi-validation-example/pkg/validation"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestAppMetrics creates a test Echo app wired like cmd/server-variants
+func setupTestAppMetrics(t *testing.T) (*echo.Echo, *database.DatabaseService) {
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+
+	serverMetrics := metrics.New()
+	e.Use(serverMetrics.Middleware())
+	e.GET(metrics.Path, serverMetrics.Handler())
+
+	validationMiddleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		OnValidationError:  serverMetrics.ValidationError,
+	})
+	require.NoError(t, err)
+	e.Use(validationMiddleware.Validate())
+
+	testDBPath := "test_users_metrics.db"
+	os.Remove(testDBPath)
+
+	db, err := database.NewDatabaseService(testDBPath)
+	require.NoError(t, err)
+	require.NoError(t, serverMetrics.RegisterJobQueue(db.GetJobQueue()))
+
+	generated.RegisterHandlers(e, handlers.NewUserHandler(db))
+
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(testDBPath)
+	})
+
+	return e, db
+}
+
+func scrapeMetrics(t *testing.T, e *echo.Echo) string {
+	req := httptest.NewRequest(http.MethodGet, metrics.Path, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_Endpoint(t *testing.T) {
+	e, db := setupTestAppMetrics(t)
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/users", `{"email": "metrics@example.com", "age": 25}`},
+		{http.MethodPost, "/users", `{"email": "metrics@example.com", "age": -1}`},
+		{http.MethodGet, "/users/1", ""},
+		{http.MethodGet, "/users/999", ""},
+		{http.MethodGet, "/no-such-route", ""},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBufferString(r.body))
+		if r.body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	_, err := db.GetJobQueue().EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "metrics"}, 0)
+	require.NoError(t, err)
+
+	// Scrape twice; the first scrape must not be counted by the second
+	scrapeMetrics(t, e)
+	body := scrapeMetrics(t, e)
+
+	// Request counts by route template and status
+	assert.Contains(t, body, `http_requests_total{method="POST",route="/users",status="201"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="POST",route="/users",status="400"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/users/:id",status="200"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/users/:id",status="404"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+
+	// Latency histogram
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="POST",route="/users"} 2`)
+
+	// Job queue gauges: user_created from the POST plus the data_analysis job
+	assert.Contains(t, body, `job_queue_jobs{status="pending"} 2`)
+	assert.Contains(t, body, `job_queue_jobs{status="failed"} 0`)
+	assert.Contains(t, body, `job_queue_jobs{status="cancelled"} 0`)
+
+	// The metrics endpoint does not count itself
+	assert.False(t, strings.Contains(body, `route="/metrics"`), "metrics endpoint should not be counted")
+}
+
+func TestMetrics_ValidationErrorsByField(t *testing.T) {
+	e, _ := setupTestAppMetrics(t)
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/users", `{"age": 25}`},
+		{http.MethodPost, "/users", `{"age": 30, "name": "No Email"}`},
+		{http.MethodPost, "/users", `{"email": "bad-age@example.com", "age": -1}`},
+		{http.MethodPost, "/users", `{"email": "extra@example.com", "age": 30, "hobby": "chess"}`},
+		{http.MethodPost, "/users", `{not json`},
+		{http.MethodGet, "/users?limit=0", ""},
+		{http.MethodPost, "/users", `{"email": "valid@example.com", "age": 30}`},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBufferString(r.body))
+		if r.body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	body := scrapeMetrics(t, e)
+	assert.Contains(t, body, `validation_errors_total{field="email"} 2`)
+	assert.Contains(t, body, `validation_errors_total{field="age"} 1`)
+	assert.Contains(t, body, `validation_errors_total{field="unknown_property"} 1`)
+	assert.Contains(t, body, `validation_errors_total{field="body"} 1`)
+	assert.Contains(t, body, `validation_errors_total{field="limit"} 1`)
+	assert.NotContains(t, body, `field="hobby"`, "client-chosen property names are not labels")
+}
@@ -0,0 +1,138 @@
+// Package auth authenticates API clients by the X-API-Key header.
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderAPIKey carries the client's API key. It matches the ApiKeyAuth
+// security scheme in the OpenAPI specs.
+const HeaderAPIKey = "X-API-Key"
+
+var (
+	// ErrMissingAPIKey is returned when a request has no X-API-Key header
+	ErrMissingAPIKey = errors.New("missing API key")
+
+	// ErrInvalidAPIKey is returned when the X-API-Key header is not a
+	// configured key
+	ErrInvalidAPIKey = errors.New("invalid API key")
+)
+
+// KeySet is the set of API keys the servers accept
+type KeySet struct {
+	keys [][]byte
+}
+
+// NewKeySet returns a KeySet accepting keys. Empty keys are ignored.
+func NewKeySet(keys []string) *KeySet {
+	ks := &KeySet{}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			ks.keys = append(ks.keys, []byte(key))
+		}
+	}
+	return ks
+}
+
+// LoadKeySet reads the keys from API_KEYS (comma-separated) and the file
+// named by API_KEYS_FILE (one key per line, # starts a comment). With neither
+// set the KeySet is empty and authentication is disabled.
+func LoadKeySet() (*KeySet, error) {
+	keys := strings.Split(os.Getenv("API_KEYS"), ",")
+
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open API_KEYS_FILE: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			keys = append(keys, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read API_KEYS_FILE: %w", err)
+		}
+	}
+
+	return NewKeySet(keys), nil
+}
+
+// Enabled reports whether any key is configured. Servers without keys stay
+// open.
+func (ks *KeySet) Enabled() bool {
+	return len(ks.keys) > 0
+}
+
+// Check returns nil if key is one of the configured keys. Keys are compared
+// in constant time.
+func (ks *KeySet) Check(key string) error {
+	if key == "" {
+		return ErrMissingAPIKey
+	}
+
+	valid := 0
+	for _, k := range ks.keys {
+		valid |= subtle.ConstantTimeCompare(k, []byte(key))
+	}
+	if valid == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
+
+// Middleware rejects requests without a valid X-API-Key header with 401.
+// Requests to the exempt paths (e.g. health checks and metrics) pass
+// through. It covers every route, including those outside the OpenAPI spec.
+func (ks *KeySet) Middleware(exempt ...string) echo.MiddlewareFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip[c.Request().URL.Path] {
+				return next(c)
+			}
+			if err := ks.Check(c.Request().Header.Get(HeaderAPIKey)); err != nil {
+				return Unauthorized(c, err)
+			}
+			return next(c)
+		}
+	}
+}
+
+// AuthenticationFunc checks the apiKey security schemes the spec declares,
+// for use as validation.Options.AuthenticationFunc
+func (ks *KeySet) AuthenticationFunc() openapi3filter.AuthenticationFunc {
+	return func(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
+		scheme := input.SecurityScheme
+		if scheme.Type != "apiKey" || scheme.In != openapi3.ParameterInHeader {
+			return fmt.Errorf("unsupported security scheme %s", input.SecuritySchemeName)
+		}
+		return ks.Check(input.RequestValidationInput.Request.Header.Get(scheme.Name))
+	}
+}
+
+// Unauthorized writes the 401 response for a failed API key check
+func Unauthorized(c echo.Context, err error) error {
+	return response.JSON(c, http.StatusUnauthorized, map[string]string{
+		"error": fmt.Sprintf("Unauthorized: %v", err),
+	})
+}
@@ -4,91 +4,400 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"openapi-validation-example/db"
 	"openapi-validation-example/generated"
 	"openapi-validation-example/pkg/jobs"
 
 	openapi_types "github.com/oapi-codegen/runtime/types"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// ErrUserNotFound is returned, possibly wrapped, when no user has the given
+// ID. Check for it with errors.Is.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrEmailTaken is returned, wrapped, when a user is created with an email
+// address another user already has. Check for it with errors.Is.
+var ErrEmailTaken = errors.New("email address is already registered")
+
+// ErrBatchRolledBack is the result of the users an all-or-nothing
+// CreateUsers did not create because others in the batch failed
+var ErrBatchRolledBack = errors.New("not created: other users in the batch failed")
+
+// NormalizeEmail trims and lowercases an email address. Emails are stored
+// normalized, so the unique constraint on users.email ignores case and
+// surrounding whitespace.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation,
+// for SQLite or for a Postgres driver exposing the SQLSTATE (e.g. pgx)
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == "23505"
+	}
+	return false
+}
+
+// PostCreateHook runs custom side effects (e.g. enqueueing extra jobs) after
+// a user has been created.
+type PostCreateHook func(ctx context.Context, user *generated.User, additionalProps map[string]interface{}) error
+
+// HookMode controls when the PostCreateHook runs relative to the create
+// transaction.
+type HookMode int
+
+const (
+	// HookAfterCommit runs the hook once the user is committed. A hook error
+	// is logged and does not fail the create.
+	HookAfterCommit HookMode = iota
+	// HookInTransaction runs the hook inside the create transaction, which is
+	// available through TxFromContext. A hook error rolls back the user. The
+	// hook must use that transaction for its queries: with MaxOpenConns set to
+	// 1, the transaction holds the only connection.
+	HookInTransaction
+)
+
+// DefaultIdempotencyKeyTTL is how long CreateUserIdempotent remembers a key
+// unless changed with SetIdempotencyKeyTTL
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+type txContextKey struct{}
+
+// TxFromContext returns the create transaction passed to a PostCreateHook
+// running in HookInTransaction mode.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// DatabaseConfig tunes the SQLite connection. The zero value keeps SQLite's
+// and database/sql's defaults; DefaultDatabaseConfig is what
+// NewDatabaseService uses.
+type DatabaseConfig struct {
+	// Driver is the database/sql driver name: DriverSQLite (the default when
+	// empty) or DriverPostgres. For Postgres the path passed to
+	// NewDatabaseServiceWithConfig is the DSN and the SQLite-only settings
+	// (JournalMode, BusyTimeout) are ignored. See schema.go.
+	Driver string
+	// JournalMode is set with PRAGMA journal_mode, e.g. "WAL", which lets
+	// readers proceed while a writer is active. Empty keeps the default.
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// connection or process before failing with "database is locked". When
+	// set, transactions also begin IMMEDIATE, taking the write lock up front:
+	// a read-then-write transaction that had to upgrade its lock later could
+	// fail without waiting.
+	BusyTimeout time.Duration
+	// MaxOpenConns limits the connection pool. SQLite allows a single writer,
+	// so 1 avoids lock contention within the process. 0 means unlimited.
+	MaxOpenConns int
+	// MaxIdleConns is how many idle connections the pool keeps open. 0 keeps
+	// database/sql's default of 2; it is capped at MaxOpenConns.
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections after they have been open this long.
+	// 0 keeps connections open indefinitely.
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultDatabaseConfig returns the settings used by NewDatabaseService
+func DefaultDatabaseConfig() DatabaseConfig {
+	return DatabaseConfig{
+		JournalMode:  "WAL",
+		BusyTimeout:  5 * time.Second,
+		MaxOpenConns: 1,
+		MaxIdleConns: 1,
+	}
+}
+
+// maxIdleConns resolves MaxIdleConns to the value passed to the pool
+func (cfg DatabaseConfig) maxIdleConns() int {
+	if cfg.MaxIdleConns > 0 {
+		return cfg.MaxIdleConns
+	}
+	return 2
+}
+
+// dsn appends the pragmas to the path as _pragma query parameters, so the
+// driver applies them to every connection the pool opens rather than just
+// the first one.
+func (cfg DatabaseConfig) dsn(dbPath string) string {
+	if cfg.driver() != DriverSQLite {
+		return dbPath
+	}
+
+	params := url.Values{}
+	if cfg.BusyTimeout > 0 {
+		params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeout.Milliseconds()))
+		params.Set("_txlock", "immediate")
+	}
+	if cfg.JournalMode != "" {
+		params.Add("_pragma", fmt.Sprintf("journal_mode(%s)", cfg.JournalMode))
+	}
+	if len(params) == 0 {
+		return dbPath
+	}
+
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	return dbPath + separator + params.Encode()
+}
+
 type DatabaseService struct {
-	db       *sql.DB
-	queries  *db.Queries
-	jobQueue *jobs.JobQueueService
+	dbPath  string
+	config  DatabaseConfig
+	db      *sql.DB
+	queries *db.Queries
+
+	// jobQueue receives the service's jobs: the SQLite queue sharing the
+	// pool unless replaced with SetJobQueue
+	jobQueue jobs.JobQueue
+
+	postCreateHook PostCreateHook
+	hookMode       HookMode
+
+	idempotencyTTL time.Duration
+	idempotencyMu  sync.Mutex
 }
 
 func NewDatabaseService(dbPath string) (*DatabaseService, error) {
-	database, err := sql.Open("sqlite", dbPath)
+	return NewDatabaseServiceWithConfig(dbPath, DefaultDatabaseConfig())
+}
+
+func NewDatabaseServiceWithConfig(dbPath string, cfg DatabaseConfig) (*DatabaseService, error) {
+	driver := cfg.driver()
+	if _, ok := schemas[driver]; !ok {
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+
+	database, err := sql.Open(driver, cfg.dsn(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	database.SetMaxOpenConns(cfg.MaxOpenConns)
+	database.SetMaxIdleConns(cfg.maxIdleConns())
+	database.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
 	if err := database.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	queries := db.New(database)
 
-	if err := initSchema(database); err != nil {
+	if err := initSchema(database, driver); err != nil {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
-	jobQueue := jobs.NewJobQueueService(database)
+	// The job queue shares the pool and the generated queries
+	jobQueue := jobs.NewJobQueueService(database, queries)
 
 	return &DatabaseService{
-		db:      database,
-		queries: queries,
+		dbPath:   dbPath,
+		config:   cfg,
+		db:       database,
+		queries:  queries,
 		jobQueue: jobQueue,
+
+		idempotencyTTL: DefaultIdempotencyKeyTTL,
 	}, nil
 }
 
-func initSchema(database *sql.DB) error {
-	schema := `
-CREATE TABLE IF NOT EXISTS users (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    email TEXT NOT NULL UNIQUE,
-    age INTEGER NOT NULL CHECK(age >= 0),
-    name TEXT,
-    bio TEXT,
-    is_active BOOLEAN NOT NULL DEFAULT 1,
-    additional_data TEXT,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS job_queue (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    job_type TEXT NOT NULL,
-    payload TEXT NOT NULL,
-    status TEXT NOT NULL DEFAULT 'pending',
-    priority INTEGER DEFAULT 0,
-    max_retries INTEGER DEFAULT 3,
-    retry_count INTEGER DEFAULT 0,
-    error_message TEXT,
-    scheduled_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    started_at DATETIME,
-    completed_at DATETIME,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-CREATE INDEX IF NOT EXISTS idx_users_active ON users(is_active);
-CREATE INDEX IF NOT EXISTS idx_job_queue_status ON job_queue(status);
-CREATE INDEX IF NOT EXISTS idx_job_queue_type ON job_queue(job_type);
-CREATE INDEX IF NOT EXISTS idx_job_queue_scheduled ON job_queue(scheduled_at);
-CREATE INDEX IF NOT EXISTS idx_job_queue_priority ON job_queue(priority DESC, scheduled_at);`
-
-	if _, err := database.Exec(schema); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+func (ds *DatabaseService) CreateUser(userReq generated.UserRequest, additionalProps map[string]interface{}) (*generated.User, error) {
+	return ds.CreateUserContext(context.Background(), userReq, additionalProps)
+}
+
+// CreateUserContext is CreateUser for a request context. A request ID set
+// with jobs.ContextWithRequestID is recorded on the user_created job, and ctx
+// is passed on to the PostCreateHook.
+func (ds *DatabaseService) CreateUserContext(ctx context.Context, userReq generated.UserRequest, additionalProps map[string]interface{}) (*generated.User, error) {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return nil
+	user, err := ds.createUserTx(ctx, tx, userReq, additionalProps)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user: %w", err)
+	}
+
+	ds.afterUserCommit(ctx, user, additionalProps)
+
+	return user, nil
 }
 
-func (ds *DatabaseService) CreateUser(userReq generated.UserRequest, additionalProps map[string]interface{}) (*generated.User, error) {
+// CreateUserIdempotent creates a user like CreateUser and remembers key for
+// the idempotency key TTL. When a user was already created with key, that user
+// is returned instead and created is false.
+//
+// Requests with the same key are serialized: in-process by a mutex, and
+// across processes by the write lock the lookup transaction takes up front
+// (see DatabaseConfig.BusyTimeout).
+func (ds *DatabaseService) CreateUserIdempotent(key string, userReq generated.UserRequest, additionalProps map[string]interface{}) (user *generated.User, created bool, err error) {
+	return ds.CreateUserIdempotentContext(context.Background(), key, userReq, additionalProps)
+}
+
+// CreateUserIdempotentContext is CreateUserIdempotent for a request context,
+// see CreateUserContext.
+func (ds *DatabaseService) CreateUserIdempotentContext(ctx context.Context, key string, userReq generated.UserRequest, additionalProps map[string]interface{}) (user *generated.User, created bool, err error) {
+	ds.idempotencyMu.Lock()
+	defer ds.idempotencyMu.Unlock()
+
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	qtx := ds.queries.WithTx(tx)
+
+	if _, err := qtx.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+		return nil, false, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	existing, err := qtx.GetIdempotencyKey(ctx, key)
+	switch {
+	case err == nil:
+		dbUser, err := qtx.GetUserByID(ctx, existing.UserID)
+		if err == nil {
+			user, err := ds.convertDBUserToGenerated(dbUser)
+			return user, false, err
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, fmt.Errorf("failed to get user: %w", err)
+		}
+		// The user has been deleted since; the key is reused for a new user
+	case err != sql.ErrNoRows:
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	user, err = ds.createUserTx(ctx, tx, userReq, additionalProps)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := qtx.UpsertIdempotencyKey(ctx, db.UpsertIdempotencyKeyParams{
+		IdempotencyKey: key,
+		UserID:         user.Id,
+		ExpiresIn:      fmt.Sprintf("%+d seconds", int64(ds.idempotencyTTL.Seconds())),
+	}); err != nil {
+		return nil, false, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit user: %w", err)
+	}
+
+	ds.afterUserCommit(ctx, user, additionalProps)
+
+	return user, true, nil
+}
+
+// BatchUser is one user for CreateUsers to create
+type BatchUser struct {
+	Request         generated.UserRequest
+	AdditionalProps map[string]interface{}
+}
+
+// BatchResult is the outcome of one BatchUser: the user created, or the
+// error that stopped it (e.g. ErrEmailTaken)
+type BatchResult struct {
+	User *generated.User
+	Err  error
+}
+
+// CreateUsers creates users like CreateUser, in one transaction, with one
+// user_created job each, and returns their results in the same order. A user
+// that fails is rolled back on its own and the others are still created,
+// unless allOrNothing is set: then, if any user fails, none are created and
+// the rest report ErrBatchRolledBack. The error is for the batch as a whole,
+// e.g. a failed commit.
+func (ds *DatabaseService) CreateUsers(users []BatchUser, allOrNothing bool) ([]BatchResult, error) {
+	return ds.CreateUsersContext(context.Background(), users, allOrNothing)
+}
+
+// CreateUsersContext is CreateUsers for a request context, see
+// CreateUserContext.
+func (ds *DatabaseService) CreateUsersContext(ctx context.Context, users []BatchUser, allOrNothing bool) ([]BatchResult, error) {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]BatchResult, len(users))
+	failed := false
+	for i, batchUser := range users {
+		// Each user gets a savepoint, so a failed one can be undone without
+		// losing the others (Postgres aborts the whole transaction otherwise)
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_user"); err != nil {
+			return nil, fmt.Errorf("failed to create savepoint for user %d: %w", i, err)
+		}
+
+		user, err := ds.createUserTx(ctx, tx, batchUser.Request, batchUser.AdditionalProps)
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_user"); rbErr != nil {
+				return nil, fmt.Errorf("failed to roll back user %d: %w", i, rbErr)
+			}
+			results[i].Err = err
+			failed = true
+		} else {
+			results[i].User = user
+		}
+
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_user"); err != nil {
+			return nil, fmt.Errorf("failed to release savepoint for user %d: %w", i, err)
+		}
+	}
+
+	if allOrNothing && failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = BatchResult{Err: ErrBatchRolledBack}
+			}
+		}
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit users: %w", err)
+	}
+
+	for i, result := range results {
+		if result.User != nil {
+			ds.afterUserCommit(ctx, result.User, users[i].AdditionalProps)
+		}
+	}
+
+	return results, nil
+}
+
+// createUserTx inserts the user and enqueues its user_created job in tx, so
+// a user is never committed without its job (or the other way around). It
+// also runs a HookInTransaction hook.
+func (ds *DatabaseService) createUserTx(ctx context.Context, tx *sql.Tx, userReq generated.UserRequest, additionalProps map[string]interface{}) (*generated.User, error) {
 	var additionalData sql.NullString
 	if len(additionalProps) > 0 {
 		jsonData, err := json.Marshal(additionalProps)
@@ -113,8 +422,8 @@ func (ds *DatabaseService) CreateUser(userReq generated.UserRequest, additionalP
 		isActive = *userReq.IsActive
 	}
 
-	dbUser, err := ds.queries.CreateUser(context.Background(), db.CreateUserParams{
-		Email:          string(userReq.Email),
+	dbUser, err := ds.queries.WithTx(tx).CreateUser(ctx, db.CreateUserParams{
+		Email:          NormalizeEmail(string(userReq.Email)),
 		Age:            int64(userReq.Age),
 		Name:           name,
 		Bio:            bio,
@@ -122,6 +431,10 @@ func (ds *DatabaseService) CreateUser(userReq generated.UserRequest, additionalP
 		AdditionalData: additionalData,
 	})
 	if err != nil {
+		// email is the only unique column a new user can collide on
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("user %s: %w", NormalizeEmail(string(userReq.Email)), ErrEmailTaken)
+		}
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -130,10 +443,29 @@ func (ds *DatabaseService) CreateUser(userReq generated.UserRequest, additionalP
 		return nil, err
 	}
 
-	// Enqueue background job for user created
-	jobPayload := jobs.JobPayload{
-		UserID:          &user.Id,
-		UserData:        map[string]interface{}{
+	// Enqueue background job for user created, committed with the user. A
+	// queue outside the database gets it after the commit instead.
+	if txQueue, ok := ds.jobQueue.(jobs.TxEnqueuer); ok {
+		jobPayload := userCreatedJobPayload(ctx, user, additionalProps)
+		if _, err := txQueue.EnqueueJobTx(ctx, tx, jobs.JobUserCreated, jobPayload, 1); err != nil {
+			return nil, fmt.Errorf("failed to enqueue user created job: %w", err)
+		}
+	}
+
+	if ds.postCreateHook != nil && ds.hookMode == HookInTransaction {
+		if err := ds.postCreateHook(context.WithValue(ctx, txContextKey{}, tx), user, additionalProps); err != nil {
+			return nil, fmt.Errorf("post-create hook failed: %w", err)
+		}
+	}
+
+	return user, nil
+}
+
+// userCreatedJobPayload is the payload of the user_created job for user
+func userCreatedJobPayload(ctx context.Context, user *generated.User, additionalProps map[string]interface{}) jobs.JobPayload {
+	return jobs.JobPayload{
+		UserID: &user.Id,
+		UserData: map[string]interface{}{
 			"id":        user.Id,
 			"email":     user.Email,
 			"age":       user.Age,
@@ -142,22 +474,58 @@ func (ds *DatabaseService) CreateUser(userReq generated.UserRequest, additionalP
 			"is_active": user.IsActive,
 		},
 		AdditionalProps: additionalProps,
+		RequestID:       jobs.RequestIDFromContext(ctx),
 	}
+}
 
-	_, jobErr := ds.jobQueue.EnqueueJob(jobs.JobUserCreated, jobPayload, 1)
-	if jobErr != nil {
-		// Log error but don't fail the user creation
-		fmt.Printf("Failed to enqueue job for user %d: %v\n", user.Id, jobErr)
+// afterUserCommit enqueues the user_created job on a queue that could not
+// take it in the user's transaction and then runs an after-commit hook
+func (ds *DatabaseService) afterUserCommit(ctx context.Context, user *generated.User, additionalProps map[string]interface{}) {
+	if _, ok := ds.jobQueue.(jobs.TxEnqueuer); !ok {
+		if _, err := ds.jobQueue.EnqueueJob(ctx, jobs.JobUserCreated, userCreatedJobPayload(ctx, user, additionalProps), 1); err != nil {
+			// Log error but don't fail the user creation
+			fmt.Printf("Failed to enqueue job for created user %d: %v\n", user.Id, err)
+		}
 	}
+	ds.runAfterCommitHook(ctx, user, additionalProps)
+}
 
-	return user, nil
+func (ds *DatabaseService) runAfterCommitHook(ctx context.Context, user *generated.User, additionalProps map[string]interface{}) {
+	if ds.postCreateHook != nil && ds.hookMode == HookAfterCommit {
+		if err := ds.postCreateHook(ctx, user, additionalProps); err != nil {
+			// Log error but don't fail the user creation
+			fmt.Printf("Post-create hook failed for user %d: %v\n", user.Id, err)
+		}
+	}
+}
+
+// SetIdempotencyKeyTTL sets how long CreateUserIdempotent remembers a key.
+// It applies to keys stored from then on.
+func (ds *DatabaseService) SetIdempotencyKeyTTL(ttl time.Duration) {
+	ds.idempotencyTTL = ttl
+}
+
+// SetJobQueue replaces the SQLite job queue with queue, e.g. a
+// jobs.MemoryJobQueue in tests. Unless queue implements jobs.TxEnqueuer, a
+// user_created job is enqueued once the user is committed, so a crash in
+// between loses the job. Call it before the service is shared between
+// goroutines.
+func (ds *DatabaseService) SetJobQueue(queue jobs.JobQueue) {
+	ds.jobQueue = queue
+}
+
+// SetPostCreateHook installs hook to run on every successful CreateUser,
+// replacing any previous hook. Pass nil to remove it.
+func (ds *DatabaseService) SetPostCreateHook(hook PostCreateHook, mode HookMode) {
+	ds.postCreateHook = hook
+	ds.hookMode = mode
 }
 
 func (ds *DatabaseService) GetUserByID(id int64) (*generated.User, error) {
 	dbUser, err := ds.queries.GetUserByID(context.Background(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -165,6 +533,157 @@ func (ds *DatabaseService) GetUserByID(id int64) (*generated.User, error) {
 	return ds.convertDBUserToGenerated(dbUser)
 }
 
+// GetUserByIDWithAdditionalProps returns the user together with the extra
+// properties stored in additional_data on create. The map is nil when the
+// user has none, or when the stored JSON cannot be parsed.
+func (ds *DatabaseService) GetUserByIDWithAdditionalProps(id int64) (*generated.User, map[string]interface{}, error) {
+	dbUser, err := ds.queries.GetUserByID(context.Background(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	user, err := ds.convertDBUserToGenerated(dbUser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, parseAdditionalData(dbUser), nil
+}
+
+// CountUsersByActive returns how many users are active and inactive. It
+// reads only the idx_users_active index, not the users table.
+func (ds *DatabaseService) CountUsersByActive() (active int64, inactive int64, err error) {
+	counts, err := ds.queries.CountUsersByActive(context.Background())
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count users by active: %w", err)
+	}
+	return counts.ActiveCount, counts.InactiveCount, nil
+}
+
+// ListUsers returns a page of users ordered by ID together with the total
+// number of users, so callers can work out whether more pages exist.
+func (ds *DatabaseService) ListUsers(limit, offset int) ([]generated.User, int64, error) {
+	ctx := context.Background()
+
+	dbUsers, err := ds.queries.ListUsers(ctx, db.ListUsersParams{
+		Limit:  int64(limit),
+		Offset: int64(offset),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	total, err := ds.queries.CountUsers(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	users := make([]generated.User, 0, len(dbUsers))
+	for _, dbUser := range dbUsers {
+		user, err := ds.convertDBUserToGenerated(dbUser)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, total, nil
+}
+
+// UpdateUser replaces the user's fields and additional properties. The query
+// bumps updated_at to CURRENT_TIMESTAMP and leaves created_at untouched.
+func (ds *DatabaseService) UpdateUser(id int64, userReq generated.UserRequest, additionalProps map[string]interface{}) (*generated.User, error) {
+	var additionalData sql.NullString
+	if len(additionalProps) > 0 {
+		jsonData, err := json.Marshal(additionalProps)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal additional properties: %w", err)
+		}
+		additionalData = sql.NullString{String: string(jsonData), Valid: true}
+	}
+
+	var name sql.NullString
+	if userReq.Name != nil {
+		name = sql.NullString{String: *userReq.Name, Valid: true}
+	}
+
+	var bio sql.NullString
+	if userReq.Bio != nil {
+		bio = sql.NullString{String: *userReq.Bio, Valid: true}
+	}
+
+	isActive := true
+	if userReq.IsActive != nil {
+		isActive = *userReq.IsActive
+	}
+
+	dbUser, err := ds.queries.UpdateUser(context.Background(), db.UpdateUserParams{
+		ID:             id,
+		Email:          NormalizeEmail(string(userReq.Email)),
+		Age:            int64(userReq.Age),
+		Name:           name,
+		Bio:            bio,
+		IsActive:       isActive,
+		AdditionalData: additionalData,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("user %d: %w", id, ErrEmailTaken)
+		}
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return ds.convertDBUserToGenerated(dbUser)
+}
+
+// DeleteUser removes a user and enqueues a user_deleted job. The delete and
+// the lookup happen in a single statement, so deleting an already removed
+// user reports ErrUserNotFound instead of enqueueing a second job.
+func (ds *DatabaseService) DeleteUser(id int64) error {
+	return ds.DeleteUserContext(context.Background(), id)
+}
+
+// DeleteUserContext is DeleteUser for a request context. A request ID set with
+// jobs.ContextWithRequestID is recorded on the user_deleted job.
+func (ds *DatabaseService) DeleteUserContext(ctx context.Context, id int64) error {
+	dbUser, err := ds.queries.DeleteUser(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	user, err := ds.convertDBUserToGenerated(dbUser)
+	if err != nil {
+		return err
+	}
+
+	// Enqueue background job for user deleted
+	jobPayload := jobs.JobPayload{
+		UserID: &user.Id,
+		UserData: map[string]interface{}{
+			"id":    user.Id,
+			"email": user.Email,
+		},
+		RequestID: jobs.RequestIDFromContext(ctx),
+	}
+
+	_, jobErr := ds.jobQueue.EnqueueJob(ctx, jobs.JobUserDeleted, jobPayload, 1)
+	if jobErr != nil {
+		// Log error but don't undo the delete
+		fmt.Printf("Failed to enqueue job for deleted user %d: %v\n", user.Id, jobErr)
+	}
+
+	return nil
+}
+
 func (ds *DatabaseService) convertDBUserToGenerated(dbUser db.User) (*generated.User, error) {
 	user := &generated.User{
 		Id:    dbUser.ID,
@@ -182,13 +701,96 @@ func (ds *DatabaseService) convertDBUserToGenerated(dbUser db.User) (*generated.
 
 	user.IsActive = &dbUser.IsActive
 
+	if dbUser.CreatedAt.Valid {
+		user.CreatedAt = &dbUser.CreatedAt.Time
+	}
+
+	if dbUser.UpdatedAt.Valid {
+		user.UpdatedAt = &dbUser.UpdatedAt.Time
+	}
+
 	return user, nil
 }
 
+func parseAdditionalData(dbUser db.User) map[string]interface{} {
+	if !dbUser.AdditionalData.Valid || dbUser.AdditionalData.String == "" {
+		return nil
+	}
+
+	var additionalProps map[string]interface{}
+	if err := json.Unmarshal([]byte(dbUser.AdditionalData.String), &additionalProps); err != nil {
+		// Log error but still return the user without the extra properties
+		fmt.Printf("Failed to parse additional data for user %d: %v\n", dbUser.ID, err)
+		return nil
+	}
+
+	return additionalProps
+}
+
+// Ping checks that the database is still usable. Besides pinging the
+// connection pool it checks that the database file still exists, since open
+// SQLite connections keep working on a file that has been moved or deleted.
+// In-memory databases have no file to check.
+func (ds *DatabaseService) Ping(ctx context.Context) error {
+	if err := ds.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	if ds.config.driver() == DriverSQLite {
+		// Ask SQLite for the file it opened rather than parsing the DSN,
+		// which may be a file: URI with query parameters
+		var file string
+		err := ds.db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file)
+		if err != nil {
+			return fmt.Errorf("failed to look up database file: %w", err)
+		}
+		if file != "" {
+			if _, err := os.Stat(file); err != nil {
+				return fmt.Errorf("database file %s is not accessible: %w", file, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reconnect drops the idle connections so that new ones reopen the database
+// path, then re-applies the schema in case the file had to be recreated. The
+// pool is reused, so services holding it (e.g. the job queue) keep working.
+func (ds *DatabaseService) Reconnect(ctx context.Context) error {
+	// Closes idle connections; restore the configured limit afterwards
+	ds.db.SetMaxIdleConns(0)
+	ds.db.SetMaxIdleConns(ds.config.maxIdleConns())
+
+	if err := ds.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to reconnect to database: %w", err)
+	}
+
+	if err := initSchema(ds.db, ds.config.driver()); err != nil {
+		return fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	return ds.Ping(ctx)
+}
+
+// Stats returns the connection pool statistics
+func (ds *DatabaseService) Stats() sql.DBStats {
+	return ds.db.Stats()
+}
+
 func (ds *DatabaseService) Close() error {
 	return ds.db.Close()
 }
 
-func (ds *DatabaseService) GetJobQueue() *jobs.JobQueueService {
+// JobQueue returns the queue the service enqueues its jobs on
+func (ds *DatabaseService) JobQueue() jobs.JobQueue {
 	return ds.jobQueue
+}
+
+// GetJobQueue returns the SQLite job queue, for the features it has beyond
+// jobs.JobQueue (scheduling settings, reaping, admin operations). It is nil
+// once SetJobQueue has replaced it with another implementation.
+func (ds *DatabaseService) GetJobQueue() *jobs.JobQueueService {
+	queue, _ := ds.jobQueue.(*jobs.JobQueueService)
+	return queue
 }
\ No newline at end of file
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Supported database/sql driver names. DriverPostgres is the name registered
+// by github.com/jackc/pgx/v5/stdlib, which the binary must import for it to
+// be available.
+//
+// Only schema creation is driver-aware so far: the sqlc queries in db/ are
+// generated for SQLite (? placeholders, json_extract, datetime()) and need a
+// Postgres variant before the services work against Postgres. See the
+// README's Database Drivers section.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "pgx"
+)
+
+// driver resolves the configured driver name, defaulting to SQLite
+func (cfg DatabaseConfig) driver() string {
+	if cfg.Driver == "" {
+		return DriverSQLite
+	}
+	return cfg.Driver
+}
+
+// schemas holds the DDL for each supported driver. AUTOINCREMENT, DATETIME
+// and boolean defaults differ between SQLite and Postgres. Keep them in sync
+// with schema.sql and schema.postgres.sql.
+var schemas = map[string]string{
+	DriverSQLite:   sqliteSchema,
+	DriverPostgres: postgresSchema,
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    age INTEGER NOT NULL CHECK(age >= 0),
+    name TEXT,
+    bio TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT 1,
+    additional_data TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS job_queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    priority INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 3,
+    retry_count INTEGER DEFAULT 0,
+    error_message TEXT,
+    scheduled_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    completed_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    duration_ms INTEGER,
+    request_id TEXT,
+    dedup_key TEXT,
+    worker_id INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS queue_settings (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS worker_heartbeats (
+    worker_id INTEGER PRIMARY KEY,
+    last_seen_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_active ON users(is_active);
+CREATE INDEX IF NOT EXISTS idx_job_queue_status ON job_queue(status);
+CREATE INDEX IF NOT EXISTS idx_job_queue_type ON job_queue(job_type);
+CREATE INDEX IF NOT EXISTS idx_job_queue_scheduled ON job_queue(scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_job_queue_priority ON job_queue(priority DESC, scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);`
+
+// sqliteDedupIndex allows one unhandled job per dedup key. It is created
+// after dedup_key is added to older databases.
+const sqliteDedupIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_queue_dedup ON job_queue(dedup_key)
+    WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing');`
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    age INTEGER NOT NULL CHECK(age >= 0),
+    name TEXT,
+    bio TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    additional_data TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS job_queue (
+    id BIGSERIAL PRIMARY KEY,
+    job_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    priority INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 3,
+    retry_count INTEGER DEFAULT 0,
+    error_message TEXT,
+    scheduled_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    duration_ms BIGINT,
+    request_id TEXT,
+    dedup_key TEXT,
+    worker_id BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS queue_settings (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS worker_heartbeats (
+    worker_id BIGINT PRIMARY KEY,
+    last_seen_at TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS request_id TEXT;
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS dedup_key TEXT;
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS worker_id BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_active ON users(is_active);
+CREATE INDEX IF NOT EXISTS idx_job_queue_status ON job_queue(status);
+CREATE INDEX IF NOT EXISTS idx_job_queue_type ON job_queue(job_type);
+CREATE INDEX IF NOT EXISTS idx_job_queue_scheduled ON job_queue(scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_job_queue_priority ON job_queue(priority DESC, scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_queue_dedup ON job_queue(dedup_key)
+    WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing');`
+
+func initSchema(database *sql.DB, driver string) error {
+	if _, err := database.Exec(schemas[driver]); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	// Databases created before duration tracking, request IDs, dedup keys or
+	// worker IDs lack the columns. Postgres handles this in its DDL with ADD
+	// COLUMN IF NOT EXISTS.
+	if driver == DriverSQLite {
+		if err := addColumnIfMissing(database, "job_queue", "duration_ms", "INTEGER"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(database, "job_queue", "request_id", "TEXT"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(database, "job_queue", "dedup_key", "TEXT"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(database, "job_queue", "worker_id", "INTEGER"); err != nil {
+			return err
+		}
+		// Needs dedup_key, so it can't be part of the DDL above
+		if _, err := database.Exec(sqliteDedupIndex); err != nil {
+			return fmt.Errorf("failed to create dedup index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func addColumnIfMissing(database *sql.DB, table, column, columnType string) error {
+	rows, err := database.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			ctype      string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultVal, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := database.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+
+	return nil
+}
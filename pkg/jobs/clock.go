@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Clock tells the job queue the current time. All scheduling decisions
+// (when a job is due, when a retry runs) use it instead of SQLite's
+// CURRENT_TIMESTAMP, so the database clock can't make jobs run early or
+// late. Tests can substitute a fake clock with SetClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock a new JobQueueService uses
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// sqliteTimestampFormat matches CURRENT_TIMESTAMP and SQLite's date functions
+const sqliteTimestampFormat = "2006-01-02 15:04:05"
+
+// SetClock replaces the clock used for scheduling. Call it before the service
+// is shared between goroutines.
+func (jq *JobQueueService) SetClock(clock Clock) {
+	jq.clock = clock
+}
+
+// now returns the clock's time in UTC. Timestamps are stored as text and
+// compared as text, so they must all use the same zone.
+func (jq *JobQueueService) now() time.Time {
+	return jq.clock.Now().UTC()
+}
+
+func (jq *JobQueueService) nowParam() sql.NullTime {
+	return sql.NullTime{Time: jq.now(), Valid: true}
+}
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"openapi-validation-example/db"
+)
+
+// SetConcurrencyLimits caps how many jobs of each type may be processing at
+// once across all workers sharing the database: GetNextJob and GetNextJobs
+// skip a type while it has its limit of jobs in flight. Types missing from
+// limits are unlimited, and nil removes every limit. Call it before the
+// service is shared between goroutines.
+func (jq *JobQueueService) SetConcurrencyLimits(limits map[JobType]int) error {
+	if limits == nil {
+		jq.concurrency = nil
+		return nil
+	}
+	for jobType, n := range limits {
+		if n <= 0 {
+			return fmt.Errorf("concurrency limit for %s must be positive, got %d", jobType, n)
+		}
+	}
+	jq.concurrency = limits
+	return nil
+}
+
+// ParseConcurrencyLimits parses per-type limits such as
+// "data_analysis=2,data_export=1". An empty string yields no limits.
+func ParseConcurrencyLimits(s string) (map[JobType]int, error) {
+	return parseTypeCounts(s, "concurrency limit")
+}
+
+// concurrencyCapacity returns how many more jobs of each limited type may be
+// claimed. It runs in the claim transaction, so the counts can't change
+// before the claimed jobs are marked processing.
+func (jq *JobQueueService) concurrencyCapacity(ctx context.Context, queries *db.Queries) (map[JobType]int, error) {
+	if jq.concurrency == nil {
+		return nil, nil
+	}
+	rows, err := queries.CountProcessingJobsByType(ctx)
+	if err != nil {
+		return nil, err
+	}
+	capacity := make(map[JobType]int, len(jq.concurrency))
+	for jobType, n := range jq.concurrency {
+		capacity[jobType] = n
+	}
+	for _, row := range rows {
+		if n, ok := capacity[JobType(row.JobType)]; ok {
+			capacity[JobType(row.JobType)] = n - int(row.ProcessingCount)
+		}
+	}
+	return capacity, nil
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"openapi-validation-example/db"
+)
+
+// fairScheduler shares claims between job types by smooth weighted
+// round-robin, so a type with many high-priority jobs can't starve the
+// others. Within a type, jobs are still claimed in priority order.
+type fairScheduler struct {
+	weights map[JobType]int
+
+	mu      sync.Mutex
+	current map[JobType]int
+}
+
+func (s *fairScheduler) weight(jobType JobType) int {
+	if w, ok := s.weights[jobType]; ok {
+		return w
+	}
+	return 1
+}
+
+// pick returns the type among ready to claim from next. Each ready type gains
+// its weight, the type with the most credit wins and pays back the total, so
+// over time each type gets claims in proportion to its weight.
+func (s *fairScheduler) pick(ready []JobType) JobType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best JobType
+	total := 0
+	for i, jobType := range ready {
+		w := s.weight(jobType)
+		total += w
+		s.current[jobType] += w
+		if i == 0 || s.current[jobType] > s.current[best] {
+			best = jobType
+		}
+	}
+	s.current[best] -= total
+	return best
+}
+
+// SetFairScheduling makes GetNextJob and GetNextJobs share claims between job
+// types in proportion to weights instead of taking the highest-priority jobs
+// first across the whole queue. Types missing from weights get weight 1. nil
+// restores plain priority order. Call it before the service is shared between
+// goroutines.
+func (jq *JobQueueService) SetFairScheduling(weights map[JobType]int) error {
+	if weights == nil {
+		jq.fairScheduler = nil
+		return nil
+	}
+	for jobType, w := range weights {
+		if w <= 0 {
+			return fmt.Errorf("weight for %s must be positive, got %d", jobType, w)
+		}
+	}
+	jq.fairScheduler = &fairScheduler{
+		weights: weights,
+		current: make(map[JobType]int),
+	}
+	return nil
+}
+
+// ParseFairWeights parses per-type weights such as
+// "user_created=3,email_notification=1". An empty string yields no weights.
+func ParseFairWeights(s string) (map[JobType]int, error) {
+	return parseTypeCounts(s, "weight")
+}
+
+// parseTypeCounts parses comma-separated type=n entries where each n is a
+// positive integer; what names n in error messages.
+func parseTypeCounts(s, what string) (map[JobType]int, error) {
+	counts := make(map[JobType]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q: expected type=%s", what, entry, what)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a positive integer", what, entry)
+		}
+		counts[JobType(strings.TrimSpace(name))] = n
+	}
+	return counts, nil
+}
+
+// getNextPendingJobsByType selects up to limit due jobs type by type, leaving
+// out types at their concurrency limit. With a fair scheduler the types take
+// turns as it decides; otherwise jobs are taken in claim order.
+func (jq *JobQueueService) getNextPendingJobsByType(ctx context.Context, queries *db.Queries, limit int) ([]db.JobQueue, error) {
+	now := jq.nowParam()
+	types, err := queries.GetPendingJobTypes(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := jq.concurrencyCapacity(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[JobType][]db.JobQueue, len(types))
+	ready := make([]JobType, 0, len(types))
+	for _, name := range types {
+		n := limit
+		if free, ok := capacity[JobType(name)]; ok {
+			n = min(n, free)
+		}
+		if n <= 0 {
+			continue
+		}
+		jobs, err := queries.GetNextPendingJobsForType(ctx, db.GetNextPendingJobsForTypeParams{
+			JobType: name,
+			Now:     now,
+			Limit:   int64(n),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) > 0 {
+			candidates[JobType(name)] = jobs
+			ready = append(ready, JobType(name))
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+
+	jobs := []db.JobQueue{}
+	if jq.fairScheduler == nil {
+		for _, jobType := range ready {
+			jobs = append(jobs, candidates[jobType]...)
+		}
+		SortByClaimOrder(jobs)
+		if len(jobs) > limit {
+			jobs = jobs[:limit]
+		}
+		return jobs, nil
+	}
+
+	for len(jobs) < limit && len(ready) > 0 {
+		jobType := jq.fairScheduler.pick(ready)
+		jobs = append(jobs, candidates[jobType][0])
+		candidates[jobType] = candidates[jobType][1:]
+		if len(candidates[jobType]) == 0 {
+			for i := range ready {
+				if ready[i] == jobType {
+					ready = append(ready[:i], ready[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return jobs, nil
+}
@@ -4,7 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"openapi-validation-example/db"
@@ -16,6 +22,7 @@ type JobType string
 
 const (
 	JobUserCreated      JobType = "user_created"
+	JobUserDeleted      JobType = "user_deleted"
 	JobDataAnalysis     JobType = "data_analysis"
 	JobEmailNotification JobType = "email_notification"
 	JobDataExport       JobType = "data_export"
@@ -28,33 +35,270 @@ type JobPayload struct {
 	Message          string                 `json:"message,omitempty"`
 	Recipients       []string               `json:"recipients,omitempty"`
 	ValidationMode   string                 `json:"validation_mode,omitempty"`
+	// RequestID is the X-Request-ID of the HTTP request that enqueued the
+	// job. It is also stored in the job's request_id column.
+	RequestID        string                 `json:"request_id,omitempty"`
+	// DedupKey, when set, makes enqueueing idempotent: while a job with the
+	// same key is pending or processing, enqueueing returns that job instead
+	// of adding another. It is also stored in the job's dedup_key column.
+	DedupKey         string                 `json:"dedup_key,omitempty"`
+}
+
+type requestIDContextKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of the HTTP
+// request being served, for the jobs it enqueues.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID set by ContextWithRequestID, or
+// "" when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// ErrQueueFull is returned when enqueueing would exceed the pending job cap
+// set with SetMaxPendingJobs. Producers should back off and retry later.
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrJobNotFound is returned when no job has the given ID
+var ErrJobNotFound = errors.New("job not found")
+
+// Job priorities run from MinPriority to MaxPriority. Workers claim due jobs
+// with a higher priority first, so 9 is the most urgent and 0, the usual
+// priority, the least.
+const (
+	MinPriority = 0
+	MaxPriority = 9
+)
+
+// ErrPriorityOutOfRange is returned for a priority outside MinPriority to
+// MaxPriority
+var ErrPriorityOutOfRange = errors.New("priority out of range")
+
+// ValidatePriority returns an error wrapping ErrPriorityOutOfRange for a
+// priority outside MinPriority to MaxPriority
+func ValidatePriority(priority int) error {
+	if priority < MinPriority || priority > MaxPriority {
+		return fmt.Errorf("%w: %d is not between %d (lowest) and %d (highest)", ErrPriorityOutOfRange, priority, MinPriority, MaxPriority)
+	}
+	return nil
+}
+
+// PayloadValidator checks a job's payload before it is enqueued
+type PayloadValidator func(payload JobPayload) error
+
+// DefaultPayloadValidators returns the validators a new JobQueueService starts
+// with. Use SetPayloadValidator to change them.
+func DefaultPayloadValidators() map[JobType]PayloadValidator {
+	return map[JobType]PayloadValidator{
+		JobUserCreated:       ValidateUserID,
+		JobUserDeleted:       ValidateUserID,
+		JobDataAnalysis:      ValidateMessage,
+		JobEmailNotification: ValidateRecipients,
+		JobDataExport:        ValidateMessage,
+	}
+}
+
+// ValidateUserID requires the ID of the user the job is about
+func ValidateUserID(payload JobPayload) error {
+	if payload.UserID == nil {
+		return fmt.Errorf("user_id is required")
+	}
+	if *payload.UserID <= 0 {
+		return fmt.Errorf("invalid user_id %d", *payload.UserID)
+	}
+	return nil
+}
+
+// ValidateMessage requires a message saying what the job should do, e.g.
+// what to analyze or export
+func ValidateMessage(payload JobPayload) error {
+	if strings.TrimSpace(payload.Message) == "" {
+		return fmt.Errorf("message is required")
+	}
+	return nil
+}
+
+// ValidateRecipients requires at least one recipient, each a plain email
+// address such as "user@example.com"
+func ValidateRecipients(payload JobPayload) error {
+	if len(payload.Recipients) == 0 {
+		return fmt.Errorf("at least one recipient is required")
+	}
+	for _, recipient := range payload.Recipients {
+		addr, err := mail.ParseAddress(recipient)
+		if err != nil || addr.Address != recipient {
+			return fmt.Errorf("invalid recipient %q", recipient)
+		}
+	}
+	return nil
 }
 
 type JobQueueService struct {
-	db      *sql.DB
-	queries *db.Queries
+	db            *sql.DB
+	queries       *db.Queries
+	validators    map[JobType]PayloadValidator
+	statsCache    *statsCache
+	fairScheduler *fairScheduler
+	concurrency   map[JobType]int
+	maxPending    int64
+	clock         Clock
+
+	subscribersMu sync.Mutex
+	subscribers   []chan struct{}
 }
 
-func NewJobQueueService(database *sql.DB) *JobQueueService {
+// NewJobQueueService creates a job queue on the given pool. queries must be
+// built on the same pool (DatabaseService passes its own); nil creates them.
+func NewJobQueueService(database *sql.DB, queries *db.Queries) *JobQueueService {
+	if queries == nil {
+		queries = db.New(database)
+	}
 	return &JobQueueService{
-		db:      database,
-		queries: db.New(database),
+		db:         database,
+		queries:    queries,
+		validators: DefaultPayloadValidators(),
+		clock:      SystemClock{},
 	}
 }
 
-func (jq *JobQueueService) EnqueueJob(jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error) {
+// SetPayloadValidator sets the validator run when jobs of jobType are
+// enqueued, replacing any previous one. Pass nil to enqueue them unchecked.
+func (jq *JobQueueService) SetPayloadValidator(jobType JobType, validator PayloadValidator) {
+	if validator == nil {
+		delete(jq.validators, jobType)
+		return
+	}
+	jq.validators[jobType] = validator
+}
+
+// SetMaxPendingJobs caps the number of pending jobs. Once the cap is reached,
+// enqueueing fails with ErrQueueFull until workers claim some jobs. 0 removes
+// the cap. Call it before the service is shared between goroutines.
+func (jq *JobQueueService) SetMaxPendingJobs(max int) {
+	jq.maxPending = int64(max)
+}
+
+// EnqueueJob stores a job that is due at once. priority must be between
+// MinPriority and MaxPriority, and payload must pass jobType's validator. If
+// payload has a DedupKey and a job with that key is still pending or
+// processing, that job is returned and nothing is stored. Subscribers are
+// notified of the job (see Subscribe).
+func (jq *JobQueueService) EnqueueJob(ctx context.Context, jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error) {
+	job, err := jq.enqueue(ctx, jq.queries, jobType, payload, priority)
+	if err == nil {
+		jq.notifySubscribers()
+	}
+	return job, err
+}
+
+// EnqueueJobTx enqueues a job as part of tx, so the job is only visible to
+// workers once tx commits and disappears if it rolls back.
+func (jq *JobQueueService) EnqueueJobTx(ctx context.Context, tx *sql.Tx, jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error) {
+	return jq.enqueue(ctx, jq.queries.WithTx(tx), jobType, payload, priority)
+}
+
+// JobSpec describes one job for EnqueueJobs
+type JobSpec struct {
+	Type     JobType
+	Payload  JobPayload
+	Priority int
+}
+
+// EnqueueJobs enqueues specs in a single transaction and returns the created
+// jobs in the same order. If any spec is invalid, an insert fails or ctx is
+// cancelled before the batch commits, no job is enqueued.
+func (jq *JobQueueService) EnqueueJobs(ctx context.Context, specs []JobSpec) ([]db.JobQueue, error) {
+	if len(specs) == 0 {
+		return []db.JobQueue{}, nil
+	}
+
+	tx, err := jq.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := jq.queries.WithTx(tx)
+	created := make([]db.JobQueue, 0, len(specs))
+	for i, spec := range specs {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("batch interrupted before job %d: %w", i, err)
+		}
+		job, err := jq.enqueue(ctx, queries, spec.Type, spec.Payload, spec.Priority)
+		if err != nil {
+			return nil, fmt.Errorf("job %d: %w", i, err)
+		}
+		created = append(created, *job)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	jq.notifySubscribers()
+
+	return created, nil
+}
+
+// validateJob checks priority and runs jobType's validator from validators,
+// if it has one, on payload
+func validateJob(validators map[JobType]PayloadValidator, jobType JobType, payload JobPayload, priority int) error {
+	if err := ValidatePriority(priority); err != nil {
+		return err
+	}
+	if validate, ok := validators[jobType]; ok {
+		if err := validate(payload); err != nil {
+			return fmt.Errorf("invalid %s payload: %w", jobType, err)
+		}
+	}
+	return nil
+}
+
+func (jq *JobQueueService) enqueue(ctx context.Context, queries *db.Queries, jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error) {
+	if err := validateJob(jq.validators, jobType, payload, priority); err != nil {
+		return nil, err
+	}
+
+	// A duplicate is not a new job, so it is returned even at the cap
+	if existing, err := jq.unhandledJob(ctx, queries, payload.DedupKey); existing != nil || err != nil {
+		return existing, err
+	}
+
+	if jq.maxPending > 0 {
+		pending, err := queries.CountPendingJobs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count pending jobs: %w", err)
+		}
+		if pending >= jq.maxPending {
+			return nil, ErrQueueFull
+		}
+	}
+
 	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	job, err := jq.queries.CreateJob(context.Background(), db.CreateJobParams{
+	job, err := queries.CreateJob(ctx, db.CreateJobParams{
 		JobType:     string(jobType),
 		Payload:     string(payloadJSON),
 		Priority:    sql.NullInt64{Int64: int64(priority), Valid: true},
 		MaxRetries:  sql.NullInt64{Int64: 3, Valid: true},
-		ScheduledAt: sql.NullTime{Time: time.Now(), Valid: true},
+		ScheduledAt: jq.nowParam(),
+		RequestID:   sql.NullString{String: payload.RequestID, Valid: payload.RequestID != ""},
+		DedupKey:    sql.NullString{String: payload.DedupKey, Valid: payload.DedupKey != ""},
 	})
+	if errors.Is(err, sql.ErrNoRows) && payload.DedupKey != "" {
+		// A job with the key was enqueued since the lookup above
+		existing, err := jq.unhandledJob(ctx, queries, payload.DedupKey)
+		if err == nil && existing == nil {
+			err = fmt.Errorf("job with dedup key %q conflicted but was not found", payload.DedupKey)
+		}
+		return existing, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create job: %w", err)
 	}
@@ -62,8 +306,34 @@ func (jq *JobQueueService) EnqueueJob(jobType JobType, payload JobPayload, prior
 	return &job, nil
 }
 
-func (jq *JobQueueService) GetNextJob() (*db.JobQueue, error) {
-	job, err := jq.queries.GetNextPendingJob(context.Background())
+// unhandledJob returns the pending or processing job with dedupKey, or nil if
+// there is none or dedupKey is empty
+func (jq *JobQueueService) unhandledJob(ctx context.Context, queries *db.Queries, dedupKey string) (*db.JobQueue, error) {
+	if dedupKey == "" {
+		return nil, nil
+	}
+	job, err := queries.GetUnhandledJobByDedupKey(ctx, sql.NullString{String: dedupKey, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up job by dedup key: %w", err)
+	}
+	return &job, nil
+}
+
+// GetNextJob claims the next pending job, as GetNextJobs does, and returns
+// nil when no job is due
+func (jq *JobQueueService) GetNextJob(ctx context.Context) (*db.JobQueue, error) {
+	if jq.fairScheduler != nil || jq.concurrency != nil {
+		jobs, err := jq.GetNextJobs(ctx, 1)
+		if err != nil || len(jobs) == 0 {
+			return nil, err
+		}
+		return &jobs[0], nil
+	}
+
+	job, err := jq.queries.GetNextPendingJob(ctx, jq.nowParam())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No jobs available
@@ -72,66 +342,535 @@ func (jq *JobQueueService) GetNextJob() (*db.JobQueue, error) {
 	}
 
 	// Mark job as processing
-	_, err = jq.queries.UpdateJobStatus(context.Background(), db.UpdateJobStatusParams{
+	_, err = jq.queries.UpdateJobStatus(ctx, db.UpdateJobStatusParams{
 		ID:          job.ID,
 		Status:      "processing",
-		StartedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+		StartedAt:   jq.nowParam(),
 		CompletedAt: sql.NullTime{Valid: false},
 		ErrorMessage: sql.NullString{Valid: false},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to update job status: %w", err)
 	}
+	// Clear any worker left from an earlier attempt
+	err = jq.queries.SetJobWorker(ctx, db.SetJobWorkerParams{ID: job.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record job worker: %w", err)
+	}
 
 	job.Status = "processing"
+	job.WorkerID = sql.NullInt64{}
 	return &job, nil
 }
 
-func (jq *JobQueueService) CompleteJob(jobID int64) error {
-	_, err := jq.queries.UpdateJobStatus(context.Background(), db.UpdateJobStatusParams{
+// GetNextJobs claims up to limit pending jobs in claim order (or fair order,
+// see SetFairScheduling), skipping types at their concurrency limit (see
+// SetConcurrencyLimits), and marks them as processing in a single
+// transaction. An empty queue yields an empty, non-nil slice and no error.
+func (jq *JobQueueService) GetNextJobs(ctx context.Context, limit int) ([]db.JobQueue, error) {
+	return jq.GetNextJobsForWorker(ctx, 0, limit)
+}
+
+// GetNextJobsForWorker is GetNextJobs for the worker with workerID, which
+// must send heartbeats (see Heartbeat). The claimed jobs record the worker,
+// so ReapJobsOfDeadWorkers can reclaim them if it dies. A workerID of 0
+// records no worker.
+func (jq *JobQueueService) GetNextJobsForWorker(ctx context.Context, workerID int, limit int) ([]db.JobQueue, error) {
+	if limit <= 0 {
+		return []db.JobQueue{}, nil
+	}
+
+	tx, err := jq.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := jq.queries.WithTx(tx)
+	var jobs []db.JobQueue
+	if jq.fairScheduler != nil || jq.concurrency != nil {
+		jobs, err = jq.getNextPendingJobsByType(ctx, queries, limit)
+	} else {
+		jobs, err = queries.GetNextPendingJobs(ctx, db.GetNextPendingJobsParams{
+			Now:   jq.nowParam(),
+			Limit: int64(limit),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next jobs: %w", err)
+	}
+	if len(jobs) == 0 {
+		return []db.JobQueue{}, nil
+	}
+
+	startedAt := jq.now()
+	for i := range jobs {
+		_, err := queries.UpdateJobStatus(ctx, db.UpdateJobStatusParams{
+			ID:           jobs[i].ID,
+			Status:       "processing",
+			StartedAt:    sql.NullTime{Time: startedAt, Valid: true},
+			CompletedAt:  sql.NullTime{Valid: false},
+			ErrorMessage: sql.NullString{Valid: false},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update job status: %w", err)
+		}
+		err = queries.SetJobWorker(ctx, db.SetJobWorkerParams{
+			WorkerID: sql.NullInt64{Int64: int64(workerID), Valid: workerID > 0},
+			ID:       jobs[i].ID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to record job worker: %w", err)
+		}
+		jobs[i].Status = "processing"
+		jobs[i].WorkerID = sql.NullInt64{Int64: int64(workerID), Valid: workerID > 0}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// CompleteJob marks a job as completed
+func (jq *JobQueueService) CompleteJob(ctx context.Context, jobID int64) error {
+	_, err := jq.queries.UpdateJobStatus(ctx, db.UpdateJobStatusParams{
 		ID:          jobID,
 		Status:      "completed",
 		StartedAt:   sql.NullTime{Valid: false}, // Keep existing value
-		CompletedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		CompletedAt: jq.nowParam(),
 		ErrorMessage: sql.NullString{Valid: false},
 	})
 	return err
 }
 
-func (jq *JobQueueService) FailJob(jobID int64, errorMessage string, retry bool) error {
+// FailJob records a failed attempt. With retry the job goes back to pending,
+// due after 5 minutes per retry so far; otherwise it is marked failed.
+func (jq *JobQueueService) FailJob(ctx context.Context, jobID int64, errorMessage string, retry bool) error {
 	if retry {
-		_, err := jq.queries.IncrementJobRetry(context.Background(), db.IncrementJobRetryParams{
+		_, err := jq.queries.IncrementJobRetry(ctx, db.IncrementJobRetryParams{
+			Now:          jq.now().Format(sqliteTimestampFormat),
 			ID:           jobID,
 			ErrorMessage: sql.NullString{String: errorMessage, Valid: true},
 		})
 		return err
 	} else {
-		_, err := jq.queries.UpdateJobStatus(context.Background(), db.UpdateJobStatusParams{
+		_, err := jq.queries.UpdateJobStatus(ctx, db.UpdateJobStatusParams{
 			ID:           jobID,
 			Status:       "failed",
 			StartedAt:    sql.NullTime{Valid: false},
-			CompletedAt:  sql.NullTime{Time: time.Now(), Valid: true},
+			CompletedAt:  jq.nowParam(),
 			ErrorMessage: sql.NullString{String: errorMessage, Valid: true},
 		})
 		return err
 	}
 }
 
-func (jq *JobQueueService) GetJobStats() (*db.GetJobStatsRow, error) {
-	stats, err := jq.queries.GetJobStats(context.Background())
+// DeferJob returns a processing job to pending, due again after delay, with
+// reason as its error. Unlike FailJob it doesn't use up a retry, so it suits
+// jobs that could not be attempted at all, e.g. because no processor for
+// their type is registered yet.
+func (jq *JobQueueService) DeferJob(ctx context.Context, jobID int64, reason string, delay time.Duration) error {
+	deferred, err := jq.queries.DeferJob(ctx, db.DeferJobParams{
+		ScheduledAt:  sql.NullTime{Time: jq.now().Add(delay), Valid: true},
+		ErrorMessage: sql.NullString{String: reason, Valid: true},
+		ID:           jobID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to defer job: %w", err)
+	}
+	if deferred == 0 {
+		return fmt.Errorf("cannot defer job, it is not processing")
+	}
+	return nil
+}
+
+// CancelJob marks a pending job as cancelled so no worker claims it. Jobs that
+// are already processing or finished can't be cancelled.
+func (jq *JobQueueService) CancelJob(ctx context.Context, jobID int64) error {
+	cancelled, err := jq.queries.CancelJob(ctx, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel job: %w", err)
+	}
+	if cancelled > 0 {
+		return nil
+	}
+
+	job, err := jq.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("cannot cancel %s job, only pending jobs can be cancelled", job.Status)
+}
+
+// RequeueJob moves a failed job back to pending so workers run it again,
+// clearing its error and timestamps. With resetRetries the job gets its full
+// retry budget back; otherwise it keeps its retry count but is granted at
+// least one more attempt. Only failed jobs can be requeued.
+func (jq *JobQueueService) RequeueJob(ctx context.Context, jobID int64, resetRetries bool) error {
+	job, err := jq.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status != "failed" {
+		return fmt.Errorf("cannot requeue %s job, only failed jobs can be requeued", job.Status)
+	}
+
+	var retryCount int64
+	if !resetRetries {
+		retryCount = job.RetryCount.Int64
+		if maxRetries := job.MaxRetries.Int64; retryCount >= maxRetries {
+			retryCount = max(maxRetries-1, 0)
+		}
+	}
+
+	requeued, err := jq.queries.RequeueJob(ctx, db.RequeueJobParams{
+		RetryCount:  sql.NullInt64{Int64: retryCount, Valid: true},
+		ScheduledAt: jq.nowParam(),
+		ID:          jobID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	if requeued == 0 {
+		// Changed by someone else since it was read
+		return fmt.Errorf("cannot requeue job, it is no longer failed")
+	}
+	return nil
+}
+
+// ReapStaleJobs recovers jobs left processing by a worker that crashed or
+// was killed mid-job: any job that started more than olderThan ago and is
+// still processing counts as a failed attempt. It goes back to pending to be
+// retried straight away, or is marked failed if that was its last retry. It
+// returns how many jobs were reaped.
+//
+// olderThan must be longer than any job's timeout, or jobs still running are
+// run twice.
+func (jq *JobQueueService) ReapStaleJobs(ctx context.Context, olderThan time.Duration) (int64, error) {
+	now := jq.now()
+	reaped, err := jq.queries.ReapStaleJobs(ctx, db.ReapStaleJobsParams{
+		Now:           sql.NullTime{Time: now, Valid: true},
+		ErrorMessage:  sql.NullString{String: fmt.Sprintf("job stalled: still processing after %s", olderThan), Valid: true},
+		StartedBefore: sql.NullTime{Time: now.Add(-olderThan), Valid: true},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap stale jobs: %w", err)
+	}
+	return reaped, nil
+}
+
+// ReplayDeadJobs moves every dead-letter job (a failed job, one whose retries
+// ran out or that failed permanently) back to pending with its full retry
+// budget, as RequeueJob does with resetRetries. An empty jobType replays all
+// types. It returns how many jobs were replayed.
+func (jq *JobQueueService) ReplayDeadJobs(ctx context.Context, jobType JobType) (int64, error) {
+	replayed, err := jq.queries.ReplayFailedJobs(ctx, db.ReplayFailedJobsParams{
+		Now:     jq.nowParam(),
+		JobType: string(jobType),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to replay dead-letter jobs: %w", err)
+	}
+	return replayed, nil
+}
+
+// queuePausedSetting is the queue_settings row behind PauseQueue. The claim
+// queries check it themselves, so a pause applies to every process at once.
+const queuePausedSetting = "paused"
+
+// PauseQueue stops all job claiming, e.g. for a maintenance window. Jobs can
+// still be enqueued, and jobs already claimed run to completion. The pause is
+// stored in the database, so it applies to every worker process, including
+// ones started later, until ResumeQueue is called.
+func (jq *JobQueueService) PauseQueue(ctx context.Context) error {
+	return jq.setQueuePaused(ctx, true)
+}
+
+// ResumeQueue lets workers claim jobs again after PauseQueue
+func (jq *JobQueueService) ResumeQueue(ctx context.Context) error {
+	return jq.setQueuePaused(ctx, false)
+}
+
+func (jq *JobQueueService) setQueuePaused(ctx context.Context, paused bool) error {
+	err := jq.queries.UpsertQueueSetting(ctx, db.UpsertQueueSettingParams{
+		Name:  queuePausedSetting,
+		Value: strconv.FormatBool(paused),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update queue pause: %w", err)
+	}
+	return nil
+}
+
+// IsQueuePaused reports whether the queue has been paused with PauseQueue
+func (jq *JobQueueService) IsQueuePaused(ctx context.Context) (bool, error) {
+	setting, err := jq.queries.GetQueueSetting(ctx, queuePausedSetting)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get queue pause: %w", err)
+	}
+	return setting.Value == "true", nil
+}
+
+// Ping checks that the job_queue table can be queried.
+func (jq *JobQueueService) Ping(ctx context.Context) error {
+	if err := jq.queries.CheckJobQueue(ctx); err != nil {
+		return fmt.Errorf("failed to query job queue: %w", err)
+	}
+	return nil
+}
+
+// PendingBacklog returns the number of pending jobs and how long the oldest
+// one that is due has been waiting past its scheduled time (0 if none is due).
+func (jq *JobQueueService) PendingBacklog(ctx context.Context) (pending int64, oldestWait time.Duration, err error) {
+	pending, err = jq.queries.CountPendingJobs(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count pending jobs: %w", err)
+	}
+
+	now := jq.now()
+	oldest, err := jq.queries.GetOldestDuePendingJob(ctx, sql.NullTime{Time: now, Valid: true})
+	if err == sql.ErrNoRows {
+		return pending, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get oldest pending job: %w", err)
+	}
+	if oldest.ScheduledAt.Valid {
+		oldestWait = max(now.Sub(oldest.ScheduledAt.Time), 0)
+	}
+	return pending, oldestWait, nil
+}
+
+// GetJobStats returns the number of jobs per status, from the cache when one
+// is set up with SetStatsCacheTTL.
+func (jq *JobQueueService) GetJobStats(ctx context.Context) (*db.GetJobStatsRow, error) {
+	if jq.statsCache != nil {
+		return jq.statsCache.get(ctx)
+	}
+	return jq.fetchJobStats(ctx)
+}
+
+func (jq *JobQueueService) fetchJobStats(ctx context.Context) (*db.GetJobStatsRow, error) {
+	stats, err := jq.queries.GetJobStats(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get job stats: %w", err)
 	}
 	return &stats, nil
 }
 
-func (jq *JobQueueService) ListJobs(status string, limit int) ([]db.JobQueue, error) {
-	jobs, err := jq.queries.ListJobs(context.Background(), db.ListJobsParams{
-		Status: status,
-		Limit:  int64(limit),
+// JobTypeStats counts the jobs of one type per status
+type JobTypeStats struct {
+	Pending    int64 `json:"pending"`
+	Processing int64 `json:"processing"`
+	Completed  int64 `json:"completed"`
+	Failed     int64 `json:"failed"`
+	Cancelled  int64 `json:"cancelled"`
+}
+
+// GetJobStatsByType returns the number of jobs per status for each job type
+// in the queue, keyed by type. Types with no jobs are left out. Unlike
+// GetJobStats it always reads the database.
+func (jq *JobQueueService) GetJobStatsByType(ctx context.Context) (map[string]JobTypeStats, error) {
+	rows, err := jq.queries.GetJobStatsByType(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job stats by type: %w", err)
+	}
+
+	stats := make(map[string]JobTypeStats, len(rows))
+	for _, row := range rows {
+		stats[row.JobType] = JobTypeStats{
+			Pending:    row.PendingCount,
+			Processing: row.ProcessingCount,
+			Completed:  row.CompletedCount,
+			Failed:     row.FailedCount,
+			Cancelled:  row.CancelledCount,
+		}
+	}
+	return stats, nil
+}
+
+// RecordJobDuration stores how long the processor took to run the job.
+func (jq *JobQueueService) RecordJobDuration(ctx context.Context, jobID int64, d time.Duration) error {
+	err := jq.queries.UpdateJobDuration(ctx, db.UpdateJobDurationParams{
+		ID:         jobID,
+		DurationMs: sql.NullInt64{Int64: d.Milliseconds(), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record job duration: %w", err)
+	}
+	return nil
+}
+
+// GetJobDurationStats returns the average processing duration per job type,
+// over the jobs that have a recorded duration.
+func (jq *JobQueueService) GetJobDurationStats(ctx context.Context) ([]db.GetJobDurationStatsRow, error) {
+	stats, err := jq.queries.GetJobDurationStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job duration stats: %w", err)
+	}
+	return stats, nil
+}
+
+// GetJobsForUser returns every job whose payload user_id matches userID,
+// oldest first, e.g. to check whether a user's signup job has run.
+func (jq *JobQueueService) GetJobsForUser(ctx context.Context, userID int64) ([]db.JobQueue, error) {
+	jobs, err := jq.queries.GetJobsForUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs for user: %w", err)
+	}
+	return jobs, nil
+}
+
+// GetJob returns the job with the given ID
+func (jq *JobQueueService) GetJob(ctx context.Context, jobID int64) (*db.JobQueue, error) {
+	job, err := jq.queries.GetJobByID(ctx, jobID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return &job, nil
+}
+
+// GetJobStatuses returns the status and error of each job in ids, in ID
+// order. IDs that don't exist are left out.
+func (jq *JobQueueService) GetJobStatuses(ctx context.Context, ids []int64) ([]db.GetJobStatusesRow, error) {
+	if len(ids) == 0 {
+		return []db.GetJobStatusesRow{}, nil
+	}
+
+	statuses, err := jq.queries.GetJobStatuses(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+// ListJobs returns up to limit jobs in status, newest first
+func (jq *JobQueueService) ListJobs(ctx context.Context, status string, limit int) ([]db.JobQueue, error) {
+	return jq.listJobs(ctx, status, "", limit)
+}
+
+// ListJobsFiltered returns up to limit jobs in status and of jobType, newest
+// first. An empty status or jobType matches any.
+func (jq *JobQueueService) ListJobsFiltered(ctx context.Context, status, jobType string, limit int) ([]db.JobQueue, error) {
+	return jq.listJobs(ctx, status, jobType, limit)
+}
+
+func (jq *JobQueueService) listJobs(ctx context.Context, status, jobType string, limit int) ([]db.JobQueue, error) {
+	jobs, err := jq.queries.ListJobs(ctx, db.ListJobsParams{
+		Status:  status,
+		JobType: jobType,
+		Limit:   int64(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// ListJobsPaginated returns up to limit jobs in status with an ID above
+// afterID, oldest first. Pass 0 for the first page and the last job's ID for
+// the next; since pages are ordered by ID, jobs enqueued in between don't
+// shift them.
+func (jq *JobQueueService) ListJobsPaginated(ctx context.Context, status string, limit int, afterID int64) ([]db.JobQueue, error) {
+	return jq.ListJobsFilteredPaginated(ctx, status, "", limit, afterID)
+}
+
+// ListJobsFilteredPaginated is ListJobsPaginated filtered like
+// ListJobsFiltered
+func (jq *JobQueueService) ListJobsFilteredPaginated(ctx context.Context, status, jobType string, limit int, afterID int64) ([]db.JobQueue, error) {
+	jobs, err := jq.queries.ListJobsAfterID(ctx, db.ListJobsAfterIDParams{
+		Status:  status,
+		JobType: jobType,
+		AfterID: afterID,
+		Limit:   int64(limit),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list jobs: %w", err)
 	}
 	return jobs, nil
-}
\ No newline at end of file
+}
+
+// ListPendingJobsInClaimOrder returns up to limit pending jobs of jobType in
+// the order GetNextJob claims them (see SortByClaimOrder), so the first page
+// shows the jobs that run next. An empty jobType matches any.
+func (jq *JobQueueService) ListPendingJobsInClaimOrder(ctx context.Context, jobType string, limit int) ([]db.JobQueue, error) {
+	jobs, err := jq.queries.ListPendingJobsInClaimOrder(ctx, db.ListPendingJobsInClaimOrderParams{
+		JobType: jobType,
+		Limit:   int64(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// SetPriorityForStatus sets the priority of every job of jobType in status
+// and returns how many jobs changed. Only pending jobs can be reprioritized,
+// since jobs in any other status are no longer waiting to be claimed.
+func (jq *JobQueueService) SetPriorityForStatus(ctx context.Context, jobType JobType, status string, priority int) (int64, error) {
+	if err := ValidatePriority(priority); err != nil {
+		return 0, err
+	}
+	if status != "pending" {
+		return 0, fmt.Errorf("cannot reprioritize jobs with status '%s': only pending jobs can be reprioritized", status)
+	}
+
+	updated, err := jq.queries.SetJobPriorityForStatus(ctx, db.SetJobPriorityForStatusParams{
+		Priority: sql.NullInt64{Int64: int64(priority), Valid: true},
+		JobType:  string(jobType),
+		Status:   status,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to set job priority: %w", err)
+	}
+	return updated, nil
+}
+
+// MigrateJobType moves pending jobs of a renamed type to its new name, so the
+// processor registered under newType picks them up instead of workers
+// failing them for having no processor. Jobs in other statuses keep the type
+// they ran under. It returns how many jobs were migrated.
+func (jq *JobQueueService) MigrateJobType(ctx context.Context, oldType, newType JobType) (int64, error) {
+	if oldType == "" || newType == "" {
+		return 0, fmt.Errorf("job types must not be empty")
+	}
+	if oldType == newType {
+		return 0, fmt.Errorf("old and new job type are both '%s'", oldType)
+	}
+
+	migrated, err := jq.queries.RenamePendingJobType(ctx, db.RenamePendingJobTypeParams{
+		NewType: string(newType),
+		OldType: string(oldType),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate job type: %w", err)
+	}
+	return migrated, nil
+}
+
+// SortByClaimOrder orders jobs the way GetNextJob claims them: highest
+// priority first, then earliest scheduled_at, then lowest ID. Jobs
+// without a priority sort after any job that has one, as in SQLite.
+func SortByClaimOrder(jobs []db.JobQueue) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		a, b := jobs[i], jobs[j]
+		if a.Priority.Valid != b.Priority.Valid {
+			return a.Priority.Valid
+		}
+		if a.Priority.Int64 != b.Priority.Int64 {
+			return a.Priority.Int64 > b.Priority.Int64
+		}
+		if !a.ScheduledAt.Time.Equal(b.ScheduledAt.Time) {
+			return a.ScheduledAt.Time.Before(b.ScheduledAt.Time)
+		}
+		return a.ID < b.ID
+	})
+}
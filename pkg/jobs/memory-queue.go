@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"openapi-validation-example/db"
+)
+
+// MemoryJobQueue is a JobQueue kept in memory, for tests that don't need a
+// database. It follows JobQueueService: jobs are claimed in claim order once
+// due, failed jobs are retried after 5 minutes per retry, payloads are
+// validated and dedup keys honored. Jobs are lost when the process exits, so
+// it is no substitute for JobQueueService outside tests. Its methods fail
+// with ctx's error once ctx is done, as the database calls would.
+type MemoryJobQueue struct {
+	mu         sync.Mutex
+	jobs       []db.JobQueue
+	nextID     int64
+	validators map[JobType]PayloadValidator
+	clock      Clock
+}
+
+// NewMemoryJobQueue creates an empty in-memory job queue with the default
+// payload validators
+func NewMemoryJobQueue() *MemoryJobQueue {
+	return &MemoryJobQueue{
+		nextID:     1,
+		validators: DefaultPayloadValidators(),
+		clock:      SystemClock{},
+	}
+}
+
+// SetClock replaces the clock used for scheduling, like
+// JobQueueService.SetClock
+func (mq *MemoryJobQueue) SetClock(clock Clock) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mq.clock = clock
+}
+
+func (mq *MemoryJobQueue) now() time.Time {
+	return mq.clock.Now().UTC()
+}
+
+// EnqueueJob stores a job that is due at once, like JobQueueService.EnqueueJob
+func (mq *MemoryJobQueue) EnqueueJob(ctx context.Context, jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateJob(mq.validators, jobType, payload, priority); err != nil {
+		return nil, err
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	if payload.DedupKey != "" {
+		for _, job := range mq.jobs {
+			if job.DedupKey.String == payload.DedupKey && (job.Status == "pending" || job.Status == "processing") {
+				return &job, nil
+			}
+		}
+	}
+
+	now := sql.NullTime{Time: mq.now(), Valid: true}
+	job := db.JobQueue{
+		ID:          mq.nextID,
+		JobType:     string(jobType),
+		Payload:     string(payloadJSON),
+		Status:      "pending",
+		Priority:    sql.NullInt64{Int64: int64(priority), Valid: true},
+		MaxRetries:  sql.NullInt64{Int64: 3, Valid: true},
+		RetryCount:  sql.NullInt64{Int64: 0, Valid: true},
+		ScheduledAt: now,
+		CreatedAt:   now,
+		RequestID:   sql.NullString{String: payload.RequestID, Valid: payload.RequestID != ""},
+		DedupKey:    sql.NullString{String: payload.DedupKey, Valid: payload.DedupKey != ""},
+	}
+	mq.nextID++
+	mq.jobs = append(mq.jobs, job)
+
+	return &job, nil
+}
+
+// GetNextJob claims the next due pending job in claim order and marks it as
+// processing. It returns nil when no job is due.
+func (mq *MemoryJobQueue) GetNextJob(ctx context.Context) (*db.JobQueue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	now := mq.now()
+	var due []db.JobQueue
+	for _, job := range mq.jobs {
+		if job.Status == "pending" && !job.ScheduledAt.Time.After(now) {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	SortByClaimOrder(due)
+
+	job := mq.find(due[0].ID)
+	job.Status = "processing"
+	job.StartedAt = sql.NullTime{Time: now, Valid: true}
+	job.CompletedAt = sql.NullTime{}
+	job.ErrorMessage = sql.NullString{}
+
+	claimed := *job
+	return &claimed, nil
+}
+
+// CompleteJob marks a job as completed
+func (mq *MemoryJobQueue) CompleteJob(ctx context.Context, jobID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	job := mq.find(jobID)
+	if job == nil {
+		return ErrJobNotFound
+	}
+	job.Status = "completed"
+	job.CompletedAt = sql.NullTime{Time: mq.now(), Valid: true}
+	job.ErrorMessage = sql.NullString{}
+	return nil
+}
+
+// FailJob records a failed attempt. With retry the job goes back to pending,
+// due after 5 minutes per retry so far; otherwise it is marked failed.
+func (mq *MemoryJobQueue) FailJob(ctx context.Context, jobID int64, errorMessage string, retry bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	job := mq.find(jobID)
+	if job == nil {
+		return ErrJobNotFound
+	}
+	now := mq.now()
+	job.ErrorMessage = sql.NullString{String: errorMessage, Valid: true}
+	if retry {
+		job.RetryCount.Int64++
+		job.Status = "pending"
+		job.ScheduledAt = sql.NullTime{Time: now.Add(time.Duration(job.RetryCount.Int64) * 5 * time.Minute), Valid: true}
+		return nil
+	}
+	job.Status = "failed"
+	job.CompletedAt = sql.NullTime{Time: now, Valid: true}
+	return nil
+}
+
+// GetJobStats returns the number of jobs per status
+func (mq *MemoryJobQueue) GetJobStats(ctx context.Context) (*db.GetJobStatsRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	var stats db.GetJobStatsRow
+	for _, job := range mq.jobs {
+		switch job.Status {
+		case "pending":
+			stats.PendingCount++
+		case "processing":
+			stats.ProcessingCount++
+		case "completed":
+			stats.CompletedCount++
+		case "failed":
+			stats.FailedCount++
+		case "cancelled":
+			stats.CancelledCount++
+		}
+	}
+	return &stats, nil
+}
+
+// ListJobs returns up to limit jobs in status, newest first. An empty status
+// matches any.
+func (mq *MemoryJobQueue) ListJobs(ctx context.Context, status string, limit int) ([]db.JobQueue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	jobs := []db.JobQueue{}
+	for _, job := range mq.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].ID > jobs[j].ID
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// find returns the stored job with jobID, or nil. mq.mu must be held.
+func (mq *MemoryJobQueue) find(jobID int64) *db.JobQueue {
+	for i := range mq.jobs {
+		if mq.jobs[i].ID == jobID {
+			return &mq.jobs[i]
+		}
+	}
+	return nil
+}
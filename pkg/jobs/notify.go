@@ -0,0 +1,34 @@
+package jobs
+
+// Subscribe returns a channel that receives a value whenever this
+// JobQueueService enqueues a job, so an idle worker can claim it at once
+// instead of waiting for its next poll. Notifications are coalesced: the
+// channel holds at most one, so a burst of enqueues wakes a subscriber once.
+//
+// It only works within a single process. Jobs enqueued by another process,
+// such as the server or worker-manager, are only seen by polling, which
+// workers keep doing. EnqueueJobTx does not notify either, as its job is not
+// visible until the caller commits.
+func (jq *JobQueueService) Subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	jq.subscribersMu.Lock()
+	jq.subscribers = append(jq.subscribers, ch)
+	jq.subscribersMu.Unlock()
+
+	return ch
+}
+
+// notifySubscribers signals every subscriber without blocking
+func (jq *JobQueueService) notifySubscribers() {
+	jq.subscribersMu.Lock()
+	defer jq.subscribersMu.Unlock()
+
+	for _, ch := range jq.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A notification is already pending
+		}
+	}
+}
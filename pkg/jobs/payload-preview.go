@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultPreviewRawLength is how much of a raw payload PayloadPreview shows in
+// job listings
+const DefaultPreviewRawLength = 200
+
+// PayloadPreview summarizes a stored job payload as display lines such as
+// "User ID: 42". When the payload does not decode as a JobPayload (malformed
+// JSON, a field of an unexpected type, a number that does not fit) or has
+// nothing to summarize, it returns the raw payload instead, cut to maxRaw
+// bytes (0 shows all of it), so a listing never hides or misrepresents a job.
+func PayloadPreview(raw string, maxRaw int) []string {
+	var payload JobPayload
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return []string{"Payload (raw): " + RawPayload(raw, maxRaw)}
+	}
+
+	var lines []string
+	if payload.UserID != nil {
+		lines = append(lines, fmt.Sprintf("User ID: %d", *payload.UserID))
+	}
+	if payload.Message != "" {
+		lines = append(lines, "Message: "+payload.Message)
+	}
+	if len(payload.Recipients) > 0 {
+		lines = append(lines, fmt.Sprintf("Recipients: %d", len(payload.Recipients)))
+	}
+	if len(lines) == 0 {
+		return []string{"Payload (raw): " + RawPayload(raw, maxRaw)}
+	}
+	return lines
+}
+
+// FormatPayload indents a stored payload for display, falling back to the raw
+// payload when it is not valid JSON.
+func FormatPayload(raw string) string {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(raw), "", "  "); err != nil {
+		return RawPayload(raw, 0)
+	}
+	return indented.String()
+}
+
+// RawPayload renders payload bytes for a terminal, cut to maxRaw bytes when
+// maxRaw > 0. Payloads with invalid UTF-8 or control characters are quoted
+// with Go escapes so they can't garble the output.
+func RawPayload(raw string, maxRaw int) string {
+	truncated := false
+	if maxRaw > 0 && len(raw) > maxRaw {
+		// Don't split a multi-byte character
+		for maxRaw > 0 && !utf8.RuneStart(raw[maxRaw]) {
+			maxRaw--
+		}
+		raw = raw[:maxRaw]
+		truncated = true
+	}
+
+	if !utf8.ValidString(raw) || containsControl(raw) {
+		raw = strconv.Quote(raw)
+	}
+	if truncated {
+		raw += "..."
+	}
+	return raw
+}
+
+func containsControl(s string) bool {
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
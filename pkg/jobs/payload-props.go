@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// StringProp returns the additional prop key if it is a string. ok is false
+// when the prop is missing or of another type.
+func (p JobPayload) StringProp(key string) (value string, ok bool) {
+	value, ok = p.AdditionalProps[key].(string)
+	return value, ok
+}
+
+// FloatProp returns the additional prop key if it is a number. A payload
+// decoded from the queue holds every JSON number as a float64, even 42, so
+// this is the accessor for numbers of any kind; Go integer types set before
+// the payload was stored are converted too. Numeric strings such as "42" are
+// not numbers.
+func (p JobPayload) FloatProp(key string) (float64, bool) {
+	switch value := p.AdditionalProps[key].(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case json.Number:
+		f, err := value.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// IntProp returns the additional prop key if it is a whole number that fits
+// in an int64, e.g. 42 but not 4.2
+func (p JobPayload) IntProp(key string) (int64, bool) {
+	f, ok := p.FloatProp(key)
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// BoolProp returns the additional prop key if it is a boolean
+func (p JobPayload) BoolProp(key string) (value bool, ok bool) {
+	value, ok = p.AdditionalProps[key].(bool)
+	return value, ok
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"openapi-validation-example/db"
+)
+
+// Queue is what a worker needs from a job queue: claiming jobs, finishing
+// them and reporting the job counts. Workers take a Queue, so they run on any
+// backend and can be tested against a mock. Every method gives up once ctx is
+// done.
+type Queue interface {
+	GetNextJob(ctx context.Context) (*db.JobQueue, error)
+	CompleteJob(ctx context.Context, jobID int64) error
+	FailJob(ctx context.Context, jobID int64, errorMessage string, retry bool) error
+	GetJobStats(ctx context.Context) (*db.GetJobStatsRow, error)
+}
+
+// JobQueue is the core of a job queue: a Queue that jobs can also be
+// enqueued on and listed from. JobQueueService implements it on SQLite and
+// MemoryJobQueue in memory, for tests; other backends, such as Redis, can be
+// swapped in wherever a JobQueue is accepted.
+type JobQueue interface {
+	Queue
+	EnqueueJob(ctx context.Context, jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error)
+	ListJobs(ctx context.Context, status string, limit int) ([]db.JobQueue, error)
+}
+
+// TxEnqueuer is implemented by queues kept in the application's SQL
+// database, such as JobQueueService, so a job can be committed in the same
+// transaction as the change it reacts to
+type TxEnqueuer interface {
+	EnqueueJobTx(ctx context.Context, tx *sql.Tx, jobType JobType, payload JobPayload, priority int) (*db.JobQueue, error)
+}
+
+// StatsCacher is implemented by queues that can serve GetJobStats from a
+// cache refreshed in the background, such as JobQueueService
+type StatsCacher interface {
+	SetStatsCacheTTL(ttl time.Duration)
+	RunStatsRefresh(done <-chan struct{})
+}
+
+// PendingLimiter is implemented by queues that can cap their pending jobs,
+// such as JobQueueService
+type PendingLimiter interface {
+	SetMaxPendingJobs(max int)
+}
+
+var (
+	_ JobQueue       = (*JobQueueService)(nil)
+	_ JobQueue       = (*MemoryJobQueue)(nil)
+	_ TxEnqueuer     = (*JobQueueService)(nil)
+	_ StatsCacher    = (*JobQueueService)(nil)
+	_ PendingLimiter = (*JobQueueService)(nil)
+)
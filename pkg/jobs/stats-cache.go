@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"openapi-validation-example/db"
+)
+
+// statsCache holds the last GetJobStats result for a TTL. Stale reads refresh
+// it synchronously, with concurrent readers sharing a single query.
+type statsCache struct {
+	ttl   time.Duration
+	fetch func(ctx context.Context) (*db.GetJobStatsRow, error)
+
+	mu        sync.Mutex
+	stats     *db.GetJobStatsRow
+	fetchedAt time.Time
+}
+
+func (c *statsCache) get(ctx context.Context) (*db.GetJobStatsRow, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats != nil && time.Since(c.fetchedAt) < c.ttl {
+		stats := *c.stats
+		return &stats, nil
+	}
+
+	return c.refreshLocked(ctx)
+}
+
+func (c *statsCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.refreshLocked(ctx)
+	return err
+}
+
+func (c *statsCache) refreshLocked(ctx context.Context) (*db.GetJobStatsRow, error) {
+	stats, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.stats = stats
+	c.fetchedAt = time.Now()
+
+	cached := *stats
+	return &cached, nil
+}
+
+// SetStatsCacheTTL makes GetJobStats serve a cached result for up to ttl, so
+// frequent readers (the stats endpoint, metrics scrapes, the worker's stats
+// log) don't each scan job_queue under heavy write load. 0 turns caching off.
+// Call it before the service is shared between goroutines.
+func (jq *JobQueueService) SetStatsCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		jq.statsCache = nil
+		return
+	}
+	jq.statsCache = &statsCache{ttl: ttl, fetch: jq.fetchJobStats}
+}
+
+// minStatsRefreshInterval bounds how often RunStatsRefresh queries the stats,
+// however short the TTL
+const minStatsRefreshInterval = 10 * time.Millisecond
+
+// RunStatsRefresh refreshes the cached stats every half TTL until done is
+// closed, so GetJobStats readers rarely wait for the query. It returns at
+// once when caching is off.
+func (jq *JobQueueService) RunStatsRefresh(done <-chan struct{}) {
+	cache := jq.statsCache
+	if cache == nil {
+		return
+	}
+
+	interval := cache.ttl / 2
+	if interval < minStatsRefreshInterval {
+		interval = minStatsRefreshInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := cache.refresh(context.Background()); err != nil {
+				slog.Error("Error refreshing job stats", "error", err)
+			}
+		}
+	}
+}
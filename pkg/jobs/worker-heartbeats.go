@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"openapi-validation-example/db"
+)
+
+// Workers call Heartbeat every HeartbeatInterval. A worker whose last
+// heartbeat is older than WorkerLivenessTimeout is taken to be dead.
+const (
+	HeartbeatInterval     = 10 * time.Second
+	WorkerLivenessTimeout = time.Minute
+)
+
+// Heartbeat records that the worker with workerID is alive. Worker IDs are
+// only unique within a process: when two processes share the database, a
+// worker counts as alive while either of them sends its heartbeats.
+func (jq *JobQueueService) Heartbeat(ctx context.Context, workerID int) error {
+	err := jq.queries.UpsertWorkerHeartbeat(ctx, db.UpsertWorkerHeartbeatParams{
+		WorkerID:   int64(workerID),
+		LastSeenAt: jq.now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record worker heartbeat: %w", err)
+	}
+	return nil
+}
+
+// ListLiveWorkers returns the workers that sent a heartbeat within the last
+// seenWithin, lowest ID first
+func (jq *JobQueueService) ListLiveWorkers(ctx context.Context, seenWithin time.Duration) ([]db.WorkerHeartbeat, error) {
+	workers, err := jq.queries.ListWorkerHeartbeats(ctx, jq.now().Add(-seenWithin))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	return workers, nil
+}
+
+// ReapJobsOfDeadWorkers recovers jobs claimed with GetNextJobsForWorker by a
+// worker that has sent no heartbeat within the last deadAfter, like
+// ReapStaleJobs does for jobs that have been processing too long. Jobs
+// claimed without a worker ID are left to ReapStaleJobs. It returns how many
+// jobs were reaped.
+func (jq *JobQueueService) ReapJobsOfDeadWorkers(ctx context.Context, deadAfter time.Duration) (int64, error) {
+	now := jq.now()
+	reaped, err := jq.queries.ReapJobsOfDeadWorkers(ctx, db.ReapJobsOfDeadWorkersParams{
+		Now:          sql.NullTime{Time: now, Valid: true},
+		ErrorMessage: sql.NullString{String: fmt.Sprintf("job abandoned: its worker sent no heartbeat for %s", deadAfter), Valid: true},
+		SeenSince:    now.Add(-deadAfter),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap jobs of dead workers: %w", err)
+	}
+	return reaped, nil
+}
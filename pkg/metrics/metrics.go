@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"openapi-validation-example/pkg/jobs"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where the metrics endpoint is served. Requests to it are not
+// counted by the middleware.
+const Path = "/metrics"
+
+// Metrics holds the Prometheus collectors for an Echo server. Each instance
+// has its own registry so several servers (or tests) can coexist.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+
+	validationErrors *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		validationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "validation_errors_total",
+			Help: "Number of requests rejected by validation, by offending field.",
+		}, []string{"field"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.latency,
+		m.validationErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Middleware records request counts and latency for every route except Path.
+// Register it before the validation middleware so rejected requests count too.
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().URL.Path == Path {
+				return next(c)
+			}
+
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// Let the error handler write the response so the real status is recorded
+				c.Error(err)
+			}
+
+			// Use the route template (e.g. /users/:id) to keep label cardinality bounded
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+
+			m.requests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			m.latency.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+
+			return nil
+		}
+	}
+}
+
+// ValidationError counts a request rejected by validation because of field.
+// Pass it as validation.Options.OnValidationError.
+func (m *Metrics) ValidationError(field string) {
+	m.validationErrors.WithLabelValues(field).Inc()
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// RegisterJobQueue exports job counts per status as gauges, read from
+// GetJobStats on every scrape.
+func (m *Metrics) RegisterJobQueue(jobQueue jobs.Queue) error {
+	return m.registry.Register(&jobQueueCollector{jobQueue: jobQueue})
+}
+
+var jobQueueJobsDesc = prometheus.NewDesc(
+	"job_queue_jobs",
+	"Number of jobs in the queue by status.",
+	[]string{"status"}, nil,
+)
+
+type jobQueueCollector struct {
+	jobQueue jobs.Queue
+}
+
+func (c *jobQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobQueueJobsDesc
+}
+
+func (c *jobQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.jobQueue.GetJobStats(context.Background())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(jobQueueJobsDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(jobQueueJobsDesc, prometheus.GaugeValue, float64(stats.PendingCount), "pending")
+	ch <- prometheus.MustNewConstMetric(jobQueueJobsDesc, prometheus.GaugeValue, float64(stats.ProcessingCount), "processing")
+	ch <- prometheus.MustNewConstMetric(jobQueueJobsDesc, prometheus.GaugeValue, float64(stats.CompletedCount), "completed")
+	ch <- prometheus.MustNewConstMetric(jobQueueJobsDesc, prometheus.GaugeValue, float64(stats.FailedCount), "failed")
+	ch <- prometheus.MustNewConstMetric(jobQueueJobsDesc, prometheus.GaugeValue, float64(stats.CancelledCount), "cancelled")
+}
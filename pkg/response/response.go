@@ -0,0 +1,26 @@
+// Package response writes the servers' JSON responses, so every handler and
+// middleware sends the same headers.
+package response
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// ContentTypeJSON is the Content-Type of every JSON response
+const ContentTypeJSON = "application/json; charset=utf-8"
+
+// ServerName, when not empty, is sent in the Server header of JSON
+// responses. Set it at startup, before the server handles requests.
+var ServerName string
+
+// JSON writes v as the JSON response body with the given status. Use it
+// instead of echo.Context.JSON so the charset (and Server header) are set
+// uniformly; the body is still encoded by Echo's JSON serializer.
+func JSON(ctx echo.Context, status int, v interface{}) error {
+	header := ctx.Response().Header()
+	header.Set(echo.HeaderContentType, ContentTypeJSON)
+	if ServerName != "" {
+		header.Set(echo.HeaderServer, ServerName)
+	}
+	return ctx.JSON(status, v)
+}
@@ -0,0 +1,113 @@
+package validation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+)
+
+// kin-openapi's reason for a request body in a media type the operation
+// doesn't declare
+const unexpectedContentTypeReason = "header Content-Type has unexpected value"
+
+func init() {
+	openapi3filter.RegisterBodyDecoder(echo.MIMEApplicationForm, formFields(openapi3filter.RegisteredBodyDecoder(echo.MIMEApplicationForm)))
+	// kin-openapi writes a body back after filling in schema defaults, and
+	// only has an encoder for JSON
+	openapi3filter.RegisterBodyEncoder(echo.MIMEApplicationForm, encodeForm)
+}
+
+// formFields wraps kin-openapi's form decoder, which only reads the fields
+// the schema defines and sets those the form leaves out to null, failing
+// optional ones that aren't nullable. Missing fields are left out instead,
+// and undefined ones are kept as strings, so a form is checked, and written
+// back after defaults are filled in, like the same fields in JSON.
+func formFields(decode openapi3filter.BodyDecoder) openapi3filter.BodyDecoder {
+	return func(body io.Reader, header http.Header, schema *openapi3.SchemaRef, encFn openapi3filter.EncodingFn) (interface{}, error) {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decode(bytes.NewReader(data), header, schema, encFn)
+		if err != nil {
+			return value, err
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+
+		for name, field := range fields {
+			if field == nil {
+				delete(fields, name)
+			}
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		for name := range values {
+			if _, defined := schema.Value.Properties[name]; !defined {
+				fields[name] = values.Get(name)
+			}
+		}
+		return fields, nil
+	}
+}
+
+// encodeForm encodes a form body kin-openapi decoded, after it has filled in
+// defaults
+func encodeForm(body interface{}) ([]byte, error) {
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T as a form", body)
+	}
+
+	values := url.Values{}
+	for name, value := range fields {
+		switch value := value.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range value {
+				values.Add(name, fmt.Sprint(item))
+			}
+		default:
+			values.Set(name, fmt.Sprint(value))
+		}
+	}
+	return []byte(values.Encode()), nil
+}
+
+// isUnsupportedContentType reports whether reqErr rejects a request body sent
+// in a media type the operation doesn't declare. A request without a
+// Content-Type is not one; it gets the usual 400.
+func isUnsupportedContentType(c echo.Context, reqErr *openapi3filter.RequestError) bool {
+	return reqErr.RequestBody != nil && reqErr.Err == nil &&
+		strings.HasPrefix(reqErr.Reason, unexpectedContentTypeReason) &&
+		c.Request().Header.Get(echo.HeaderContentType) != ""
+}
+
+// respondUnsupportedContentType rejects a request body in a media type the
+// operation doesn't declare with 415, listing the ones it does
+func respondUnsupportedContentType(c echo.Context, reqErr *openapi3filter.RequestError) error {
+	declared := make([]string, 0, len(reqErr.RequestBody.Content))
+	for mediaType := range reqErr.RequestBody.Content {
+		declared = append(declared, mediaType)
+	}
+	sort.Strings(declared)
+
+	return response.JSON(c, http.StatusUnsupportedMediaType, map[string]string{
+		"error": fmt.Sprintf("Unsupported content type %q; expected %s",
+			c.Request().Header.Get(echo.HeaderContentType), strings.Join(declared, " or ")),
+	})
+}
@@ -0,0 +1,46 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// Fields ErrorField reports for errors not tied to a field of the spec
+const (
+	FieldRequest         = "request"
+	FieldBody            = "body"
+	FieldUnknownProperty = "unknown_property"
+)
+
+// ErrorField names what a rejected request got wrong: the parameter name, or
+// the dotted path of the body property (e.g. "email"). Properties the schema
+// doesn't define are all reported as FieldUnknownProperty, so client-chosen
+// names can't grow the set of values; errors without a field are FieldBody
+// or FieldRequest.
+func ErrorField(err error) string {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return FieldRequest
+	}
+	if reqErr.Parameter != nil {
+		return reqErr.Parameter.Name
+	}
+	if reqErr.RequestBody == nil {
+		return FieldRequest
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(reqErr.Err, &schemaErr) {
+		return FieldBody
+	}
+	if isUnknownPropertyError(schemaErr) {
+		return FieldUnknownProperty
+	}
+	if path := schemaErr.JSONPointer(); len(path) > 0 {
+		return strings.Join(path, ".")
+	}
+	return FieldBody
+}
@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// FormatFunc checks a string against a schema format, e.g. format: phone,
+// returning why the value doesn't match
+type FormatFunc func(value string) error
+
+// Formats registered when the package is loaded, on top of the date,
+// date-time and byte formats kin-openapi checks itself
+const (
+	FormatUUID  = "uuid"
+	FormatPhone = "phone"
+)
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+)
+
+// ValidateUUID accepts a UUID in its canonical hyphenated form, in either
+// case
+func ValidateUUID(value string) error {
+	if !uuidPattern.MatchString(value) {
+		return errors.New("not a UUID")
+	}
+	return nil
+}
+
+// ValidatePhone accepts an E.164 phone number: "+", a country code and the
+// subscriber number, up to 15 digits in all, without spaces or punctuation
+func ValidatePhone(value string) error {
+	if !phonePattern.MatchString(value) {
+		return errors.New("not an E.164 phone number such as +14155550123")
+	}
+	return nil
+}
+
+// formatRegistry holds the FormatFunc for each format registered through
+// Options.Formats or the built-ins. kin-openapi keeps formats in a
+// process-global map that is read, unlocked, on every validation, so each
+// name is handed to it once, as a lookup into this registry; registering a
+// name again only swaps the func here.
+var formatRegistry = struct {
+	sync.RWMutex
+	funcs map[string]FormatFunc
+}{funcs: make(map[string]FormatFunc)}
+
+// registerFormats makes formats checked by every ValidationMiddleware in the
+// process. A format registered again keeps the func passed last.
+func registerFormats(formats map[string]FormatFunc) {
+	formatRegistry.Lock()
+	defer formatRegistry.Unlock()
+
+	for name, fn := range formats {
+		if _, ok := formatRegistry.funcs[name]; !ok {
+			openapi3.DefineStringFormatCallback(name, checkFormat(name))
+		}
+		formatRegistry.funcs[name] = fn
+	}
+}
+
+func init() {
+	registerFormats(map[string]FormatFunc{
+		FormatUUID:  ValidateUUID,
+		FormatPhone: ValidatePhone,
+	})
+}
+
+// checkFormat returns the callback kin-openapi runs for the named format
+func checkFormat(name string) openapi3.FormatCallback {
+	return func(value string) error {
+		formatRegistry.RLock()
+		fn := formatRegistry.funcs[name]
+		formatRegistry.RUnlock()
+
+		if fn == nil {
+			return fmt.Errorf("format %q is not registered", name)
+		}
+		return fn(value)
+	}
+}
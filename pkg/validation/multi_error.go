@@ -0,0 +1,92 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+)
+
+// requestErrors flattens an error returned by ValidateRequest with
+// MultiError set into one error per failure. kin-openapi nests the body's
+// schema errors in a single RequestError; each becomes a RequestError of its
+// own, so it is reported like the only error would be.
+func requestErrors(err error) []error {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var errs []error
+		for _, inner := range e {
+			errs = append(errs, requestErrors(inner)...)
+		}
+		return errs
+	case *openapi3filter.RequestError:
+		if me, ok := e.Err.(openapi3.MultiError); ok && len(me) > 0 {
+			var errs []error
+			for _, inner := range me {
+				split := *e
+				split.Err = inner
+				errs = append(errs, requestErrors(&split)...)
+			}
+			return errs
+		}
+	}
+	return []error{err}
+}
+
+// handleMultiError rejects a request that failed validation in several places
+// with 400, listing every failure in "details". Authentication failures,
+// unsupported content types and strict mode's unknown fields are answered as
+// in single-error mode.
+func (v *ValidationMiddleware) handleMultiError(c echo.Context, me openapi3.MultiError) error {
+	errs := requestErrors(me)
+	if len(errs) == 0 {
+		return v.handleValidationError(c, errors.New("request validation failed"))
+	}
+
+	for _, err := range errs {
+		var securityErr *openapi3filter.SecurityRequirementsError
+		if errors.As(err, &securityErr) {
+			return v.handleValidationError(c, securityErr)
+		}
+	}
+	for _, err := range errs {
+		if reqErr, ok := err.(*openapi3filter.RequestError); ok && isUnsupportedContentType(c, reqErr) {
+			return v.handleValidationError(c, reqErr)
+		}
+	}
+
+	if v.onValidationError != nil {
+		for _, err := range errs {
+			v.onValidationError(ErrorField(err))
+		}
+	}
+
+	if v.reportUnknownFields {
+		for _, err := range errs {
+			if reqErr, ok := err.(*openapi3filter.RequestError); ok {
+				if handled, err := v.respondUnknownFields(c, reqErr); handled {
+					return err
+				}
+			}
+		}
+	}
+
+	details := make([]string, 0, len(errs))
+	for _, err := range errs {
+		details = append(details, v.formatErrorMessage(requestErrorMessage(err)))
+	}
+	errorMessage := details[0]
+	if len(details) > 1 {
+		errorMessage = fmt.Sprintf("Request validation failed with %d errors", len(details))
+	}
+
+	return response.JSON(c, http.StatusBadRequest, map[string]interface{}{
+		"error":   errorMessage,
+		"details": details,
+	})
+}
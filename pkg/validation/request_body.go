@@ -0,0 +1,64 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/labstack/echo/v4"
+)
+
+// BodyRequiredMessage is the message Options.RequireRequestBody answers
+// with
+const BodyRequiredMessage = "request body is required"
+
+// isEmptyBody reports whether data carries nothing: no bytes, only
+// whitespace, or an empty JSON object
+func isEmptyBody(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return true
+	}
+	var object map[string]json.RawMessage
+	return trimmed[0] == '{' && json.Unmarshal(trimmed, &object) == nil && len(object) == 0
+}
+
+// checkRequestBody rejects a request without a body for an operation that
+// requires one with 400 and BodyRequiredMessage. It reports whether the
+// request was rejected; otherwise the body is put back for validation and
+// the handler.
+func (v *ValidationMiddleware) checkRequestBody(c echo.Context, route *routers.Route) (bool, error) {
+	requestBody := route.Operation.RequestBody
+	if requestBody == nil || requestBody.Value == nil || !requestBody.Value.Required {
+		return false, nil
+	}
+
+	req := c.Request()
+	if req.Body == nil || req.Body == http.NoBody {
+		return true, v.respondBodyRequired(c)
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		// e.g. past the body limit; the error handler answers
+		return true, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	if isEmptyBody(data) {
+		return true, v.respondBodyRequired(c)
+	}
+	return false, nil
+}
+
+func (v *ValidationMiddleware) respondBodyRequired(c echo.Context) error {
+	if v.onValidationError != nil {
+		v.onValidationError(FieldBody)
+	}
+	return response.JSON(c, http.StatusBadRequest, map[string]string{
+		"error": BodyRequiredMessage,
+	})
+}
@@ -0,0 +1,119 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+)
+
+// ResponseValidationFromEnv decides Options.ValidateResponses from the
+// environment. VALIDATE_RESPONSES ("true" or "false") wins when set;
+// otherwise responses are validated when APP_ENV is dev, development,
+// staging or test, and not in prod or when APP_ENV is unset, since
+// buffering and checking every response costs time.
+func ResponseValidationFromEnv() bool {
+	switch strings.ToLower(os.Getenv("VALIDATE_RESPONSES")) {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+
+	switch strings.ToLower(os.Getenv("APP_ENV")) {
+	case "dev", "development", "staging", "test":
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferedResponseWriter holds a handler's response so it can be validated
+// before anything is sent to the client
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// validateResponse runs next with the response buffered, checks the status,
+// headers and body against the spec and only then sends the response. A
+// response that does not match the spec is logged and replaced with a 500,
+// so the mismatch is noticed before it reaches production.
+func (v *ValidationMiddleware) validateResponse(c echo.Context, input *openapi3filter.RequestValidationInput, next echo.HandlerFunc) error {
+	res := c.Response()
+	original := res.Writer
+	buffered := &bufferedResponseWriter{ResponseWriter: original, status: http.StatusOK}
+	res.Writer = buffered
+	err := next(c)
+	res.Writer = original
+	if err != nil && !res.Committed {
+		// Not written yet; the error handler writes the response
+		return err
+	}
+
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 buffered.status,
+		Header:                 res.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(buffered.body.Bytes())),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+			// Bodies without a decoder (e.g. XML) are not checked
+			ExcludeResponseBody: !hasBodyDecoder(res.Header().Get(echo.HeaderContentType)),
+		},
+	}
+	if validationErr := openapi3filter.ValidateResponse(c.Request().Context(), responseInput); validationErr != nil {
+		log.Printf("Response validation failed for %s %s: %v", c.Request().Method, c.Request().URL.Path, validationErr)
+		return writeInvalidResponse(original, validationErr)
+	}
+
+	original.WriteHeader(buffered.status)
+	if _, writeErr := original.Write(buffered.body.Bytes()); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func hasBodyDecoder(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err != nil || openapi3filter.RegisteredBodyDecoder(mediaType) != nil
+}
+
+// writeInvalidResponse replaces a response that failed validation. Echo
+// already considers the response committed, so it is written directly.
+func writeInvalidResponse(w http.ResponseWriter, err error) error {
+	body, marshalErr := json.Marshal(map[string]string{
+		"error": fmt.Sprintf("Response validation failed: %v", err),
+	})
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	w.Header().Del(echo.HeaderContentLength)
+	w.Header().Set(echo.HeaderContentType, response.ContentTypeJSON)
+	w.WriteHeader(http.StatusInternalServerError)
+	_, writeErr := w.Write(body)
+	return writeErr
+}
@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"openapi-validation-example/generated"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// CheckGeneratedTypes validates fully populated sample values of the
+// generated User and UserRequest types against the schemas of the same name
+// in the spec. It returns one message per mismatch, so drift between the spec
+// and code generated from an older copy of it can be caught at startup.
+func CheckGeneratedTypes(specPath string) ([]string, error) {
+	loader := &openapi3.Loader{Context: context.Background(), IsExternalRefsAllowed: true}
+	doc, err := loader.LoadFromFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+
+	name := "Sample User"
+	bio := "Sample biography"
+	isActive := true
+	now := time.Now().UTC()
+
+	samples := []struct {
+		schemaName string
+		value      interface{}
+	}{
+		{
+			schemaName: "User",
+			value: generated.User{
+				Id:        1,
+				Email:     "sample@example.com",
+				Age:       30,
+				Name:      &name,
+				Bio:       &bio,
+				IsActive:  &isActive,
+				CreatedAt: &now,
+				UpdatedAt: &now,
+			},
+		},
+		{
+			schemaName: "UserRequest",
+			value: generated.UserRequest{
+				Email:    "sample@example.com",
+				Age:      30,
+				Name:     &name,
+				Bio:      &bio,
+				IsActive: &isActive,
+			},
+		},
+	}
+
+	var mismatches []string
+	for _, sample := range samples {
+		schemaRef, ok := doc.Components.Schemas[sample.schemaName]
+		if !ok || schemaRef.Value == nil {
+			mismatches = append(mismatches, fmt.Sprintf("schema %s is missing from the spec", sample.schemaName))
+			continue
+		}
+		schema := schemaRef.Value
+
+		sampleJSON, err := json.Marshal(sample.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal sample %s: %w", sample.schemaName, err)
+		}
+		var sampleValue map[string]interface{}
+		if err := json.Unmarshal(sampleJSON, &sampleValue); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal sample %s: %w", sample.schemaName, err)
+		}
+
+		// Fields on one side only. These are checked separately because
+		// additionalProperties: true would let extra generated fields through.
+		for _, field := range sortedKeys(sampleValue) {
+			if _, ok := schema.Properties[field]; !ok {
+				mismatches = append(mismatches, fmt.Sprintf("%s: generated field %q is not in the spec", sample.schemaName, field))
+			}
+		}
+		for _, property := range sortedKeys(schema.Properties) {
+			if _, ok := sampleValue[property]; !ok {
+				mismatches = append(mismatches, fmt.Sprintf("%s: spec property %q is missing from the generated type", sample.schemaName, property))
+			}
+		}
+
+		if err := schema.VisitJSON(sampleValue, openapi3.MultiErrors()); err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: generated sample does not match the spec: %v", sample.schemaName, err))
+		}
+	}
+
+	return mismatches, nil
+}
+
+// SelfTest runs CheckGeneratedTypes and logs a warning for each mismatch. It
+// returns false if the spec and the generated types have drifted apart.
+func SelfTest(specPath string, logger *log.Logger) bool {
+	mismatches, err := CheckGeneratedTypes(specPath)
+	if err != nil {
+		logger.Printf("WARNING: spec self-test for %s could not run: %v", specPath, err)
+		return false
+	}
+
+	for _, mismatch := range mismatches {
+		logger.Printf("WARNING: %s and generated types have drifted: %s (re-run make generate)", specPath, mismatch)
+	}
+
+	return len(mismatches) == 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
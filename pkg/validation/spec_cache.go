@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// cachedSpec is a loaded, validated spec and its router, valid while the
+// file's modification time is unchanged
+type cachedSpec struct {
+	modTime time.Time
+	doc     *openapi3.T
+	router  routers.Router
+}
+
+// specCache lets every middleware built from the same spec file share one
+// parsed spec and router, so tests that build many middlewares load each
+// spec once. Files the spec references are not tracked; call ClearSpecCache
+// after changing them.
+var specCache = struct {
+	sync.Mutex
+	entries map[string]cachedSpec
+}{entries: make(map[string]cachedSpec)}
+
+// readSpecURI reads spec files without kin-openapi's default cache, which
+// keeps a file's first contents for the life of the process and would hide
+// changes from a reload
+var readSpecURI = openapi3.ReadFromURIs(openapi3.ReadFromHTTP(http.DefaultClient), openapi3.ReadFromFile)
+
+// ClearSpecCache drops every cached spec, so the next middleware reloads its
+// spec from disk
+func ClearSpecCache() {
+	specCache.Lock()
+	defer specCache.Unlock()
+	specCache.entries = make(map[string]cachedSpec)
+}
+
+// loadSpec returns the spec at specPath and its router, from the cache if the
+// file is unchanged. Loads are serialized, so concurrent callers share one.
+func loadSpec(specPath string) (*openapi3.T, routers.Router, error) {
+	path, err := filepath.Abs(specPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+
+	specCache.Lock()
+	defer specCache.Unlock()
+
+	if cached, ok := specCache.entries[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.doc, cached.router, nil
+	}
+
+	ctx := context.Background()
+	loader := &openapi3.Loader{Context: ctx, IsExternalRefsAllowed: true, ReadFromURIFunc: readSpecURI}
+	doc, err := loader.LoadFromFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+
+	if err := doc.Validate(ctx); err != nil {
+		return nil, nil, fmt.Errorf("OpenAPI spec validation failed: %w", err)
+	}
+
+	// Match routes on path alone. The middleware only ever sees requests
+	// addressed to this server, and the spec's servers entry (localhost:8080)
+	// would otherwise make every request on another host skip validation.
+	doc.Servers = nil
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create router: %w", err)
+	}
+
+	specCache.entries[path] = cachedSpec{modTime: info.ModTime(), doc: doc, router: router}
+	return doc, router, nil
+}
@@ -0,0 +1,100 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"openapi-validation-example/pkg/response"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+)
+
+// isUnknownPropertyError reports whether err is a schema violation of
+// additionalProperties: false
+func isUnknownPropertyError(err error) bool {
+	var schemaErr *openapi3.SchemaError
+	return errors.As(err, &schemaErr) &&
+		schemaErr.SchemaField == "properties" &&
+		strings.HasSuffix(schemaErr.Reason, " is unsupported")
+}
+
+// unknownFields lists, sorted, the top-level properties of the request body
+// that its schema doesn't define. kin-openapi stops at the first one, so the
+// body, which it puts back after reading, is checked again here.
+func unknownFields(c echo.Context, reqErr *openapi3filter.RequestError) []string {
+	if reqErr.RequestBody == nil {
+		return nil
+	}
+	mediaType := reqErr.RequestBody.Content.Get(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType == nil || mediaType.Schema == nil || mediaType.Schema.Value == nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil
+	}
+	names, err := bodyFieldNames(c.Request().Header.Get(echo.HeaderContentType), data)
+	if err != nil {
+		return nil
+	}
+
+	var fields []string
+	for _, name := range names {
+		if _, ok := mediaType.Schema.Value.Properties[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// bodyFieldNames returns the top-level field names of a JSON or form-encoded
+// body
+func bodyFieldNames(contentType string, data []byte) ([]string, error) {
+	var names []string
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == echo.MIMEApplicationForm {
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		for name := range values {
+			names = append(names, name)
+		}
+		return names, nil
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	for name := range body {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// respondUnknownFields rejects a request body with properties its schema
+// doesn't allow with 422, naming every one of them. It returns false when err
+// is not such a violation.
+func (v *ValidationMiddleware) respondUnknownFields(c echo.Context, reqErr *openapi3filter.RequestError) (bool, error) {
+	if !isUnknownPropertyError(reqErr.Err) {
+		return false, nil
+	}
+	fields := unknownFields(c, reqErr)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	return true, response.JSON(c, http.StatusUnprocessableEntity, map[string]string{
+		"error": fmt.Sprintf("unexpected fields: [%s]", strings.Join(fields, ", ")),
+	})
+}
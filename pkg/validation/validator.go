@@ -2,40 +2,146 @@ package validation
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"openapi-validation-example/pkg/response"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/getkin/kin-openapi/routers"
-	"github.com/getkin/kin-openapi/routers/gorillamux"
 	"github.com/labstack/echo/v4"
 )
 
+// ResponseContentTypesKey is the echo.Context key under which Validate stores
+// the media types the spec declares for the matched operation's 2XX
+// responses, as a sorted []string. Handlers use it for content negotiation.
+const ResponseContentTypesKey = "openapi.response_content_types"
+
+// ValidationDurationHeader carries how long request validation took, in
+// milliseconds, when Options.ReportDuration is set
+const ValidationDurationHeader = "X-Validation-Duration-ms"
+
 type ValidationMiddleware struct {
-	router routers.Router
+	router              routers.Router
+	methods             map[string]bool
+	reportDuration      bool
+	authenticate        openapi3filter.AuthenticationFunc
+	validateResponses   bool
+	reportUnknownFields bool
+	onValidationError   func(field string)
+	multiError          bool
+	requireRequestBody  bool
+}
+
+// Options configures a ValidationMiddleware
+type Options struct {
+	// ValidateMethods limits validation to the listed HTTP methods (e.g.
+	// POST, PUT, PATCH); requests with other methods skip parameter and body
+	// validation, but still have to meet the spec's security requirements.
+	// Empty means every method is validated.
+	ValidateMethods []string
+
+	// ReportDuration adds a ValidationDurationHeader to the response of every
+	// validated request, for performance debugging. Off by default.
+	ReportDuration bool
+
+	// AuthenticationFunc checks the spec's security requirements (e.g.
+	// auth.KeySet.AuthenticationFunc); requests that fail get 401. nil uses
+	// RejectAllAuthenticationFunc, so a spec with security requirements
+	// fails closed until authentication is configured. Pass
+	// openapi3filter.NoopAuthenticationFunc to disable authentication.
+	AuthenticationFunc openapi3filter.AuthenticationFunc
+
+	// ValidateResponses also checks each response against the spec before
+	// it is sent; a mismatch is logged and replaced with a 500. Responses
+	// are buffered, so enable it in development and staging only (see
+	// ResponseValidationFromEnv).
+	ValidateResponses bool
+
+	// ReportUnknownFields answers a request body with properties the spec
+	// doesn't allow (additionalProperties: false) with 422 and
+	// {"error": "unexpected fields: [a, b]"}, naming all of them, instead of
+	// the usual 400 about the first one. Strict mode turns it on.
+	ReportUnknownFields bool
+
+	// OnValidationError is called with ErrorField(err) for every request
+	// rejected by validation (not for authentication failures), e.g.
+	// metrics.Metrics.ValidationError.
+	OnValidationError func(field string)
+
+	// MultiError keeps validating after the first failure and answers with
+	// every one of them: {"error": "...", "details": ["...", "..."]}, one
+	// entry per failing parameter or body field. Off by default, so
+	// validation stops at the first failure and "details" is left out.
+	MultiError bool
+
+	// Formats adds checks for string formats, by format name, on top of the
+	// built-in FormatUUID and FormatPhone, which it can also replace.
+	// kin-openapi keeps formats process-global, so they apply to every
+	// middleware in the process; registering a name again is safe and the
+	// last func registered wins.
+	Formats map[string]FormatFunc
+
+	// RequireRequestBody answers a request that sends no body to an
+	// operation requiring one with 400 and {"error": "request body is
+	// required"}, whether the body is empty, only whitespace or {}. Off by
+	// default, so such bodies get whatever error validation finds first.
+	RequireRequestBody bool
+}
+
+// ErrNoAuthentication is returned by RejectAllAuthenticationFunc
+var ErrNoAuthentication = errors.New("no authentication configured")
+
+// RejectAllAuthenticationFunc is the default AuthenticationFunc. It rejects
+// every request to an operation with security requirements.
+func RejectAllAuthenticationFunc(context.Context, *openapi3filter.AuthenticationInput) error {
+	return ErrNoAuthentication
 }
 
 func NewValidationMiddleware(specPath string) (*ValidationMiddleware, error) {
-	ctx := context.Background()
-	loader := &openapi3.Loader{Context: ctx, IsExternalRefsAllowed: true}
-	doc, err := loader.LoadFromFile(specPath)
+	return NewValidationMiddlewareWithOptions(specPath, Options{})
+}
+
+// NewValidationMiddlewareWithOptions builds a middleware validating against
+// the spec at specPath. The parsed spec is cached and shared by middlewares
+// for the same unchanged file (see ClearSpecCache).
+func NewValidationMiddlewareWithOptions(specPath string, opts Options) (*ValidationMiddleware, error) {
+	registerFormats(opts.Formats)
+
+	_, router, err := loadSpec(specPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+		return nil, err
 	}
 
-	if err := doc.Validate(ctx); err != nil {
-		return nil, fmt.Errorf("OpenAPI spec validation failed: %w", err)
+	var methods map[string]bool
+	if len(opts.ValidateMethods) > 0 {
+		methods = make(map[string]bool, len(opts.ValidateMethods))
+		for _, method := range opts.ValidateMethods {
+			methods[strings.ToUpper(method)] = true
+		}
 	}
 
-	router, err := gorillamux.NewRouter(doc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create router: %w", err)
+	authenticate := opts.AuthenticationFunc
+	if authenticate == nil {
+		authenticate = RejectAllAuthenticationFunc
 	}
 
 	return &ValidationMiddleware{
-		router: router,
+		router:              router,
+		methods:             methods,
+		reportDuration:      opts.ReportDuration,
+		authenticate:        authenticate,
+		validateResponses:   opts.ValidateResponses,
+		reportUnknownFields: opts.ReportUnknownFields,
+		onValidationError:   opts.OnValidationError,
+		multiError:          opts.MultiError,
+		requireRequestBody:  opts.RequireRequestBody,
 	}, nil
 }
 
@@ -49,47 +155,164 @@ func (v *ValidationMiddleware) Validate() echo.MiddlewareFunc {
 				return next(c)
 			}
 
+			c.Set(ResponseContentTypesKey, successContentTypes(route.Operation))
+
 			requestValidationInput := &openapi3filter.RequestValidationInput{
 				Request:    req,
 				PathParams: pathParams,
 				Route:      route,
+				Options: &openapi3filter.Options{
+					AuthenticationFunc: v.authenticate,
+					MultiError:         v.multiError,
+				},
 			}
 
-			ctx := context.Background()
-			if err := openapi3filter.ValidateRequest(ctx, requestValidationInput); err != nil {
+			// Methods outside ValidateMethods skip parameter and body
+			// validation, but not the security requirements
+			if v.methods != nil && !v.methods[req.Method] {
+				if err := checkSecurity(req.Context(), requestValidationInput); err != nil {
+					return v.handleValidationError(c, err)
+				}
+				return v.serve(c, requestValidationInput, next)
+			}
+
+			// Validation runs on the request's context, and a request whose
+			// client has gone away is dropped before and after validating
+			// rather than passed on to the handler
+			ctx := req.Context()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if v.requireRequestBody {
+				if rejected, err := v.checkRequestBody(c, route); rejected {
+					return err
+				}
+			}
+			start := time.Now()
+			err = openapi3filter.ValidateRequest(ctx, requestValidationInput)
+			if v.reportDuration {
+				ms := float64(time.Since(start).Microseconds()) / 1000
+				c.Response().Header().Set(ValidationDurationHeader, strconv.FormatFloat(ms, 'f', 3, 64))
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
 				return v.handleValidationError(c, err)
 			}
 
-			return next(c)
+			return v.serve(c, requestValidationInput, next)
 		}
 	}
 }
 
+// checkSecurity checks the matched operation's security requirements, or the
+// spec's global ones if it declares none, as ValidateRequest does first
+func checkSecurity(ctx context.Context, input *openapi3filter.RequestValidationInput) error {
+	security := input.Route.Operation.Security
+	if security == nil {
+		security = &input.Route.Spec.Security
+	}
+	return openapi3filter.ValidateSecurityRequirements(ctx, input, *security)
+}
+
+// serve runs the handler, validating its response if enabled
+func (v *ValidationMiddleware) serve(c echo.Context, input *openapi3filter.RequestValidationInput, next echo.HandlerFunc) error {
+	if v.validateResponses {
+		return v.validateResponse(c, input, next)
+	}
+	return next(c)
+}
+
+// successContentTypes returns the media types of the operation's 2XX
+// responses
+func successContentTypes(operation *openapi3.Operation) []string {
+	seen := map[string]bool{}
+	var contentTypes []string
+	if operation == nil || operation.Responses == nil {
+		return contentTypes
+	}
+	for code, response := range operation.Responses {
+		if !strings.HasPrefix(code, "2") || response.Value == nil {
+			continue
+		}
+		for mediaType := range response.Value.Content {
+			if !seen[mediaType] {
+				seen[mediaType] = true
+				contentTypes = append(contentTypes, mediaType)
+			}
+		}
+	}
+	sort.Strings(contentTypes)
+	return contentTypes
+}
+
 func (v *ValidationMiddleware) handleValidationError(c echo.Context, err error) error {
-	var errorMessage string
+	// A body without Content-Length that runs past the body limit fails
+	// while it is read; pass the 413 on to the error handler
+	if errors.Is(err, echo.ErrStatusRequestEntityTooLarge) {
+		return err
+	}
+
+	if me, ok := err.(openapi3.MultiError); ok {
+		return v.handleMultiError(c, me)
+	}
+
+	var securityErr *openapi3filter.SecurityRequirementsError
+	if v.onValidationError != nil && !errors.As(err, &securityErr) {
+		v.onValidationError(ErrorField(err))
+	}
 
 	switch e := err.(type) {
 	case *openapi3filter.RequestError:
-		if e.Parameter != nil {
-			errorMessage = fmt.Sprintf("Parameter validation failed for '%s': %s", e.Parameter.Name, e.Err.Error())
-		} else if e.RequestBody != nil {
-			errorMessage = fmt.Sprintf("Request body validation failed: %s", e.Err.Error())
-		} else {
-			errorMessage = fmt.Sprintf("Request validation failed: %s", e.Err.Error())
+		if isUnsupportedContentType(c, e) {
+			return respondUnsupportedContentType(c, e)
+		}
+		if v.reportUnknownFields {
+			if handled, err := v.respondUnknownFields(c, e); handled {
+				return err
+			}
 		}
 	case *openapi3filter.SecurityRequirementsError:
-		errorMessage = "Security requirements not met"
-	default:
-		errorMessage = err.Error()
+		reasons := make([]string, 0, len(e.Errors))
+		for _, reqErr := range e.Errors {
+			reasons = append(reasons, reqErr.Error())
+		}
+		return response.JSON(c, http.StatusUnauthorized, map[string]string{
+			"error": "Unauthorized: " + strings.Join(reasons, "; "),
+		})
 	}
 
-	errorMessage = v.formatErrorMessage(errorMessage)
+	errorMessage := v.formatErrorMessage(requestErrorMessage(err))
 
-	return c.JSON(http.StatusBadRequest, map[string]string{
+	return response.JSON(c, http.StatusBadRequest, map[string]string{
 		"error": errorMessage,
 	})
 }
 
+// requestErrorMessage describes a validation failure, naming the parameter
+// or the request body it was found in
+func requestErrorMessage(err error) string {
+	e, ok := err.(*openapi3filter.RequestError)
+	if !ok {
+		return err.Error()
+	}
+
+	// Some request errors (e.g. an unsupported content type) only carry a reason
+	detail := e.Reason
+	if e.Err != nil {
+		detail = e.Err.Error()
+	}
+
+	if e.Parameter != nil {
+		return fmt.Sprintf("Parameter validation failed for '%s': %s", e.Parameter.Name, detail)
+	}
+	if e.RequestBody != nil {
+		return fmt.Sprintf("Request body validation failed: %s", detail)
+	}
+	return fmt.Sprintf("Request validation failed: %s", detail)
+}
+
 func (v *ValidationMiddleware) formatErrorMessage(message string) string {
 	message = strings.ReplaceAll(message, "doesn't match schema", "does not match the required format")
 	message = strings.ReplaceAll(message, "Error at", "Error in field")
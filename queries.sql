@@ -13,9 +13,17 @@ WHERE email = ?;
 
 -- name: ListUsers :many
 SELECT * FROM users
-WHERE is_active = true
-ORDER BY created_at DESC
-LIMIT ?;
+ORDER BY id ASC
+LIMIT ? OFFSET ?;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users;
+
+-- name: CountUsersByActive :one
+SELECT
+    COUNT(CASE WHEN is_active THEN 1 END) as active_count,
+    COUNT(CASE WHEN NOT is_active THEN 1 END) as inactive_count
+FROM users;
 
 -- name: UpdateUser :one
 UPDATE users
@@ -23,53 +31,273 @@ SET email = ?, age = ?, name = ?, bio = ?, is_active = ?, additional_data = ?, u
 WHERE id = ?
 RETURNING *;
 
--- name: DeleteUser :exec
+-- name: DeleteUser :one
 DELETE FROM users
-WHERE id = ?;
+WHERE id = ?
+RETURNING *;
 
 -- Job Queue Operations
 -- name: CreateJob :one
-INSERT INTO job_queue (job_type, payload, priority, max_retries, scheduled_at)
-VALUES (?, ?, ?, ?, ?)
+INSERT INTO job_queue (job_type, payload, priority, max_retries, scheduled_at, request_id, dedup_key)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (dedup_key) WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing') DO NOTHING
 RETURNING *;
 
+-- name: CancelJob :execrows
+UPDATE job_queue
+SET status = 'cancelled'
+WHERE id = ? AND status = 'pending';
+
+-- name: CountPendingJobs :one
+SELECT COUNT(*) FROM job_queue WHERE status = 'pending';
+
+-- name: CountProcessingJobsByType :many
+SELECT job_type, COUNT(*) as processing_count FROM job_queue
+WHERE status = 'processing'
+GROUP BY job_type
+ORDER BY job_type;
+
 -- name: GetNextPendingJob :one
 SELECT * FROM job_queue
 WHERE status = 'pending'
-  AND scheduled_at <= CURRENT_TIMESTAMP
+  AND scheduled_at <= sqlc.arg(now)
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT 1;
+
+-- name: GetNextPendingJobs :many
+SELECT * FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= sqlc.arg(now)
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT ?;
+
+-- name: GetNextPendingJobsForType :many
+SELECT * FROM job_queue
+WHERE status = 'pending'
+  AND job_type = ?
+  AND scheduled_at <= sqlc.arg(now)
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT ?;
+
+-- name: GetOldestDuePendingJob :one
+SELECT * FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= sqlc.arg(now)
   AND retry_count < max_retries
-ORDER BY priority DESC, scheduled_at ASC
+ORDER BY scheduled_at ASC, id ASC
 LIMIT 1;
 
+-- name: GetPendingJobTypes :many
+SELECT DISTINCT job_type FROM job_queue
+WHERE status = 'pending'
+  AND scheduled_at <= sqlc.arg(now)
+  AND retry_count < max_retries
+  AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE name = 'paused' AND value = 'true')
+ORDER BY job_type;
+
 -- name: UpdateJobStatus :one
 UPDATE job_queue
 SET status = ?, started_at = ?, completed_at = ?, error_message = ?
 WHERE id = ?
 RETURNING *;
 
+-- name: SetJobWorker :exec
+UPDATE job_queue
+SET worker_id = ?
+WHERE id = ?;
+
 -- name: IncrementJobRetry :one
 UPDATE job_queue
 SET retry_count = retry_count + 1,
     status = 'pending',
-    scheduled_at = datetime(CURRENT_TIMESTAMP, '+' || (retry_count + 1) * 5 || ' minutes'),
-    error_message = ?
-WHERE id = ?
+    scheduled_at = datetime(sqlc.arg(now), '+' || ((retry_count + 1) * 5) || ' minutes'),
+    error_message = sqlc.arg(error_message)
+WHERE id = sqlc.arg(id)
 RETURNING *;
 
+-- name: RequeueJob :execrows
+UPDATE job_queue
+SET status = 'pending',
+    retry_count = ?,
+    scheduled_at = ?,
+    error_message = NULL,
+    started_at = NULL,
+    completed_at = NULL
+WHERE id = ? AND status = 'failed';
+
+-- name: DeferJob :execrows
+UPDATE job_queue
+SET status = 'pending',
+    scheduled_at = sqlc.arg(scheduled_at),
+    error_message = sqlc.arg(error_message),
+    started_at = NULL,
+    worker_id = NULL
+WHERE id = sqlc.arg(id) AND status = 'processing';
+
+-- name: ReplayFailedJobs :execrows
+UPDATE job_queue
+SET status = 'pending',
+    retry_count = 0,
+    scheduled_at = sqlc.arg(now),
+    error_message = NULL,
+    started_at = NULL,
+    completed_at = NULL
+WHERE status = 'failed' AND (sqlc.arg(job_type) = '' OR job_type = sqlc.arg(job_type));
+
+-- name: ReapStaleJobs :execrows
+UPDATE job_queue
+SET retry_count = retry_count + 1,
+    status = CASE WHEN retry_count + 1 < max_retries THEN 'pending' ELSE 'failed' END,
+    scheduled_at = sqlc.arg(now),
+    started_at = NULL,
+    completed_at = CASE WHEN retry_count + 1 < max_retries THEN NULL ELSE sqlc.arg(now) END,
+    error_message = sqlc.arg(error_message)
+WHERE status = 'processing' AND started_at < sqlc.arg(started_before);
+
+-- name: ReapJobsOfDeadWorkers :execrows
+UPDATE job_queue
+SET retry_count = retry_count + 1,
+    status = CASE WHEN retry_count + 1 < max_retries THEN 'pending' ELSE 'failed' END,
+    scheduled_at = sqlc.arg(now),
+    started_at = NULL,
+    completed_at = CASE WHEN retry_count + 1 < max_retries THEN NULL ELSE sqlc.arg(now) END,
+    error_message = sqlc.arg(error_message)
+WHERE status = 'processing' AND worker_id IS NOT NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM worker_heartbeats h
+    WHERE h.worker_id = job_queue.worker_id AND h.last_seen_at >= sqlc.arg(seen_since)
+  );
+
+-- name: RenamePendingJobType :execrows
+UPDATE job_queue
+SET job_type = sqlc.arg(new_type)
+WHERE job_type = sqlc.arg(old_type) AND status = 'pending';
+
+-- name: SetJobPriorityForStatus :execrows
+UPDATE job_queue
+SET priority = ?
+WHERE job_type = ? AND status = ?;
+
+-- name: UpdateJobDuration :exec
+UPDATE job_queue
+SET duration_ms = ?
+WHERE id = ?;
+
 -- name: GetJobByID :one
 SELECT * FROM job_queue
 WHERE id = ?;
 
+-- name: GetUnhandledJobByDedupKey :one
+SELECT * FROM job_queue
+WHERE dedup_key = ? AND status IN ('pending', 'processing')
+LIMIT 1;
+
 -- name: ListJobs :many
 SELECT * FROM job_queue
-WHERE status = ?
-ORDER BY created_at DESC
-LIMIT ?;
+WHERE (sqlc.arg(status) = '' OR status = sqlc.arg(status))
+  AND (sqlc.arg(job_type) = '' OR job_type = sqlc.arg(job_type))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(limit);
+
+-- name: ListJobsAfterID :many
+SELECT * FROM job_queue
+WHERE (sqlc.arg(status) = '' OR status = sqlc.arg(status))
+  AND (sqlc.arg(job_type) = '' OR job_type = sqlc.arg(job_type))
+  AND id > sqlc.arg(after_id)
+ORDER BY id ASC
+LIMIT sqlc.arg(limit);
+
+-- name: ListPendingJobsInClaimOrder :many
+SELECT * FROM job_queue
+WHERE status = 'pending'
+  AND (sqlc.arg(job_type) = '' OR job_type = sqlc.arg(job_type))
+ORDER BY priority DESC, scheduled_at ASC, id ASC
+LIMIT sqlc.arg(limit);
+
+-- name: GetJobsForUser :many
+SELECT * FROM job_queue
+WHERE json_extract(payload, '$.user_id') = sqlc.arg(user_id)
+ORDER BY created_at ASC, id ASC;
 
 -- name: GetJobStats :one
 SELECT
     COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_count,
     COUNT(CASE WHEN status = 'processing' THEN 1 END) as processing_count,
     COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_count,
-    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_count
-FROM job_queue;
\ No newline at end of file
+    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_count,
+    COUNT(CASE WHEN status = 'cancelled' THEN 1 END) as cancelled_count
+FROM job_queue;
+
+-- name: GetJobStatsByType :many
+SELECT
+    job_type,
+    COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending_count,
+    COUNT(CASE WHEN status = 'processing' THEN 1 END) as processing_count,
+    COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_count,
+    COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed_count,
+    COUNT(CASE WHEN status = 'cancelled' THEN 1 END) as cancelled_count
+FROM job_queue
+GROUP BY job_type
+ORDER BY job_type;
+
+-- name: GetJobStatuses :many
+SELECT id, status, error_message FROM job_queue
+WHERE id IN (sqlc.slice('ids'))
+ORDER BY id;
+
+-- name: GetJobDurationStats :many
+SELECT
+    job_type,
+    COUNT(duration_ms) as job_count,
+    AVG(duration_ms) as avg_duration_ms
+FROM job_queue
+WHERE duration_ms IS NOT NULL
+GROUP BY job_type
+ORDER BY job_type;
+
+-- name: CheckJobQueue :exec
+SELECT id FROM job_queue LIMIT 1;
+
+-- Idempotency Keys
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE idempotency_key = ? AND expires_at > CURRENT_TIMESTAMP;
+
+-- name: UpsertIdempotencyKey :exec
+INSERT INTO idempotency_keys (idempotency_key, user_id, expires_at)
+VALUES (sqlc.arg(idempotency_key), sqlc.arg(user_id), datetime(CURRENT_TIMESTAMP, sqlc.arg(expires_in)))
+ON CONFLICT (idempotency_key) DO UPDATE
+SET user_id = excluded.user_id, created_at = CURRENT_TIMESTAMP, expires_at = excluded.expires_at;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- Queue Settings
+-- name: GetQueueSetting :one
+SELECT * FROM queue_settings
+WHERE name = ?;
+
+-- name: UpsertQueueSetting :exec
+INSERT INTO queue_settings (name, value)
+VALUES (?, ?)
+ON CONFLICT (name) DO UPDATE
+SET value = excluded.value, updated_at = CURRENT_TIMESTAMP;
+
+-- Worker Heartbeats
+-- name: UpsertWorkerHeartbeat :exec
+INSERT INTO worker_heartbeats (worker_id, last_seen_at)
+VALUES (?, ?)
+ON CONFLICT (worker_id) DO UPDATE
+SET last_seen_at = excluded.last_seen_at;
+
+-- name: ListWorkerHeartbeats :many
+SELECT * FROM worker_heartbeats
+WHERE last_seen_at >= sqlc.arg(seen_since)
+ORDER BY worker_id;
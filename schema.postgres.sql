@@ -0,0 +1,69 @@
+-- Postgres variant of schema.sql, for a postgresql sqlc target
+CREATE TABLE users (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    age INTEGER NOT NULL CHECK(age >= 0),
+    name TEXT,
+    bio TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    additional_data TEXT, -- JSON string for additional properties
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Job queue table for background processing
+CREATE TABLE job_queue (
+    id BIGSERIAL PRIMARY KEY,
+    job_type TEXT NOT NULL, -- 'user_created', 'data_analysis', 'email_notification', etc.
+    payload TEXT NOT NULL,  -- JSON data to process
+    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'processing', 'completed', 'failed', 'cancelled'
+    priority INTEGER DEFAULT 0, -- Higher number = higher priority
+    max_retries INTEGER DEFAULT 3,
+    retry_count INTEGER DEFAULT 0,
+    error_message TEXT,
+    scheduled_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    duration_ms BIGINT, -- How long the processor took, set when processing finishes
+    request_id TEXT, -- X-Request-ID of the HTTP request that enqueued the job
+    dedup_key TEXT, -- Optional; at most one pending or processing job per key
+    worker_id BIGINT -- Worker that claimed the job, when it identified itself
+);
+
+-- Idempotency keys sent with POST /users, mapped to the user they created
+CREATE TABLE idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL -- The key is forgotten after this
+);
+
+-- Queue-wide switches, e.g. name 'paused' with value 'true' stops job claiming
+CREATE TABLE queue_settings (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Last heartbeat of each worker, to tell live workers from dead ones
+CREATE TABLE worker_heartbeats (
+    worker_id BIGINT PRIMARY KEY,
+    last_seen_at TIMESTAMPTZ NOT NULL
+);
+
+-- Index for faster email lookups
+CREATE INDEX idx_users_email ON users(email);
+CREATE INDEX idx_users_active ON users(is_active);
+
+-- Indexes for job queue
+CREATE INDEX idx_job_queue_status ON job_queue(status);
+CREATE INDEX idx_job_queue_type ON job_queue(job_type);
+CREATE INDEX idx_job_queue_scheduled ON job_queue(scheduled_at);
+CREATE INDEX idx_job_queue_priority ON job_queue(priority DESC, scheduled_at);
+-- Deduplication: one unhandled job per dedup key
+CREATE UNIQUE INDEX idx_job_queue_dedup ON job_queue(dedup_key)
+    WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing');
+
+-- Index for expiring idempotency keys
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
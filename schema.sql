@@ -15,7 +15,7 @@ CREATE TABLE job_queue (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     job_type TEXT NOT NULL, -- 'user_created', 'data_analysis', 'email_notification', etc.
     payload TEXT NOT NULL,  -- JSON data to process
-    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'processing', 'completed', 'failed'
+    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'processing', 'completed', 'failed', 'cancelled'
     priority INTEGER DEFAULT 0, -- Higher number = higher priority
     max_retries INTEGER DEFAULT 3,
     retry_count INTEGER DEFAULT 0,
@@ -23,7 +23,32 @@ CREATE TABLE job_queue (
     scheduled_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     started_at DATETIME,
     completed_at DATETIME,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    duration_ms INTEGER, -- How long the processor took, set when processing finishes
+    request_id TEXT, -- X-Request-ID of the HTTP request that enqueued the job
+    dedup_key TEXT, -- Optional; at most one pending or processing job per key
+    worker_id INTEGER -- Worker that claimed the job, when it identified itself
+);
+
+-- Idempotency keys sent with POST /users, mapped to the user they created
+CREATE TABLE idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL -- The key is forgotten after this
+);
+
+-- Queue-wide switches, e.g. name 'paused' with value 'true' stops job claiming
+CREATE TABLE queue_settings (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Last heartbeat of each worker, to tell live workers from dead ones
+CREATE TABLE worker_heartbeats (
+    worker_id INTEGER PRIMARY KEY,
+    last_seen_at DATETIME NOT NULL
 );
 
 -- Index for faster email lookups
@@ -34,4 +59,10 @@ CREATE INDEX idx_users_active ON users(is_active);
 CREATE INDEX idx_job_queue_status ON job_queue(status);
 CREATE INDEX idx_job_queue_type ON job_queue(job_type);
 CREATE INDEX idx_job_queue_scheduled ON job_queue(scheduled_at);
-CREATE INDEX idx_job_queue_priority ON job_queue(priority DESC, scheduled_at);
\ No newline at end of file
+CREATE INDEX idx_job_queue_priority ON job_queue(priority DESC, scheduled_at);
+-- Deduplication: one unhandled job per dedup key
+CREATE UNIQUE INDEX idx_job_queue_dedup ON job_queue(dedup_key)
+    WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing');
+
+-- Index for expiring idempotency keys
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
\ No newline at end of file
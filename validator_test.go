@@ -1,18 +1,41 @@
 package main
 
 import (
+	"openapi-validation-example/pkg/auth"
 	"openapi-validation-example/pkg/validation"
 
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// newUnauthenticatedMiddleware validates against specFile with
+// authentication disabled, as the servers do when no API keys are configured
+func newUnauthenticatedMiddleware(specFile string) (*validation.ValidationMiddleware, error) {
+	return validation.NewValidationMiddlewareWithOptions(specFile, validation.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	})
+}
+
 func TestValidationMiddleware_NewValidationMiddleware(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -57,8 +80,77 @@ func TestValidationMiddleware_NewValidationMiddleware(t *testing.T) {
 	}
 }
 
+func TestValidationMiddleware_SpecCache(t *testing.T) {
+	validation.ClearSpecCache()
+	t.Cleanup(validation.ClearSpecCache)
+
+	original, err := os.ReadFile("openapi.yaml")
+	require.NoError(t, err)
+	specFile := filepath.Join(t.TempDir(), "openapi.yaml")
+	require.NoError(t, os.WriteFile(specFile, original, 0o644))
+	info, err := os.Stat(specFile)
+	require.NoError(t, err)
+
+	// Raise the minimum age to 18 in every schema
+	stricter := bytes.Replace(original, []byte("age:\n          type: integer\n          minimum: 0"), []byte("age:\n          type: integer\n          minimum: 18"), -1)
+	require.NotEqual(t, original, stricter, "spec layout changed; update the replacement")
+
+	postMinor := func(m *validation.ValidationMiddleware) int {
+		e := echo.New()
+		e.Use(m.Validate())
+		e.POST("/users", func(c echo.Context) error {
+			return c.NoContent(http.StatusCreated)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "minor@example.com", "age": 10}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Build many middlewares concurrently from the same file
+	middlewares := make([]*validation.ValidationMiddleware, 20)
+	errs := make([]error, len(middlewares))
+	var wg sync.WaitGroup
+	for i := range middlewares {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			middlewares[i], errs[i] = newUnauthenticatedMiddleware(specFile)
+		}(i)
+	}
+	wg.Wait()
+	for i := range middlewares {
+		require.NoError(t, errs[i])
+		assert.Equal(t, http.StatusCreated, postMinor(middlewares[i]))
+	}
+
+	// Changed contents with the same modification time: the cached spec is reused
+	require.NoError(t, os.WriteFile(specFile, stricter, 0o644))
+	require.NoError(t, os.Chtimes(specFile, info.ModTime(), info.ModTime()))
+	cached, err := newUnauthenticatedMiddleware(specFile)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, postMinor(cached))
+
+	// ClearSpecCache forces a reload
+	validation.ClearSpecCache()
+	reloaded, err := newUnauthenticatedMiddleware(specFile)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, postMinor(reloaded))
+
+	// So does a new modification time
+	require.NoError(t, os.WriteFile(specFile, original, 0o644))
+	require.NoError(t, os.Chtimes(specFile, info.ModTime().Add(time.Minute), info.ModTime().Add(time.Minute)))
+	modified, err := newUnauthenticatedMiddleware(specFile)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, postMinor(modified))
+
+	// Middlewares built earlier keep the spec they were built with
+	assert.Equal(t, http.StatusBadRequest, postMinor(reloaded))
+}
+
 func TestValidationMiddleware_Validate(t *testing.T) {
-	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(t, err)
 
 	e := echo.New()
@@ -169,7 +261,7 @@ func TestValidationMiddleware_Validate(t *testing.T) {
 }
 
 func TestValidationMiddleware_FlexibleMode(t *testing.T) {
-	middleware, err := validation.NewValidationMiddleware("openapi-flexible.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi-flexible.yaml")
 	require.NoError(t, err)
 
 	e := echo.New()
@@ -219,7 +311,10 @@ func TestValidationMiddleware_FlexibleMode(t *testing.T) {
 }
 
 func TestValidationMiddleware_StrictMode(t *testing.T) {
-	middleware, err := validation.NewValidationMiddleware("openapi-strict.yaml")
+	middleware, err := validation.NewValidationMiddlewareWithOptions("openapi-strict.yaml", validation.Options{
+		AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		ReportUnknownFields: true,
+	})
 	require.NoError(t, err)
 
 	e := echo.New()
@@ -244,7 +339,7 @@ func TestValidationMiddleware_StrictMode(t *testing.T) {
 		{
 			name:           "Invalid with additional properties",
 			body:           `{"email": "strict@example.com", "age": 25, "extra": "property"}`,
-			expectedStatus: http.StatusInternalServerError,
+			expectedStatus: http.StatusUnprocessableEntity,
 			description:    "Should reject additional properties in strict mode",
 		},
 		{
@@ -269,7 +364,7 @@ func TestValidationMiddleware_StrictMode(t *testing.T) {
 }
 
 func TestValidationMiddleware_GetUserValidation(t *testing.T) {
-	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(t, err)
 
 	e := echo.New()
@@ -329,8 +424,184 @@ func TestValidationMiddleware_GetUserValidation(t *testing.T) {
 	}
 }
 
+func TestValidationMiddleware_ValidateMethods(t *testing.T) {
+	middleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		ValidateMethods:    []string{http.MethodPost, "put", http.MethodPatch},
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	})
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(middleware.Validate())
+
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+	e.POST("/users", func(c echo.Context) error {
+		return c.JSON(http.StatusCreated, map[string]string{"status": "created"})
+	})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		description    string
+	}{
+		{
+			name:           "GET with invalid ID is skipped",
+			method:         http.MethodGet,
+			path:           "/users/invalid",
+			expectedStatus: http.StatusOK,
+			description:    "GET is not in ValidateMethods, so path params are not validated",
+		},
+		{
+			name:           "Valid POST",
+			method:         http.MethodPost,
+			path:           "/users",
+			body:           `{"email": "test@example.com", "age": 25}`,
+			expectedStatus: http.StatusCreated,
+			description:    "POST is validated and the body is valid",
+		},
+		{
+			name:           "Invalid POST",
+			method:         http.MethodPost,
+			path:           "/users",
+			body:           `{"email": "test@example.com"}`,
+			expectedStatus: http.StatusBadRequest,
+			description:    "POST is still validated and age is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.body != "" {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			}
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code, tt.description)
+		})
+	}
+
+	// Methods that skip validation are still authenticated, and the default
+	// AuthenticationFunc still fails closed
+	middleware, err = validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		ValidateMethods: []string{http.MethodPost},
+	})
+	require.NoError(t, err)
+	e = echo.New()
+	e.Use(middleware.Validate())
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no authentication configured")
+
+	middleware, err = validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		ValidateMethods:    []string{http.MethodPost},
+		AuthenticationFunc: auth.NewKeySet([]string{"let-me-in"}).AuthenticationFunc(),
+	})
+	require.NoError(t, err)
+	e = echo.New()
+	e.Use(middleware.Validate())
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/invalid", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/invalid", nil)
+	req.Header.Set("X-API-Key", "let-me-in")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "a valid key passes and the path parameter is not validated")
+}
+
+func TestValidationMiddleware_ReportDuration(t *testing.T) {
+	newApp := func(opts validation.Options) *echo.Echo {
+		opts.AuthenticationFunc = openapi3filter.NoopAuthenticationFunc
+		middleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", opts)
+		require.NoError(t, err)
+
+		e := echo.New()
+		e.Use(middleware.Validate())
+		e.POST("/users", func(c echo.Context) error {
+			return c.JSON(http.StatusCreated, map[string]string{"status": "created"})
+		})
+		e.GET("/unknown", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		return e
+	}
+	post := func(e *echo.Echo, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Off by default
+	rec := post(newApp(validation.Options{}), `{"email": "test@example.com", "age": 25}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(validation.ValidationDurationHeader))
+
+	e := newApp(validation.Options{ReportDuration: true})
+	for _, body := range []string{`{"email": "test@example.com", "age": 25}`, `{"email": "test@example.com"}`} {
+		rec := post(e, body)
+		header := rec.Header().Get(validation.ValidationDurationHeader)
+		require.NotEmpty(t, header, "valid and rejected requests both report the duration")
+		ms, err := strconv.ParseFloat(header, 64)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, ms, 0.0)
+	}
+
+	// Requests outside the spec are not validated, so there is nothing to report
+	req := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get(validation.ValidationDurationHeader))
+}
+
+func TestValidationMiddleware_AnyHost(t *testing.T) {
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(middleware.Validate())
+
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	// The spec's servers entry names localhost:8080, but requests are
+	// validated whatever host they were sent to
+	for _, target := range []string{
+		"http://localhost:8080/users/invalid",
+		"http://api.example.com/users/invalid",
+		"/users/invalid",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
+
 func TestValidationMiddleware_ContentTypeValidation(t *testing.T) {
-	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(t, err)
 
 	e := echo.New()
@@ -361,12 +632,26 @@ func TestValidationMiddleware_ContentTypeValidation(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			description:    "Should accept application/json with charset",
 		},
+		{
+			name:           "Form-encoded",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "email=test%40example.com&age=25",
+			expectedStatus: http.StatusOK,
+			description:    "Should accept form-encoded bodies, which the spec declares",
+		},
+		{
+			name:           "Invalid form-encoded",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "email=test%40example.com&age=-1",
+			expectedStatus: http.StatusBadRequest,
+			description:    "Should validate form fields against the schema",
+		},
 		{
 			name:           "Invalid content type",
 			contentType:    "text/plain",
 			body:           `{"email": "test@example.com", "age": 25}`,
-			expectedStatus: http.StatusBadRequest,
-			description:    "Should reject non-JSON content type",
+			expectedStatus: http.StatusUnsupportedMediaType,
+			description:    "Should reject content types the spec doesn't declare",
 		},
 		{
 			name:           "Missing content type",
@@ -393,7 +678,7 @@ func TestValidationMiddleware_ContentTypeValidation(t *testing.T) {
 }
 
 func TestValidationMiddleware_EdgeCases(t *testing.T) {
-	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(t, err)
 
 	e := echo.New()
@@ -448,6 +733,93 @@ func TestValidationMiddleware_EdgeCases(t *testing.T) {
 	}
 }
 
+func TestValidationMiddleware_ErrorResponseMatchesSpec(t *testing.T) {
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(middleware.Validate())
+
+	e.POST("/users", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	body := `{"email": "envelope@example.com", "age": -1}`
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set(echo.HeaderContentType, "application/json")
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Validate the error response against the 4XX response declared in the spec
+	ctx := context.Background()
+	doc, err := openapi3.NewLoader().LoadFromFile("openapi.yaml")
+	require.NoError(t, err)
+	doc.Servers = nil
+
+	router, err := gorillamux.NewRouter(doc)
+	require.NoError(t, err)
+
+	specReq := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	specReq.Header.Set(echo.HeaderContentType, "application/json")
+	route, pathParams, err := router.FindRoute(specReq)
+	require.NoError(t, err)
+
+	responseValidationInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    specReq,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status: rec.Code,
+		Header: rec.Header(),
+		Body:   io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+	}
+	assert.NoError(t, openapi3filter.ValidateResponse(ctx, responseValidationInput))
+}
+
+func TestValidationMiddleware_SelfTest(t *testing.T) {
+	for _, specFile := range []string{"openapi.yaml", "openapi-flexible.yaml", "openapi-strict.yaml"} {
+		t.Run(specFile, func(t *testing.T) {
+			var logs bytes.Buffer
+			ok := validation.SelfTest(specFile, log.New(&logs, "", 0))
+
+			assert.True(t, ok)
+			assert.Empty(t, logs.String())
+		})
+	}
+
+	t.Run("Mismatched spec", func(t *testing.T) {
+		spec, err := os.ReadFile("openapi.yaml")
+		require.NoError(t, err)
+
+		// Drift the User schema: age becomes a string and a new property appears
+		mismatched := strings.Replace(string(spec), `        age:
+          type: integer
+          minimum: 0
+          description: User age
+        name:`, `        age:
+          type: string
+          description: User age
+        nickname:
+          type: string
+        name:`, 1)
+		require.NotEqual(t, string(spec), mismatched)
+
+		specFile := filepath.Join(t.TempDir(), "openapi-mismatched.yaml")
+		require.NoError(t, os.WriteFile(specFile, []byte(mismatched), 0o644))
+
+		var logs bytes.Buffer
+		ok := validation.SelfTest(specFile, log.New(&logs, "", 0))
+
+		assert.False(t, ok)
+		assert.Contains(t, logs.String(), "WARNING")
+		assert.Contains(t, logs.String(), `spec property "nickname" is missing from the generated type`)
+		assert.Contains(t, logs.String(), "User: generated sample does not match the spec")
+	})
+}
+
 // Helper function to generate long strings for testing
 func generateLongString(length int) string {
 	result := make([]byte, length)
@@ -459,7 +831,7 @@ func generateLongString(length int) string {
 
 // Benchmark validation performance
 func BenchmarkValidationMiddleware_ValidRequest(b *testing.B) {
-	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(b, err)
 
 	e := echo.New()
@@ -481,7 +853,7 @@ func BenchmarkValidationMiddleware_ValidRequest(b *testing.B) {
 }
 
 func BenchmarkValidationMiddleware_InvalidRequest(b *testing.B) {
-	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	middleware, err := newUnauthenticatedMiddleware("openapi.yaml")
 	require.NoError(b, err)
 
 	e := echo.New()
@@ -500,4 +872,384 @@ func BenchmarkValidationMiddleware_InvalidRequest(b *testing.B) {
 
 		e.ServeHTTP(rec, req)
 	}
-}
\ No newline at end of file
+}
+func TestValidationMiddleware_AuthenticationFunc(t *testing.T) {
+	newApp := func(middleware *validation.ValidationMiddleware) *echo.Echo {
+		e := echo.New()
+		e.Use(middleware.Validate())
+		e.GET("/users", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+		})
+		return e
+	}
+
+	// Without an AuthenticationFunc the spec's security requirement fails closed
+	middleware, err := validation.NewValidationMiddleware("openapi.yaml")
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	newApp(middleware).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no authentication configured")
+
+	// A custom func sees the scheme and decides
+	var schemes []string
+	middleware, err = validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		AuthenticationFunc: func(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
+			schemes = append(schemes, input.SecuritySchemeName)
+			if input.RequestValidationInput.Request.Header.Get(input.SecurityScheme.Name) != "let-me-in" {
+				return errors.New("wrong key")
+			}
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	e := newApp(middleware)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "wrong key")
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("X-API-Key", "let-me-in")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"ApiKeyAuth", "ApiKeyAuth"}, schemes)
+}
+
+func TestValidationMiddleware_RequestContext(t *testing.T) {
+	// Authentication stands in for slow validation: it blocks until the
+	// request's context is done
+	authenticating := make(chan struct{}, 1)
+	middleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+		AuthenticationFunc: func(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
+			authenticating <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	require.NoError(t, err)
+
+	handlerCalled := false
+	handler := middleware.Validate()(func(c echo.Context) error {
+		handlerCalled = true
+		return c.NoContent(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	done := make(chan error, 1)
+	go func() { done <- handler(c) }()
+
+	<-authenticating
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("middleware did not return after the request was cancelled")
+	}
+	assert.False(t, handlerCalled)
+
+	// A request cancelled before it arrives is not validated at all
+	req = httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx)
+	err = handler(echo.New().NewContext(req, httptest.NewRecorder()))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, authenticating)
+	assert.False(t, handlerCalled)
+}
+
+func TestValidationMiddleware_MultiError(t *testing.T) {
+	var fields []string
+	newApp := func(opts validation.Options) *echo.Echo {
+		if opts.AuthenticationFunc == nil {
+			opts.AuthenticationFunc = openapi3filter.NoopAuthenticationFunc
+		}
+		opts.OnValidationError = func(field string) { fields = append(fields, field) }
+		middleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", opts)
+		require.NoError(t, err)
+
+		e := echo.New()
+		e.Use(middleware.Validate())
+		e.POST("/users", func(c echo.Context) error {
+			return c.JSON(http.StatusCreated, map[string]string{"status": "created"})
+		})
+		e.GET("/users", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+		})
+		return e
+	}
+	post := func(e *echo.Echo, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+		return rec, decoded
+	}
+	invalid := `{"age": -1, "name": ""}`
+
+	// Off by default: only the first failure, without details
+	rec, body := post(newApp(validation.Options{}), invalid)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NotContains(t, body, "details")
+	assert.Len(t, fields, 1)
+
+	// Every failing field in one response
+	fields = nil
+	e := newApp(validation.Options{MultiError: true})
+	rec, body = post(e, invalid)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "Request validation failed with 3 errors", body["error"])
+	details, ok := body["details"].([]interface{})
+	require.True(t, ok, "details is a list: %s", rec.Body.String())
+	require.Len(t, details, 3)
+	assert.Equal(t, "Email address format is invalid", details[2], "the missing email, in the existing wording")
+	assert.Contains(t, details[0], "must be at least 0")
+	assert.Contains(t, details[1], `Error in field "/name"`)
+	assert.ElementsMatch(t, []string{"email", "age", "name"}, fields)
+
+	// A single failure is reported the same way, with one detail
+	rec, body = post(e, `{"email": "test@example.com", "age": -1}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, body["error"], "must be at least 0")
+	assert.Len(t, body["details"], 1)
+
+	// Parameters too
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users?limit=0&offset=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Parameter validation failed for 'limit'")
+	assert.Contains(t, rec.Body.String(), "Parameter validation failed for 'offset'")
+
+	// Valid requests pass
+	rec, _ = post(e, `{"email": "test@example.com", "age": 25}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	// Authentication failures still get 401
+	rec, body = post(newApp(validation.Options{MultiError: true, AuthenticationFunc: validation.RejectAllAuthenticationFunc}), invalid)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotContains(t, body, "details")
+}
+
+func TestValidationMiddleware_Formats(t *testing.T) {
+	spec, err := os.ReadFile("openapi.yaml")
+	require.NoError(t, err)
+
+	// Give UserRequest a phone number, a device UUID and a custom-format nickname
+	withFormats := strings.Replace(string(spec), `      additionalProperties: false
+      properties:
+        email:
+          type: string
+          format: email
+          description: User email address
+        age:
+          type: integer
+          minimum: 0
+          description: User age
+        name:`, `      additionalProperties: false
+      properties:
+        email:
+          type: string
+          format: email
+          description: User email address
+        age:
+          type: integer
+          minimum: 0
+          description: User age
+        phone:
+          type: string
+          format: phone
+        device_id:
+          type: string
+          format: uuid
+        nickname:
+          type: string
+          format: test-lowercase
+        name:`, 1)
+	specFile := filepath.Join(t.TempDir(), "openapi-formats.yaml")
+	require.NoError(t, os.WriteFile(specFile, []byte(withFormats), 0o644))
+
+	lowercase := func(value string) error {
+		if value != strings.ToLower(value) {
+			return errors.New("not lowercase")
+		}
+		return nil
+	}
+	newApp := func(formats map[string]validation.FormatFunc) *echo.Echo {
+		middleware, err := validation.NewValidationMiddlewareWithOptions(specFile, validation.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			Formats:            formats,
+		})
+		require.NoError(t, err)
+
+		e := echo.New()
+		e.Use(middleware.Validate())
+		e.POST("/users", func(c echo.Context) error {
+			return c.NoContent(http.StatusCreated)
+		})
+		return e
+	}
+	post := func(e *echo.Echo, fields string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email": "test@example.com", "age": 25, `+fields+`}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Registering the same format from several middlewares at once is safe
+	apps := make([]*echo.Echo, 10)
+	var wg sync.WaitGroup
+	for i := range apps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			apps[i] = newApp(map[string]validation.FormatFunc{"test-lowercase": lowercase})
+		}(i)
+	}
+	wg.Wait()
+	e := apps[0]
+
+	tests := []struct {
+		name         string
+		fields       string
+		expectedCode int
+		expectedBody string
+	}{
+		{"Valid formats", `"phone": "+14155550123", "device_id": "123e4567-e89b-12d3-a456-426614174000", "nickname": "ace"`, http.StatusCreated, ""},
+		{"Phone with punctuation", `"phone": "(415) 555-0123"`, http.StatusBadRequest, "not an E.164 phone number"},
+		{"Phone without country code", `"phone": "4155550123"`, http.StatusBadRequest, `field \"/phone\"`},
+		{"Invalid UUID", `"device_id": "123e4567"`, http.StatusBadRequest, "not a UUID"},
+		{"Custom format", `"nickname": "Ace"`, http.StatusBadRequest, "not lowercase"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(e, tt.fields)
+			assert.Equal(t, tt.expectedCode, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tt.expectedBody)
+		})
+	}
+
+	// Formats are process-global: registering a name again replaces its
+	// check for every middleware
+	newApp(map[string]validation.FormatFunc{"test-lowercase": func(string) error { return nil }})
+	assert.Equal(t, http.StatusCreated, post(e, `"nickname": "Ace"`).Code)
+}
+
+func TestValidationMiddleware_RequireRequestBody(t *testing.T) {
+	newApp := func(requireBody bool) *echo.Echo {
+		middleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			RequireRequestBody: requireBody,
+		})
+		require.NoError(t, err)
+
+		e := echo.New()
+		e.Use(middleware.Validate())
+		e.POST("/users", func(c echo.Context) error {
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return err
+			}
+			return c.String(http.StatusCreated, string(body))
+		})
+		e.GET("/users", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		return e
+	}
+	post := func(e *echo.Echo, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	required := newApp(true)
+	optional := newApp(false)
+
+	for _, body := range []string{"", "  \n\t", "{}", " { }\n"} {
+		rec := post(required, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "body %q", body)
+		assert.JSONEq(t, `{"error": "request body is required"}`, rec.Body.String(), "body %q", body)
+
+		// Off, validation reports whatever it finds first
+		rec = post(optional, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "body %q", body)
+		assert.NotContains(t, rec.Body.String(), validation.BodyRequiredMessage, "body %q", body)
+	}
+
+	// Bodies with content are validated as usual and reach the handler intact
+	rec := post(required, `{"age": 25}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NotContains(t, rec.Body.String(), validation.BodyRequiredMessage)
+
+	rec = post(required, `{"email": "test@example.com", "age": 25}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"email": "test@example.com", "age": 25, "is_active": true}`, rec.Body.String())
+
+	// Operations without a request body are unaffected
+	rec = httptest.NewRecorder()
+	required.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestValidationMiddleware_ValidateResponses(t *testing.T) {
+	newApp := func() *echo.Echo {
+		middleware, err := validation.NewValidationMiddlewareWithOptions("openapi.yaml", validation.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			ValidateResponses:  validation.ResponseValidationFromEnv(),
+		})
+		require.NoError(t, err)
+
+		e := echo.New()
+		e.Use(middleware.Validate())
+		// A handler whose response breaks the spec: a user needs id, email and age
+		e.GET("/users/:id", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{"id": 1})
+		})
+		e.GET("/users", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{"users": []interface{}{}, "total": 0})
+		})
+		return e
+	}
+	get := func(e *echo.Echo, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Staging validates responses: the broken one becomes a 500
+	t.Setenv("VALIDATE_RESPONSES", "")
+	t.Setenv("APP_ENV", "staging")
+	e := newApp()
+	rec := get(e, "/users/1")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Response validation failed")
+	rec = get(e, "/users")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"users": [], "total": 0}`, rec.Body.String())
+
+	// Production skips response validation
+	t.Setenv("APP_ENV", "prod")
+	rec = get(newApp(), "/users/1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id": 1}`, rec.Body.String())
+
+	// VALIDATE_RESPONSES overrides the environment
+	t.Setenv("VALIDATE_RESPONSES", "true")
+	rec = get(newApp(), "/users/1")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	t.Setenv("APP_ENV", "dev")
+	t.Setenv("VALIDATE_RESPONSES", "false")
+	rec = get(newApp(), "/users/1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
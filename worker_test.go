@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"openapi-validation-example/db"
+	"openapi-validation-example/internal/worker"
+	"openapi-validation-example/pkg/jobs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowProcessor signals when a job starts and takes a while to finish
+type slowProcessor struct {
+	started chan int64
+}
+
+func (p *slowProcessor) JobType() jobs.JobType {
+	return jobs.JobDataAnalysis
+}
+
+func (p *slowProcessor) Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error {
+	p.started <- job.ID
+	time.Sleep(300 * time.Millisecond)
+	return nil
+}
+
+func TestWorkerManager_Shutdown(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "shutdown"}, 0)
+	require.NoError(t, err)
+
+	processor := &slowProcessor{started: make(chan int64, 1)}
+	manager := worker.NewManager(jobQueue, map[jobs.JobType]worker.JobProcessor{
+		jobs.JobDataAnalysis: processor,
+	}, 2, 1)
+	manager.Start()
+
+	var tasksExited atomic.Int32
+	for i := 0; i < 2; i++ {
+		manager.Go(func(done <-chan struct{}) {
+			<-done
+			tasksExited.Add(1)
+		})
+	}
+
+	// Shut down while the job is being processed
+	select {
+	case id := <-processor.started:
+		assert.Equal(t, job.ID, id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not picked up")
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		manager.Shutdown()
+		close(shutdownDone)
+	}()
+
+	select {
+	case <-shutdownDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return; a worker or background task did not exit")
+	}
+
+	// Every background task has exited and the in-flight job was finished
+	assert.Equal(t, int32(2), tasksExited.Load())
+	completed, err := jobQueue.ListJobs(context.Background(), "completed", 10)
+	require.NoError(t, err)
+	require.Len(t, completed, 1)
+	assert.Equal(t, job.ID, completed[0].ID)
+
+	// Shutting down again is a no-op
+	manager.Shutdown()
+}
+
+func TestWorker_MemoryQueue(t *testing.T) {
+	// A queue without batch claiming, heartbeats or durations
+	jobQueue := jobs.NewMemoryJobQueue()
+	var queue jobs.Queue = jobQueue
+
+	first, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "first"}, 0)
+	require.NoError(t, err)
+	second, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "second"}, 0)
+	require.NoError(t, err)
+
+	processor := &slowProcessor{started: make(chan int64, 2)}
+	manager := worker.NewManager(queue, map[jobs.JobType]worker.JobProcessor{
+		jobs.JobDataAnalysis: processor,
+	}, 1, 2)
+	manager.Start()
+
+	// Both jobs are claimed in one batch of GetNextJob calls
+	var started []int64
+	for len(started) < 2 {
+		select {
+		case id := <-processor.started:
+			started = append(started, id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("jobs were not picked up")
+		}
+	}
+	assert.ElementsMatch(t, []int64{first.ID, second.ID}, started)
+	manager.Shutdown()
+
+	stats, err := queue.GetJobStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.CompletedCount)
+}
+
+func TestJobLogger_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	worker.JobLogger(logger, &db.JobQueue{
+		ID:        7,
+		JobType:   string(jobs.JobUserCreated),
+		RequestID: sql.NullString{String: "req-abc", Valid: true},
+	}).Info("Processing job")
+	assert.Contains(t, buf.String(), "job_id=7 job_type=user_created request_id=req-abc")
+
+	buf.Reset()
+	worker.JobLogger(logger, &db.JobQueue{ID: 8, JobType: string(jobs.JobDataAnalysis)}).Info("Processing job")
+	assert.Contains(t, buf.String(), "job_id=8 job_type=data_analysis")
+	assert.NotContains(t, buf.String(), "request_id")
+}
+
+// deadlineProcessor reports how much time each job had left at the start of
+// Process. With block set it runs until the job's context is done.
+type deadlineProcessor struct {
+	jobType   jobs.JobType
+	remaining chan time.Duration
+	block     bool
+}
+
+func (p *deadlineProcessor) JobType() jobs.JobType {
+	return p.jobType
+}
+
+func (p *deadlineProcessor) Process(ctx context.Context, job *db.JobQueue, payload jobs.JobPayload) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		p.remaining <- 0
+		return nil
+	}
+	p.remaining <- time.Until(deadline)
+	if p.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestWorker_JobTypeTimeouts(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	_, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, jobs.JobPayload{Message: "quick", Recipients: []string{"user@example.com"}}, 0)
+	require.NoError(t, err)
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "slow"}, 0)
+	require.NoError(t, err)
+	userID := int64(1)
+	_, err = jobQueue.EnqueueJob(context.Background(), jobs.JobUserCreated, jobs.JobPayload{Message: "default", UserID: &userID}, 0)
+	require.NoError(t, err)
+
+	processors := map[jobs.JobType]worker.JobProcessor{}
+	for _, jobType := range []jobs.JobType{jobs.JobEmailNotification, jobs.JobDataAnalysis, jobs.JobUserCreated} {
+		processors[jobType] = &deadlineProcessor{jobType: jobType, remaining: make(chan time.Duration, 1)}
+	}
+	manager := worker.NewManager(jobQueue, processors, 1, 3)
+	manager.SetJobTypeConfigs(map[jobs.JobType]worker.JobTypeConfig{
+		jobs.JobEmailNotification: {Timeout: 30 * time.Second},
+		jobs.JobDataAnalysis:      {Timeout: 10 * time.Minute},
+	})
+	manager.Start()
+	defer manager.Shutdown()
+
+	// Each job's deadline is its type's timeout from when it started
+	for jobType, timeout := range map[jobs.JobType]time.Duration{
+		jobs.JobEmailNotification: 30 * time.Second,
+		jobs.JobDataAnalysis:      10 * time.Minute,
+		jobs.JobUserCreated:       worker.DefaultJobTimeout,
+	} {
+		select {
+		case remaining := <-processors[jobType].(*deadlineProcessor).remaining:
+			assert.LessOrEqual(t, remaining, timeout, jobType)
+			assert.Greater(t, remaining, timeout-time.Second, jobType)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s job was not picked up", jobType)
+		}
+	}
+}
+
+func TestWorker_JobTimeoutFailsJob(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	job, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "stuck"}, 0)
+	require.NoError(t, err)
+
+	processor := &deadlineProcessor{jobType: jobs.JobDataAnalysis, remaining: make(chan time.Duration, 1), block: true}
+	manager := worker.NewManager(jobQueue, map[jobs.JobType]worker.JobProcessor{
+		jobs.JobDataAnalysis: processor,
+	}, 1, 1)
+	manager.SetJobTypeConfigs(map[jobs.JobType]worker.JobTypeConfig{
+		jobs.JobDataAnalysis: {Timeout: 50 * time.Millisecond},
+	})
+	manager.Start()
+
+	select {
+	case <-processor.remaining:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not picked up")
+	}
+	manager.Shutdown()
+
+	// The timed-out job is failed and scheduled for a retry
+	failed, err := jobQueue.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", failed.Status)
+	assert.Equal(t, int64(1), failed.RetryCount.Int64)
+	assert.Contains(t, failed.ErrorMessage.String, "job timed out after 50ms")
+}
+
+func TestWorker_DeferUnknownJobTypes(t *testing.T) {
+	jobQueue := setupTestJobQueue(t)
+
+	// The email processor is not registered yet, e.g. mid-deploy
+	unknown, err := jobQueue.EnqueueJob(context.Background(), jobs.JobEmailNotification, jobs.JobPayload{Recipients: []string{"user@example.com"}}, 9)
+	require.NoError(t, err)
+	known, err := jobQueue.EnqueueJob(context.Background(), jobs.JobDataAnalysis, jobs.JobPayload{Message: "registered"}, 0)
+	require.NoError(t, err)
+
+	processor := &deadlineProcessor{jobType: jobs.JobDataAnalysis, remaining: make(chan time.Duration, 1)}
+	manager := worker.NewManager(jobQueue, map[jobs.JobType]worker.JobProcessor{
+		jobs.JobDataAnalysis: processor,
+	}, 1, 1)
+	manager.SetDeferUnknownJobTypes(time.Hour)
+	manager.Start()
+
+	// The higher-priority unknown job is claimed first
+	select {
+	case <-processor.remaining:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not picked up")
+	}
+	manager.Shutdown()
+
+	job, err := jobQueue.GetJob(context.Background(), unknown.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", job.Status, "not failed")
+	assert.Equal(t, int64(0), job.RetryCount.Int64, "no retry used up")
+	assert.Equal(t, "No processor for job type: email_notification", job.ErrorMessage.String)
+	assert.True(t, job.ScheduledAt.Time.After(time.Now().Add(50*time.Minute)), "due again after the delay")
+
+	job, err = jobQueue.GetJob(context.Background(), known.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "completed", job.Status)
+
+	// Once due, the deferred job is claimed again like any other
+	jobQueue.SetClock(&fakeClock{now: time.Now().Add(2 * time.Hour)})
+	next, err := jobQueue.GetNextJob(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, unknown.ID, next.ID)
+}
+
+func TestParseJobTimeouts(t *testing.T) {
+	configs, err := worker.ParseJobTimeouts("email_notification=30s, data_analysis=10m")
+	require.NoError(t, err)
+	assert.Equal(t, map[jobs.JobType]worker.JobTypeConfig{
+		jobs.JobEmailNotification: {Timeout: 30 * time.Second},
+		jobs.JobDataAnalysis:      {Timeout: 10 * time.Minute},
+	}, configs)
+
+	for _, value := range []string{"email_notification", "email_notification=soon", "email_notification=0s"} {
+		_, err := worker.ParseJobTimeouts(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLogJobStats(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	worker.LogJobStats(context.Background(), logger, func(ctx context.Context) (*db.GetJobStatsRow, error) {
+		return nil, errors.New("database is locked")
+	})
+	assert.Contains(t, buf.String(), `level=WARN msg="Failed to get job stats" error="database is locked"`)
+
+	buf.Reset()
+	worker.LogJobStats(context.Background(), logger, func(ctx context.Context) (*db.GetJobStatsRow, error) {
+		return &db.GetJobStatsRow{PendingCount: 3, FailedCount: 1}, nil
+	})
+	assert.Contains(t, buf.String(), `level=INFO msg="Job stats" pending=3 processing=0 completed=0 failed=1 cancelled=0`)
+}